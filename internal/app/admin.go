@@ -0,0 +1,227 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"log"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/erobx/csupgrade-go-api/pkg/pricing"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	adminPageSize    = 50
+	adminMaxPageSize = 200
+)
+
+func (s *Server) searchUsers() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit, offset := Pagination(c, adminPageSize, adminMaxPageSize)
+
+		users, err := s.adminService.SearchUsers(c.Query("q"), limit, offset)
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		return c.JSON(fiber.Map{"users": users})
+	}
+}
+
+// Users whose cached balance no longer matches their ledger
+func (s *Server) getBalanceDrift() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		drift, err := s.ledgerService.FindDrift()
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"drift": drift})
+	}
+}
+
+func (s *Server) adminGetUser() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, err := s.adminService.GetUser(c.Params("userId"))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(user)
+	}
+}
+
+func (s *Server) setRole() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		roleRequest := new(api.SetRoleRequest)
+
+		if err := c.BodyParser(roleRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		err := s.adminService.SetRole(GetUserIDFromClaims(c), c.Params("userId"), roleRequest.Role)
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func (s *Server) adjustBalance() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		balanceRequest := new(api.AdjustBalanceRequest)
+
+		if err := c.BodyParser(balanceRequest); err != nil {
+			if errors.Is(err, api.ErrUnsupportedCurrency) {
+				return api.UnsupportedCurrency("delta")
+			}
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		balance, err := s.adminService.AdjustBalance(GetUserIDFromClaims(c), c.Params("userId"),
+			balanceRequest)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"balance": balance})
+	}
+}
+
+func (s *Server) banUser() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		banRequest := new(api.BanRequest)
+
+		if err := c.BodyParser(banRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		err := s.adminService.BanUser(GetUserIDFromClaims(c), c.Params("userId"), banRequest.Reason)
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func (s *Server) unbanUser() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := s.adminService.UnbanUser(GetUserIDFromClaims(c), c.Params("userId"))
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func (s *Server) forceCompleteTradeup() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := s.adminService.CompleteTradeup(GetUserIDFromClaims(c), c.Params("tradeupId"))
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func (s *Server) cancelTradeup() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := s.adminService.CancelTradeup(GetUserIDFromClaims(c), c.Params("tradeupId"))
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func (s *Server) updateCrate() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		crateUpdate := new(api.CrateUpdate)
+
+		if err := c.BodyParser(crateUpdate); err != nil {
+			if errors.Is(err, api.ErrUnsupportedCurrency) {
+				return api.UnsupportedCurrency("cost")
+			}
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		err := s.adminService.UpdateCrate(GetUserIDFromClaims(c), c.Params("crateId"), crateUpdate)
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func (s *Server) setCrateSkins() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		skinsRequest := new(api.CrateSkinsRequest)
+
+		if err := c.BodyParser(skinsRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		err := s.adminService.SetCrateSkins(GetUserIDFromClaims(c), c.Params("crateId"),
+			skinsRequest)
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// The body is the price list itself, sent as text/csv or application/json
+func (s *Server) importPrices() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		format := pricing.FormatOf(c.Get(fiber.HeaderContentType))
+		if format == "" {
+			return api.ErrInvalidPriceList
+		}
+
+		imported, err := s.adminService.ImportPrices(GetUserIDFromClaims(c), bytes.NewReader(c.Body()),
+			format)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"imported": imported})
+	}
+}
+
+func (s *Server) clearImportedPrices() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := s.adminService.ClearImportedPrices(GetUserIDFromClaims(c))
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func (s *Server) getAuditLog() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit, offset := Pagination(c, adminPageSize, adminMaxPageSize)
+
+		entries, err := s.adminService.GetAuditLog(limit, offset)
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		return c.JSON(fiber.Map{"entries": entries})
+	}
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"log"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/gofiber/fiber/v2"
+)
+
+// The user's current seed pair with the server seed hidden
+func (s *Server) getSeed() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		seed, err := s.fairnessService.GetSeed(GetUserIDFromClaims(c))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(seed)
+	}
+}
+
+// Reveals the current server seed and starts a new pair with the given client
+// seed
+func (s *Server) rotateSeed() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		seedRequest := new(api.ClientSeedRequest)
+
+		if err := c.BodyParser(seedRequest); err != nil {
+			log.Println(err)
+			return malformedBody(c)
+		}
+
+		rotation, err := s.fairnessService.RotateSeed(GetUserIDFromClaims(c), seedRequest.ClientSeed)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(rotation)
+	}
+}
+
+func (s *Server) getRolls() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit, offset := Pagination(c, 20, 100)
+
+		rolls, err := s.fairnessService.GetRolls(GetUserIDFromClaims(c), limit, offset)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"rolls":  rolls,
+			"limit":  limit,
+			"offset": offset,
+		})
+	}
+}
+
+// Recomputes a roll from its revealed seeds so the user can compare it with
+// what they got
+func (s *Server) verifyRoll() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		verifyRequest := new(api.VerifyRollRequest)
+
+		if err := c.BodyParser(verifyRequest); err != nil {
+			log.Println(err)
+			return malformedBody(c)
+		}
+
+		verification, err := s.fairnessService.Verify(GetUserIDFromClaims(c), verifyRequest.RollID)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(verification)
+	}
+}
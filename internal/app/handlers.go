@@ -1,11 +1,14 @@
 package app
 
 import (
+	"errors"
+	"io"
 	"log"
+	"net/url"
 	"strconv"
-	"time"
 
 	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/erobx/csupgrade-go-api/pkg/steam"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
@@ -18,16 +21,24 @@ func (s *Server) register() fiber.Handler {
 
 		if err := c.BodyParser(newUserRequest); err != nil {
 			log.Println(err)
-			return c.SendStatus(fiber.StatusBadRequest)
+			return malformedBody(c)
+		}
+
+		if err := s.validator.ValidateNewUser(newUserRequest); err != nil {
+			return validationProblem(c, err)
 		}
 
 		userID, err := s.userService.New(newUserRequest)
 		if err != nil {
-			log.Println(err)
-			return c.SendStatus(fiber.StatusInternalServerError)
+			return err
 		}
 		log.Printf("Created new user %s\n", userID)
 
+		err = s.accountService.SendVerification(userID)
+		if err != nil {
+			s.logger.Error("failed to send verification email", "user", userID, "error", err)
+		}
+
 		user, err := s.userService.GetUser(userID)
 		if err != nil {
 			log.Println(err)
@@ -40,23 +51,17 @@ func (s *Server) register() fiber.Handler {
             return c.SendStatus(fiber.StatusInternalServerError)
         }
 
-		claims := jwt.MapClaims{
-			"id":                  userID,
-			"email":               user.Email,
-			"refreshTokenVersion": user.RefreshTokenVersion,
-			"exp":                 time.Now().Add(time.Hour * 23).Unix(),
-		}
-
-		t, err := s.issueNewToken(claims)
+		t, refreshToken, err := s.issueTokens(c, user)
 		if err != nil {
-			log.Printf("token.SignedString: %v", err)
+			log.Printf("issueTokens: %v", err)
 			return c.SendStatus(fiber.StatusInternalServerError)
 		}
 
 		return c.JSON(fiber.Map{
-			"user": user,
-            "inventory": inv,
-			"jwt":  t,
+			"user":         user,
+			"inventory":    inv,
+			"jwt":          t,
+			"refreshToken": refreshToken,
 		})
 	}
 }
@@ -66,37 +71,310 @@ func (s *Server) login() fiber.Handler {
 		newLoginRequest := new(api.NewLoginRequest)
 
 		if err := c.BodyParser(newLoginRequest); err != nil {
+			log.Println(err)
+			return malformedBody(c)
+		}
+
+		if err := s.validator.ValidateLogin(newLoginRequest); err != nil {
+			return validationProblem(c, err)
+		}
+
+		user, inv, err := s.userService.Login(newLoginRequest, ClientIP(c))
+		if err != nil {
+			return err
+		}
+
+		return s.completeLogin(c, user, inv)
+	}
+}
+
+// Second step of logging in for users with 2FA
+func (s *Server) loginTwoFactor() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		twoFactorRequest := new(api.TwoFactorLoginRequest)
+
+		if err := c.BodyParser(twoFactorRequest); err != nil {
 			log.Println(err)
 			return c.SendStatus(fiber.StatusBadRequest)
 		}
 
-		user, inv, err := s.userService.Login(newLoginRequest)
+		userID, challengeID, err := s.parseChallengeToken(twoFactorRequest.ChallengeToken)
 		if err != nil {
 			log.Println(err)
 			return c.SendStatus(fiber.StatusUnauthorized)
 		}
 
-		claims := jwt.MapClaims{
-			"id":                  user.ID,
-			"email":               user.Email,
-			"refreshTokenVersion": user.RefreshTokenVersion,
-			"exp":                 time.Now().Add(time.Hour * 23).Unix(),
+		// the per-IP limit alone lets a challenge be guessed at from many IPs
+		if err := s.takeChallengeAttempt(challengeID); err != nil {
+			s.logger.Info("2fa challenge exhausted", "user", userID)
+			return err
+		}
+
+		err = s.twoFactorService.Verify(userID, twoFactorRequest.Code)
+		if err != nil {
+			return err
+		}
+
+		user, err := s.userService.GetUser(userID)
+		if err != nil {
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		if user.BannedAt != nil {
+			return c.SendStatus(fiber.StatusForbidden)
+		}
+
+		inv, err := s.userService.GetInventory(userID)
+		if err != nil {
+			s.logger.Error("failed to load inventory", "user", userID)
+			return c.SendStatus(fiber.StatusInternalServerError)
 		}
 
-		t, err := s.issueNewToken(claims)
+		return s.sendTokens(c, user, inv)
+	}
+}
+
+// Users with 2FA get a challenge token to trade for real tokens at
+// /auth/login/2fa, everyone else is logged in straight away
+func (s *Server) completeLogin(c *fiber.Ctx, user api.User, inv api.Inventory) error {
+	if user.BannedAt != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error":  api.ErrAccountBanned.Error(),
+			"reason": user.BanReason,
+		})
+	}
+
+	if user.TwoFactorEnabled {
+		challenge, err := s.issueChallengeToken(user)
+		if err != nil {
+			log.Printf("issueChallengeToken: %v", err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		return c.JSON(fiber.Map{
+			"twoFactorRequired": true,
+			"challengeToken":    challenge,
+		})
+	}
+
+	return s.sendTokens(c, user, inv)
+}
+
+func (s *Server) sendTokens(c *fiber.Ctx, user api.User, inv api.Inventory) error {
+	t, refreshToken, err := s.issueTokens(c, user)
+	if err != nil {
+		log.Printf("issueTokens: %v", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	return c.JSON(fiber.Map{
+		"user":         user,
+		"inventory":    inv,
+		"jwt":          t,
+		"refreshToken": refreshToken,
+	})
+}
+
+// Exchanges a refresh token for a new access token and rotated refresh token
+func (s *Server) refresh() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		refreshRequest := new(api.RefreshRequest)
+
+		if err := c.BodyParser(refreshRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		user, refreshToken, session, err := s.sessionService.Refresh(refreshRequest.RefreshToken,
+			ClientIP(c))
+		if err != nil {
+			return err
+		}
+
+		t, err := s.issueAccessToken(user, session.ID)
 		if err != nil {
 			log.Printf("token.SignedString: %v", err)
 			return c.SendStatus(fiber.StatusInternalServerError)
 		}
 
 		return c.JSON(fiber.Map{
-			"user":      user,
-			"inventory": inv,
-			"jwt":       t,
+			"jwt":          t,
+			"refreshToken": refreshToken,
 		})
 	}
 }
 
+// Sends the user to Steam to sign in. Steam redirects back to the frontend
+// with the assertion in the query string.
+func (s *Server) steamRedirect() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.Redirect(s.steamService.AuthURL(), fiber.StatusFound)
+	}
+}
+
+// Verifies the assertion the frontend forwards in the query string, then logs
+// in (or creates) the user linked to that Steam account
+func (s *Server) steamLogin() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		params, err := url.ParseQuery(string(c.Request().URI().QueryString()))
+		if err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		user, err := s.steamService.Login(params)
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusUnauthorized)
+		}
+
+		inv, err := s.userService.GetInventory(user.ID)
+		if err != nil {
+			s.logger.Error("failed to load inventory", "user", user.ID)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		return s.completeLogin(c, user, inv)
+	}
+}
+
+func (s *Server) verifyEmail() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		verifyRequest := new(api.VerifyEmailRequest)
+
+		if err := c.BodyParser(verifyRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		err := s.accountService.VerifyEmail(verifyRequest.Token)
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// Always accepted so callers can't tell whether the email has an account
+func (s *Server) forgotPassword() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		forgotRequest := new(api.ForgotPasswordRequest)
+
+		if err := c.BodyParser(forgotRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		err := s.accountService.ForgotPassword(forgotRequest.Email)
+		if err != nil {
+			s.logger.Error("failed to send password reset", "error", err)
+		}
+
+		return c.SendStatus(fiber.StatusAccepted)
+	}
+}
+
+func (s *Server) resetPassword() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		resetRequest := new(api.ResetPasswordRequest)
+
+		if err := c.BodyParser(resetRequest); err != nil {
+			log.Println(err)
+			return malformedBody(c)
+		}
+
+		if err := s.validator.ValidatePassword("password", resetRequest.Password); err != nil {
+			return validationProblem(c, err)
+		}
+
+		err := s.accountService.ResetPassword(resetRequest)
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// Revokes the session the refresh token belongs to
+func (s *Server) logout() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		refreshRequest := new(api.RefreshRequest)
+
+		if err := c.BodyParser(refreshRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		err := s.sessionService.Logout(refreshRequest.RefreshToken)
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// Revokes every session of the user the refresh token belongs to
+func (s *Server) logoutAll() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		refreshRequest := new(api.RefreshRequest)
+
+		if err := c.BodyParser(refreshRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		err := s.sessionService.LogoutAll(refreshRequest.RefreshToken)
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func (s *Server) getSessions() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := GetUserIDFromClaims(c)
+		currentID := GetSessionIDFromClaims(c)
+
+		sessions, err := s.sessionService.GetSessions(userID)
+		if err != nil {
+			log.Printf("couldn't get sessions for %s - %v\n", userID, err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == currentID
+		}
+
+		return c.JSON(sessions)
+	}
+}
+
+func (s *Server) revokeSession() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := GetUserIDFromClaims(c)
+		sessionID := c.Params("sessionId")
+
+		err := s.sessionService.RevokeSession(userID, sessionID)
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// Public keys other services use to verify our tokens
+func (s *Server) getJWKS() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return c.JSON(s.keys.JWKS())
+	}
+}
+
 func (s *Server) getUser() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		jwtUser := c.Locals("user").(*jwt.Token)
@@ -114,6 +392,367 @@ func (s *Server) getUser() fiber.Handler {
 	}
 }
 
+func (s *Server) getProfile() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		profile, err := s.profileService.GetProfile(c.Params("username"))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(profile)
+	}
+}
+
+func (s *Server) updatePrivacy() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		settings := new(api.PrivacySettings)
+
+		if err := c.BodyParser(settings); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		err := s.profileService.UpdatePrivacy(GetUserIDFromClaims(c), *settings)
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		return c.JSON(settings)
+	}
+}
+
+func (s *Server) setShowcase() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		showcaseRequest := new(api.ShowcaseRequest)
+
+		if err := c.BodyParser(showcaseRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		err := s.profileService.SetShowcase(GetUserIDFromClaims(c), showcaseRequest.InvIDs)
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// Takes the image from the avatar field of a multipart form
+func (s *Server) uploadAvatar() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		header, err := c.FormFile("avatar")
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		if header.Size > api.MaxAvatarBytes {
+			return sendProblem(c, fiber.StatusRequestEntityTooLarge, api.ErrImageTooLarge.Code,
+				api.ErrImageTooLarge.Message)
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, api.MaxAvatarBytes+1))
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		user, err := s.profileService.SetAvatar(GetUserIDFromClaims(c), data)
+		if err != nil {
+			// the catalog calls these validation errors, but HTTP has better
+			// statuses for them
+			switch {
+			case errors.Is(err, api.ErrImageTooLarge):
+				return sendProblem(c, fiber.StatusRequestEntityTooLarge, api.ErrImageTooLarge.Code, err.Error())
+			case errors.Is(err, api.ErrInvalidImage):
+				return sendProblem(c, fiber.StatusUnsupportedMediaType, api.ErrInvalidImage.Code, err.Error())
+			}
+			return err
+		}
+
+		return c.JSON(fiber.Map{"avatarSrc": user.AvatarSrc})
+	}
+}
+
+func (s *Server) changeUsername() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		usernameRequest := new(api.ChangeUsernameRequest)
+
+		if err := c.BodyParser(usernameRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		user, err := s.accountService.ChangeUsername(GetUserIDFromClaims(c), usernameRequest.Username)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"user": user})
+	}
+}
+
+// Accepted whether or not the new email is free, the change happens once the
+// link mailed to it is opened
+func (s *Server) changeEmail() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		emailRequest := new(api.ChangeEmailRequest)
+
+		if err := c.BodyParser(emailRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		err := s.accountService.ChangeEmail(GetUserIDFromClaims(c), GetSessionIDFromClaims(c),
+			emailRequest)
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusAccepted)
+	}
+}
+
+func (s *Server) confirmEmailChange() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		confirmRequest := new(api.VerifyEmailRequest)
+
+		if err := c.BodyParser(confirmRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		err := s.accountService.ConfirmEmailChange(confirmRequest.Token)
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// Changing the password ends every session, including this one, so the caller
+// gets fresh tokens back
+func (s *Server) changePassword() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := GetUserIDFromClaims(c)
+		passwordRequest := new(api.ChangePasswordRequest)
+
+		if err := c.BodyParser(passwordRequest); err != nil {
+			log.Println(err)
+			return malformedBody(c)
+		}
+
+		err := s.validator.ValidatePassword("newPassword", passwordRequest.NewPassword)
+		if err != nil {
+			return validationProblem(c, err)
+		}
+
+		err = s.accountService.ChangePassword(userID, GetSessionIDFromClaims(c), passwordRequest)
+		if err != nil {
+			return err
+		}
+
+		user, err := s.userService.GetUser(userID)
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		t, refreshToken, err := s.issueTokens(c, user)
+		if err != nil {
+			log.Printf("issueTokens: %v", err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		return c.JSON(fiber.Map{
+			"jwt":          t,
+			"refreshToken": refreshToken,
+		})
+	}
+}
+
+// Sent as a download so browsers save it rather than render it
+func (s *Server) exportAccount() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		export, err := s.accountService.Export(GetUserIDFromClaims(c))
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="csupgrade-export.json"`)
+		return c.JSON(export)
+	}
+}
+
+func (s *Server) deleteAccount() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		deleteRequest := new(api.DeleteAccountRequest)
+
+		if err := c.BodyParser(deleteRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		deleteAfter, err := s.accountService.RequestDeletion(GetUserIDFromClaims(c), GetSessionIDFromClaims(c),
+			deleteRequest)
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"deleteAfter": deleteAfter})
+	}
+}
+
+func (s *Server) cancelAccountDeletion() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := s.accountService.CancelDeletion(GetUserIDFromClaims(c))
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func (s *Server) resendVerification() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := GetUserIDFromClaims(c)
+
+		err := s.accountService.SendVerification(userID)
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		return c.SendStatus(fiber.StatusAccepted)
+	}
+}
+
+func (s *Server) enrollTwoFactor() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := GetUserIDFromClaims(c)
+
+		enrollment, err := s.twoFactorService.Enroll(userID)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(enrollment)
+	}
+}
+
+func (s *Server) confirmTwoFactor() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := GetUserIDFromClaims(c)
+		codeRequest := new(api.TwoFactorCodeRequest)
+
+		if err := c.BodyParser(codeRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		codes, err := s.twoFactorService.Confirm(userID, codeRequest.Code)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"recoveryCodes": codes,
+		})
+	}
+}
+
+func (s *Server) disableTwoFactor() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := GetUserIDFromClaims(c)
+		codeRequest := new(api.TwoFactorCodeRequest)
+
+		if err := c.BodyParser(codeRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		err := s.twoFactorService.Disable(userID, codeRequest.Code)
+		if err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func (s *Server) regenerateRecoveryCodes() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := GetUserIDFromClaims(c)
+		codeRequest := new(api.TwoFactorCodeRequest)
+
+		if err := c.BodyParser(codeRequest); err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		codes, err := s.twoFactorService.RegenerateRecoveryCodes(userID, codeRequest.Code)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"recoveryCodes": codes,
+		})
+	}
+}
+
+func (s *Server) linkSteam() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := GetUserIDFromClaims(c)
+
+		params, err := url.ParseQuery(string(c.Request().URI().QueryString()))
+		if err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		user, err := s.steamService.Link(userID, params)
+		if err != nil {
+			if errors.Is(err, steam.ErrInvalidAssertion) {
+				return fiber.ErrUnauthorized
+			}
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"user": user,
+		})
+	}
+}
+
+func (s *Server) unlinkSteam() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := GetUserIDFromClaims(c)
+
+		user, err := s.steamService.Unlink(userID)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"user": user,
+		})
+	}
+}
+
 func (s *Server) getInventory() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Query("userId")
@@ -141,6 +780,10 @@ func (s *Server) getRecentTradeups() fiber.Handler {
 		userID := c.Params("userId")
 		log.Println("Getting recent tradeups for:", userID)
 
+		if ok, err := s.canView(c, userID, hiddenInventory); !ok {
+			return err
+		}
+
 		recentTradeups, err := s.userService.GetRecentTradeups(userID)
 		if err != nil {
 			log.Printf("couldn't get recent tradeups for %s - %v\n", userID, err)
@@ -151,12 +794,113 @@ func (s *Server) getRecentTradeups() fiber.Handler {
 	}
 }
 
+func hiddenInventory(p api.PrivacySettings) bool { return p.HideInventory }
+func hiddenStats(p api.PrivacySettings) bool     { return p.HideStats }
+
+// Users can always see their own data, others only what the owner's privacy
+// settings allow. The error explains why access was denied.
+func (s *Server) canView(c *fiber.Ctx, userID string, hidden func(api.PrivacySettings) bool) (bool, error) {
+	if userID == GetUserIDFromClaims(c) {
+		return true, nil
+	}
+
+	user, err := s.userService.GetUser(userID)
+	if err != nil {
+		return false, err
+	}
+
+	if hidden(user.Privacy) {
+		return false, api.ErrForbidden
+	}
+
+	return true, nil
+}
+
+// Paginated history of items the user won through tradeups
+func (s *Server) getRecentWinnings() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Params("userId")
+		limit, offset := Pagination(c, 20, 100)
+
+		if ok, err := s.canView(c, userID, hiddenInventory); !ok {
+			return err
+		}
+
+		winnings, err := s.userService.GetRecentWinnings(userID, limit, offset)
+		if err != nil {
+			log.Printf("couldn't get winnings for %s - %v\n", userID, err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		return c.JSON(fiber.Map{
+			"winnings": winnings,
+			"limit":    limit,
+			"offset":   offset,
+		})
+	}
+}
+
+// Paginated ledger entries on the user's balance, newest first
+func (s *Server) getTransactions() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit, offset := Pagination(c, 20, 100)
+
+		transactions, err := s.ledgerService.GetTransactions(GetUserIDFromClaims(c), limit, offset)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"transactions": transactions,
+			"limit":        limit,
+			"offset":       offset,
+		})
+	}
+}
+
 func (s *Server) getUserStats() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Params("userId")
 		log.Println("Retrieving stats for:", userID)
 
-		return nil
+		if ok, err := s.canView(c, userID, hiddenStats); !ok {
+			return err
+		}
+
+		stats, err := s.userService.GetStats(userID)
+		if err != nil {
+			log.Printf("couldn't get stats for %s - %v\n", userID, err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		return c.JSON(stats)
+	}
+}
+
+func (s *Server) getCrates() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		crates, err := s.storeService.GetCrates()
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"crates": crates})
+	}
+}
+
+func (s *Server) getCrate() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		crateID := c.Params("crateId")
+		if _, err := strconv.Atoi(crateID); err != nil {
+			return api.ErrCrateNotFound
+		}
+
+		crate, err := s.storeService.GetCrate(crateID)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(crate)
 	}
 }
 
@@ -164,8 +908,6 @@ func (s *Server) buyCrate() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Query("userId")
 		crateID := c.Query("crateId")
-		amount, _ := strconv.Atoi(c.Query("amount"))
-
 		jwtUserID := GetUserIDFromClaims(c)
 
 		err := s.validator.ValidateUserID(userID, jwtUserID)
@@ -174,16 +916,51 @@ func (s *Server) buyCrate() fiber.Handler {
 			return c.SendStatus(fiber.StatusUnauthorized)
 		}
 
+		err = s.validator.ValidateBuyCrate(crateID, c.Query("amount"))
+		if err != nil {
+			return validationProblem(c, err)
+		}
+		amount := c.QueryInt("amount")
+
 		log.Printf("User %s buying crate %s - %d\n", userID, crateID, amount)
-		updatedBalance, addedItems, err := s.storeService.BuyCrate(crateID, userID, amount)
+		updatedBalance, openings, err := s.storeService.BuyCrate(crateID, userID, amount)
 		if err != nil {
-			log.Println(err)
-			return c.SendStatus(fiber.StatusInternalServerError)
+			return err
 		}
 
 		return c.JSON(fiber.Map{
-			"balance": updatedBalance,
-			"items":   addedItems,
+			"balance":  updatedBalance,
+			"openings": openings,
+		})
+	}
+}
+
+// Sells the items in invIds, everything below a price or everything of a
+// rarity
+func (s *Server) sellItems() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sellRequest := new(api.SellRequest)
+		if err := c.BodyParser(sellRequest); err != nil {
+			if errors.Is(err, api.ErrUnsupportedCurrency) {
+				return api.UnsupportedCurrency("below")
+			}
+			return malformedBody(c)
+		}
+
+		balance, sold, err := s.storeService.SellItems(GetUserIDFromClaims(c), sellRequest)
+		if err != nil {
+			return err
+		}
+
+		total := api.Cents(0)
+		for _, sale := range sold {
+			total = total.Add(sale.Price)
+		}
+
+		return c.JSON(fiber.Map{
+			"balance": balance,
+			"sold":    sold,
+			"total":   total,
 		})
 	}
 }
@@ -194,14 +971,14 @@ func (s *Server) addSkinToTradeup() fiber.Handler {
 		invID := c.Query("invId")
 		userID := GetUserIDFromClaims(c)
 
-		err := s.tradeupService.AddSkinToTradeup(tradeupID, invID, userID)
+		err := s.validator.ValidateTradeupItem(tradeupID, invID)
 		if err != nil {
-			if err == api.ErrMaxContribution {
-				return c.SendStatus(fiber.StatusBadRequest)
-			}
+			return validationProblem(c, err)
+		}
 
-			log.Println(err)
-			return c.SendStatus(fiber.StatusInternalServerError)
+		err = s.tradeupService.AddSkinToTradeup(tradeupID, invID, userID)
+		if err != nil {
+			return err
 		}
 
 		return c.SendStatus(fiber.StatusOK)
@@ -214,10 +991,14 @@ func (s *Server) removeSkinFromTradeup() fiber.Handler {
 		invID := c.Query("invId")
 		userID := GetUserIDFromClaims(c)
 
-		err := s.tradeupService.RemoveSkinFromTradeup(tradeupID, invID, userID)
+		err := s.validator.ValidateTradeupItem(tradeupID, invID)
 		if err != nil {
-			log.Println(err)
-			return c.SendStatus(fiber.StatusInternalServerError)
+			return validationProblem(c, err)
+		}
+
+		err = s.tradeupService.RemoveSkinFromTradeup(tradeupID, invID, userID)
+		if err != nil {
+			return err
 		}
 
 		return c.SendStatus(fiber.StatusOK)
@@ -243,14 +1024,14 @@ func (s *Server) handleWebSocket(c *websocket.Conn) {
 
 	s.logger.Info("New connection", "user", client.UserID)
 
-	s.Lock()
-	s.clients[userID] = client
-	s.Unlock()
+	s.wsManager.Lock()
+	s.wsManager.clients[userID] = client
+	s.wsManager.Unlock()
 
 	defer func() {
-		s.Lock()
-		delete(s.clients, userID)
-		s.Unlock()
+		s.wsManager.Lock()
+		delete(s.wsManager.clients, userID)
+		s.wsManager.Unlock()
 		c.Close()
 	}()
 
@@ -2,26 +2,975 @@ package app
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/erobx/csupgrade-go-api/pkg/ratelimit"
+	"github.com/gofiber/fiber/v2"
 )
 
+type fakeUserService struct {
+	api.UserService
+	users map[string]api.User
+}
+
+func (f *fakeUserService) New(user *api.NewUserRequest) (string, error) {
+	for _, existing := range f.users {
+		if existing.Email == user.Email {
+			return "", api.ErrEmailTaken
+		}
+	}
+
+	id := "user-1"
+	f.users[id] = api.User{ID: id, Email: user.Email, Username: user.Username}
+	return id, nil
+}
+
+func (f *fakeUserService) Login(request *api.NewLoginRequest, ipAddress string) (api.User, api.Inventory, error) {
+	for _, user := range f.users {
+		if user.Email == request.Email {
+			return user, api.Inventory{UserID: user.ID, Items: []api.Item{}}, nil
+		}
+	}
+	return api.User{}, api.Inventory{}, api.ErrInvalidCredentials
+}
+
+func (f *fakeUserService) GetUser(userID string) (api.User, error) {
+	return f.users[userID], nil
+}
+
+func (f *fakeUserService) GetInventory(userID string) (api.Inventory, error) {
+	return api.Inventory{UserID: userID, Items: []api.Item{}}, nil
+}
+
+func (f *fakeUserService) GetRecentWinnings(userID string, limit, offset int) ([]api.WonItem, error) {
+	return []api.WonItem{{TradeupID: 7, Rarity: "Consumer", InputFloats: []float64{0.1, 0.2}}}, nil
+}
+
+func (f *fakeUserService) GetStats(userID string) (api.Stats, error) {
+	return api.Stats{TradeupsEntered: 3}, nil
+}
+
+type fakeSessionService struct {
+	api.SessionService
+	users   *fakeUserService
+	tokens  map[string]string
+	revoked map[string]bool
+}
+
+func (f *fakeSessionService) Create(userID, userAgent, ipAddress string) (string, api.Session, error) {
+	token := "refresh-" + userID
+	f.tokens[token] = userID
+	return token, api.Session{ID: "session-1", UserID: userID}, nil
+}
+
+func (f *fakeSessionService) Refresh(refreshToken, ipAddress string) (api.User, string, api.Session, error) {
+	userID, ok := f.tokens[refreshToken]
+	if !ok {
+		return api.User{}, "", api.Session{}, api.ErrInvalidRefreshToken
+	}
+	delete(f.tokens, refreshToken)
+
+	rotated := refreshToken + "-rotated"
+	f.tokens[rotated] = userID
+	return f.users.users[userID], rotated, api.Session{ID: "session-1", UserID: userID}, nil
+}
+
+func (f *fakeSessionService) IsActive(sessionID string) (bool, error) {
+	return !f.revoked[sessionID], nil
+}
+
+func (f *fakeSessionService) Logout(refreshToken string) error {
+	if _, ok := f.tokens[refreshToken]; !ok {
+		return api.ErrInvalidRefreshToken
+	}
+	delete(f.tokens, refreshToken)
+	return nil
+}
+
+type fakeAccountService struct {
+	api.AccountService
+	verificationsSent int
+}
+
+func (f *fakeAccountService) SendVerification(userID string) error {
+	f.verificationsSent++
+	return nil
+}
+
+type fakeTwoFactorService struct {
+	api.TwoFactorService
+}
+
+func (f *fakeTwoFactorService) Verify(userID, code string) error {
+	if code != "123456" {
+		return api.ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+type fakeAdminService struct {
+	api.AdminService
+}
+
+func (f *fakeAdminService) SearchUsers(query string, limit, offset int) ([]api.User, error) {
+	return []api.User{}, nil
+}
+
+func (f *fakeAdminService) SetRole(actorID, userID, role string) error {
+	return nil
+}
+
+// Only user-1 has entries
+type fakeLedgerService struct {
+	api.LedgerService
+}
+
+func (f *fakeLedgerService) GetTransactions(userID string, limit, offset int) ([]api.LedgerEntry, error) {
+	if userID != "user-1" {
+		return []api.LedgerEntry{}, nil
+	}
+	return []api.LedgerEntry{{ID: 2, Amount: api.Cents(-250), BalanceAfter: api.Cents(750),
+		Reason: api.ReasonCratePurchase}}, nil
+}
+
+type fakePricingService struct {
+	api.PricingService
+}
+
+func (f *fakePricingService) GetSkinPrices(skinID int, rangeName string) ([]api.PriceSeries, error) {
+	if rangeName != api.DefaultPriceRange {
+		return nil, api.ErrInvalidPriceRange
+	}
+	return []api.PriceSeries{{Wear: "Factory New", Points: []api.PricePoint{{Price: api.Cents(500)}}}}, nil
+}
+
+type fakeStoreService struct {
+	api.StoreService
+}
+
+func (f *fakeStoreService) GetCrate(crateID string) (api.Crate, error) {
+	if crateID != "1" {
+		return api.Crate{}, api.ErrCrateNotFound
+	}
+
+	return api.WithOdds(api.Crate{ID: 1, Name: "Test Case", Cost: api.Cents(250), Contents: []api.CrateDrop{
+		{SkinID: 1, Rarity: "Mil-Spec", Weight: 3},
+		{SkinID: 2, Rarity: "Mil-Spec", Weight: 1},
+	}}, api.DefaultRarityWeights()), nil
+}
+
+// Pays $1 an item
+func (f *fakeStoreService) SellItems(userID string, request *api.SellRequest) (api.Money, []api.ItemSale, error) {
+	if len(request.InvIDs) == 0 {
+		return api.Money{}, nil, api.ErrInvalidSellRequest
+	}
+
+	sold := make([]api.ItemSale, len(request.InvIDs))
+	for i, invID := range request.InvIDs {
+		sold[i] = api.ItemSale{InvID: invID, Price: api.Cents(100)}
+	}
+	return api.Cents(5000), sold, nil
+}
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := &fakeUserService{users: make(map[string]api.User)}
+	limiter := ratelimit.NewMemoryStore()
+	t.Cleanup(limiter.Close)
+
+	s := &Server{
+		app:              fiber.New(fiber.Config{ErrorHandler: ErrorHandler}),
+		validator:        NewValidator(),
+		keys:             NewKeyRing(key),
+		logger:           api.NewLogger(),
+		userService:      users,
+		sessionService:   &fakeSessionService{users: users, tokens: make(map[string]string)},
+		accountService:   &fakeAccountService{},
+		twoFactorService: &fakeTwoFactorService{},
+		adminService:     &fakeAdminService{},
+		storeService:     &fakeStoreService{},
+		ledgerService:    &fakeLedgerService{},
+		pricingService:   &fakePricingService{},
+		limiter:          limiter,
+		rateLimits:       DefaultRateLimits(),
+	}
+
+	s.Routes()
+	s.Protect()
+	s.ProtectedRoutes()
+
+	return s
+}
+
+func doJSON(t *testing.T, s *Server, method, target string, payload any) (*http.Response, map[string]any) {
+	t.Helper()
+
+	b, _ := json.Marshal(payload)
+	request := httptest.NewRequest(method, target, bytes.NewReader(b))
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := s.app.Test(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := make(map[string]any)
+	json.NewDecoder(response.Body).Decode(&body)
+	return response, body
+}
+
 // Should register a new user and return their data + jwt
 func TestRegister(t *testing.T) {
 	t.Run("registers new user", func(t *testing.T) {
+		s := newTestServer(t)
+		payload := api.NewUserRequest{
+			Email:    "test@test.com",
+			Username: "testing",
+			Password: "test-password",
+		}
+
+		response, body := doJSON(t, s, http.MethodPost, "/auth/register", payload)
+		if response.StatusCode != fiber.StatusOK {
+			t.Fatalf("expected 200, got %d", response.StatusCode)
+		}
+
+		if body["jwt"] == "" || body["jwt"] == nil {
+			t.Error("expected jwt in response")
+		}
+
+		if body["refreshToken"] != "refresh-user-1" {
+			t.Errorf("expected refresh token in response, got %v", body["refreshToken"])
+		}
+
+		if s.accountService.(*fakeAccountService).verificationsSent != 1 {
+			t.Error("expected verification email to be sent")
+		}
+	})
+
+	t.Run("reports every invalid field", func(t *testing.T) {
+		s := newTestServer(t)
+		payload := api.NewUserRequest{
+			Email:    "not-an-email",
+			Username: "a b",
+			Password: "short",
+		}
+
+		response, body := doJSON(t, s, http.MethodPost, "/auth/register", payload)
+		if response.StatusCode != fiber.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", response.StatusCode)
+		}
+
+		if ct := response.Header.Get("Content-Type"); ct != problemContentType {
+			t.Errorf("expected problem+json, got %q", ct)
+		}
+
+		if body["code"] != api.CodeValidationFailed {
+			t.Errorf("expected %s, got %v", api.CodeValidationFailed, body["code"])
+		}
+
+		codes := make(map[string]string)
+		for _, e := range body["errors"].([]any) {
+			field := e.(map[string]any)
+			codes[field["field"].(string)] = field["code"].(string)
+		}
+
+		want := map[string]string{
+			"email":    api.CodeInvalidEmail,
+			"username": api.CodeInvalidCharacters,
+			"password": api.CodeTooShort,
+		}
+		for field, code := range want {
+			if codes[field] != code {
+				t.Errorf("expected %s for %s, got %q", code, field, codes[field])
+			}
+		}
+	})
+
+	t.Run("reports taken email as a conflict", func(t *testing.T) {
+		s := newTestServer(t)
 		payload := api.NewUserRequest{
 			Email:    "test@test.com",
 			Username: "testing",
+			Password: "test-password",
+		}
+		doJSON(t, s, http.MethodPost, "/auth/register", payload)
+
+		response, body := doJSON(t, s, http.MethodPost, "/auth/register", payload)
+		if response.StatusCode != fiber.StatusConflict {
+			t.Fatalf("expected 409, got %d", response.StatusCode)
+		}
+
+		if body["code"] != api.CodeTaken {
+			t.Errorf("expected %s, got %v", api.CodeTaken, body["code"])
+		}
+	})
+}
+
+func TestTradeupItemValidation(t *testing.T) {
+	s := newTestServer(t)
+
+	token, err := s.issueAccessToken(api.User{ID: "user-1"}, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPut, "/v1/tradeups/3/add?invId=abc", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := s.app.Test(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", response.StatusCode)
+	}
+
+	var problem Problem
+	json.NewDecoder(response.Body).Decode(&problem)
+
+	if len(problem.Errors) != 1 || problem.Errors[0].Field != "invId" ||
+		problem.Errors[0].Code != api.CodeNotANumber {
+		t.Errorf("unexpected field errors %+v", problem.Errors)
+	}
+}
+
+func TestBuyCrateAmountValidation(t *testing.T) {
+	s := newTestServer(t)
+
+	token, err := s.issueAccessToken(api.User{ID: "user-1"}, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := "/v1/store/buy?userId=user-1&crateId=1&amount=" + strconv.Itoa(api.MaxCrateAmount+1)
+	request := httptest.NewRequest(http.MethodPost, target, nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := s.app.Test(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", response.StatusCode)
+	}
+
+	var problem Problem
+	json.NewDecoder(response.Body).Decode(&problem)
+
+	if len(problem.Errors) != 1 || problem.Errors[0].Field != "amount" ||
+		problem.Errors[0].Code != api.CodeTooLarge {
+		t.Errorf("unexpected field errors %+v", problem.Errors)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{api.ErrTradeupFull, fiber.StatusConflict, "tradeup_full"},
+		{api.ErrTradeupLocked, fiber.StatusLocked, "tradeup_locked"},
+		{api.ErrInsufficientFunds, fiber.StatusConflict, "insufficient_funds"},
+		{api.Wrap(api.ErrTradeupNotFound, errors.New("no rows")), fiber.StatusNotFound, "tradeup_not_found"},
+		{api.ErrInvalidCredentials, fiber.StatusUnauthorized, "invalid_credentials"},
+		{api.ErrForbidden, fiber.StatusForbidden, "forbidden"},
+		{api.ErrInvalidEmail, fiber.StatusBadRequest, api.CodeValidationFailed},
+		{api.ErrEmailTaken, fiber.StatusConflict, api.CodeTaken},
+		{&api.LockoutError{RetryAfter: time.Minute}, fiber.StatusTooManyRequests, "account_locked"},
+		{api.UnsupportedCurrency("below"), fiber.StatusUnprocessableEntity, "unsupported_currency"},
+		{fiber.ErrNotFound, fiber.StatusNotFound, "not_found"},
+		{errors.New("connection refused"), fiber.StatusInternalServerError, api.CodeInternal},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.code, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+			app.Get("/", func(c *fiber.Ctx) error { return tc.err })
+
+			response, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if response.StatusCode != tc.status {
+				t.Fatalf("expected %d, got %d", tc.status, response.StatusCode)
+			}
+
+			var problem Problem
+			json.NewDecoder(response.Body).Decode(&problem)
+
+			if problem.Code != tc.code {
+				t.Errorf("expected code %q, got %q", tc.code, problem.Code)
+			}
+
+			if strings.Contains(problem.Detail, "connection refused") {
+				t.Error("internal error details leaked to the client")
+			}
+		})
+	}
+
+	t.Run("recovers from a panicking handler", func(t *testing.T) {
+		s := &Server{app: fiber.New(fiber.Config{ErrorHandler: ErrorHandler})}
+		s.UseMiddleware()
+		s.app.Get("/", func(c *fiber.Ctx) error { panic("currency mismatch") })
+
+		response, err := s.app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.StatusCode != fiber.StatusInternalServerError {
+			t.Errorf("expected 500, got %d", response.StatusCode)
+		}
+	})
+
+	t.Run("sets Retry-After when locked out", func(t *testing.T) {
+		app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		app.Get("/", func(c *fiber.Ctx) error {
+			return &api.LockoutError{RetryAfter: 90 * time.Second}
+		})
+
+		response, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.Header.Get(fiber.HeaderRetryAfter) != "90" {
+			t.Errorf("expected Retry-After 90, got %q", response.Header.Get(fiber.HeaderRetryAfter))
+		}
+	})
+}
+
+// The catalog is public, no token needed
+func TestGetCrate(t *testing.T) {
+	s := newTestServer(t)
+
+	response, err := s.app.Test(httptest.NewRequest(http.MethodGet, "/v1/store/crates/1", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", response.StatusCode)
+	}
+
+	var crate api.Crate
+	json.NewDecoder(response.Body).Decode(&crate)
+
+	if len(crate.Contents) != 2 || crate.Contents[1].Probability != 0.25 {
+		t.Errorf("unexpected contents %+v", crate.Contents)
+	}
+
+	for _, target := range []string{"/v1/store/crates/2", "/v1/store/crates/abc"} {
+		response, err := s.app.Test(httptest.NewRequest(http.MethodGet, target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.StatusCode != fiber.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", target, response.StatusCode)
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	s := newTestServer(t)
+	doJSON(t, s, http.MethodPost, "/auth/register", api.NewUserRequest{
+		Email:    "test@test.com",
+		Username: "testing",
+		Password: "test-password",
+	})
+
+	t.Run("rotates refresh token", func(t *testing.T) {
+		response, body := doJSON(t, s, http.MethodPost, "/auth/refresh",
+			api.RefreshRequest{RefreshToken: "refresh-user-1"})
+		if response.StatusCode != fiber.StatusOK {
+			t.Fatalf("expected 200, got %d", response.StatusCode)
+		}
+
+		if body["refreshToken"] != "refresh-user-1-rotated" {
+			t.Errorf("expected rotated refresh token, got %v", body["refreshToken"])
+		}
+	})
+
+	t.Run("rejects used refresh token", func(t *testing.T) {
+		response, _ := doJSON(t, s, http.MethodPost, "/auth/refresh",
+			api.RefreshRequest{RefreshToken: "refresh-user-1"})
+		if response.StatusCode != fiber.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", response.StatusCode)
+		}
+	})
+
+	t.Run("rejects requests without access token", func(t *testing.T) {
+		response, _ := doJSON(t, s, http.MethodGet, "/v1/users/", nil)
+		if response.StatusCode != fiber.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", response.StatusCode)
+		}
+	})
+}
+
+func TestLogout(t *testing.T) {
+	s := newTestServer(t)
+	doJSON(t, s, http.MethodPost, "/auth/register", api.NewUserRequest{
+		Email:    "test@test.com",
+		Username: "testing",
+		Password: "test-password",
+	})
+
+	response, _ := doJSON(t, s, http.MethodPost, "/auth/logout",
+		api.RefreshRequest{RefreshToken: "refresh-user-1"})
+	if response.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("expected 204, got %d", response.StatusCode)
+	}
+
+	response, _ = doJSON(t, s, http.MethodPost, "/auth/refresh",
+		api.RefreshRequest{RefreshToken: "refresh-user-1"})
+	if response.StatusCode != fiber.StatusUnauthorized {
+		t.Fatalf("expected 401 after logout, got %d", response.StatusCode)
+	}
+}
+
+func TestRevokedSessionToken(t *testing.T) {
+	s := newTestServer(t)
+
+	token, err := s.issueAccessToken(api.User{ID: "user-1"}, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() *http.Response {
+		request := httptest.NewRequest(http.MethodGet, "/v1/users/transactions", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+
+		response, err := s.app.Test(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	if response := get(); response.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", response.StatusCode)
+	}
+
+	s.sessionService.(*fakeSessionService).revoked = map[string]bool{"session-1": true}
+
+	if response := get(); response.StatusCode != fiber.StatusUnauthorized {
+		t.Fatalf("expected 401 once the session is revoked, got %d", response.StatusCode)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	// app.Test connections come from 0.0.0.0
+	tests := []struct {
+		name    string
+		proxies []string
+		want    string
+	}{
+		{"no proxies configured", nil, "0.0.0.0"},
+		{"untrusted proxy", []string{"10.0.0.1"}, "0.0.0.0"},
+		{"trusted proxy", []string{"0.0.0.0"}, "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newApp(tt.proxies)
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString(ClientIP(c))
+			})
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Fly-Client-IP", "203.0.113.7")
+
+			response, err := app.Test(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, _ := io.ReadAll(response.Body)
+			if string(body) != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, body)
+			}
+		})
+	}
+}
+
+func TestLoginTwoFactor(t *testing.T) {
+	s := newTestServer(t)
+	s.userService.(*fakeUserService).users["user-2"] = api.User{
+		ID:               "user-2",
+		Email:            "2fa@test.com",
+		TwoFactorEnabled: true,
+	}
+
+	response, body := doJSON(t, s, http.MethodPost, "/auth/login", api.NewLoginRequest{
+		Email:    "2fa@test.com",
+		Password: "test",
+	})
+	if response.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", response.StatusCode)
+	}
+
+	if body["twoFactorRequired"] != true || body["jwt"] != nil {
+		t.Fatalf("expected only a challenge, got %v", body)
+	}
+	challenge := body["challengeToken"].(string)
+
+	t.Run("challenge token does not grant access", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/v1/users/", nil)
+		request.Header.Set("Authorization", "Bearer "+challenge)
+
+		response, err := s.app.Test(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.StatusCode != fiber.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", response.StatusCode)
+		}
+	})
+
+	t.Run("rejects wrong code", func(t *testing.T) {
+		response, _ := doJSON(t, s, http.MethodPost, "/auth/login/2fa", api.TwoFactorLoginRequest{
+			ChallengeToken: challenge,
+			Code:           "000000",
+		})
+		if response.StatusCode != fiber.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", response.StatusCode)
+		}
+	})
+
+	t.Run("gives up on a challenge after too many codes", func(t *testing.T) {
+		_, body := doJSON(t, s, http.MethodPost, "/auth/login", api.NewLoginRequest{
+			Email:    "2fa@test.com",
 			Password: "test",
+		})
+		guessed := body["challengeToken"].(string)
+
+		for range maxChallengeAttempts {
+			doJSON(t, s, http.MethodPost, "/auth/login/2fa", api.TwoFactorLoginRequest{
+				ChallengeToken: guessed,
+				Code:           "000000",
+			})
 		}
-		b, _ := json.Marshal(payload)
-		reader := bytes.NewReader(b)
-		request := httptest.NewRequest(http.MethodPost, "/auth/register", reader)
-		response := httptest.NewRecorder()
 
+		response, body := doJSON(t, s, http.MethodPost, "/auth/login/2fa", api.TwoFactorLoginRequest{
+			ChallengeToken: guessed,
+			Code:           "123456",
+		})
+		if response.StatusCode != fiber.StatusUnauthorized || body["code"] != "challenge_exhausted" {
+			t.Fatalf("expected the challenge to be used up, got %d %v", response.StatusCode, body)
+		}
 	})
+
+	t.Run("issues tokens for correct code", func(t *testing.T) {
+		response, body := doJSON(t, s, http.MethodPost, "/auth/login/2fa", api.TwoFactorLoginRequest{
+			ChallengeToken: challenge,
+			Code:           "123456",
+		})
+		if response.StatusCode != fiber.StatusOK {
+			t.Fatalf("expected 200, got %d", response.StatusCode)
+		}
+
+		if body["jwt"] == nil || body["refreshToken"] == nil {
+			t.Fatalf("expected tokens, got %v", body)
+		}
+	})
+}
+
+func TestLoginBanned(t *testing.T) {
+	s := newTestServer(t)
+	bannedAt := time.Now()
+	s.userService.(*fakeUserService).users["user-2"] = api.User{
+		ID:        "user-2",
+		Email:     "banned@test.com",
+		BannedAt:  &bannedAt,
+		BanReason: "cheating",
+	}
+
+	response, body := doJSON(t, s, http.MethodPost, "/auth/login", api.NewLoginRequest{
+		Email:    "banned@test.com",
+		Password: "test",
+	})
+	if response.StatusCode != fiber.StatusForbidden {
+		t.Fatalf("expected 403, got %d", response.StatusCode)
+	}
+
+	if body["jwt"] != nil || body["reason"] != "cheating" {
+		t.Fatalf("expected ban reason without tokens, got %v", body)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	s := newTestServer(t)
+
+	request := func(role, method, target string) int {
+		t.Helper()
+
+		token, err := s.issueAccessToken(api.User{ID: "user-" + role, Role: role}, "session-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := httptest.NewRequest(method, target, strings.NewReader(`{"role":"moderator"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		response, err := s.app.Test(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.StatusCode
+	}
+
+	cases := []struct {
+		role, method, target string
+		expected             int
+	}{
+		{api.RoleUser, http.MethodGet, "/v1/admin/users", fiber.StatusForbidden},
+		{api.RoleModerator, http.MethodGet, "/v1/admin/users", fiber.StatusOK},
+		{api.RoleAdmin, http.MethodGet, "/v1/admin/users", fiber.StatusOK},
+		{api.RoleModerator, http.MethodPut, "/v1/admin/users/user-1/role", fiber.StatusForbidden},
+		{api.RoleAdmin, http.MethodPut, "/v1/admin/users/user-1/role", fiber.StatusNoContent},
+		{"", http.MethodGet, "/v1/admin/users", fiber.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.role+" "+tc.method+" "+tc.target, func(t *testing.T) {
+			if status := request(tc.role, tc.method, tc.target); status != tc.expected {
+				t.Fatalf("expected %d, got %d", tc.expected, status)
+			}
+		})
+	}
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.rateLimits.Apply("login.account=2/1m"); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(email string) *http.Response {
+		response, _ := doJSON(t, s, http.MethodPost, "/auth/login", api.NewLoginRequest{
+			Email:    email,
+			Password: "wrong",
+		})
+		return response
+	}
+
+	for range 2 {
+		if response := login("Target@test.com"); response.StatusCode != fiber.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", response.StatusCode)
+		}
+	}
+
+	response := login(" target@test.com")
+	if response.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", response.StatusCode)
+	}
+
+	if response.Header.Get(fiber.HeaderRetryAfter) != "30" {
+		t.Errorf("expected Retry-After 30, got %q", response.Header.Get(fiber.HeaderRetryAfter))
+	}
+
+	t.Run("other accounts are not affected", func(t *testing.T) {
+		if response := login("other@test.com"); response.StatusCode != fiber.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", response.StatusCode)
+		}
+	})
+}
+
+func TestGetRecentWinnings(t *testing.T) {
+	s := newTestServer(t)
+
+	token, err := s.issueAccessToken(api.User{ID: "user-1"}, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/v1/users/user-1/winnings?limit=1000&offset=-5", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := s.app.Test(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", response.StatusCode)
+	}
+
+	var body struct {
+		Winnings []api.WonItem `json:"winnings"`
+		Limit    int           `json:"limit"`
+		Offset   int           `json:"offset"`
+	}
+	json.NewDecoder(response.Body).Decode(&body)
+
+	if body.Limit != 100 || body.Offset != 0 {
+		t.Errorf("expected clamped pagination, got limit %d offset %d", body.Limit, body.Offset)
+	}
+
+	if len(body.Winnings) != 1 || body.Winnings[0].TradeupID != 7 {
+		t.Errorf("unexpected winnings %+v", body.Winnings)
+	}
+}
+
+func TestGetTransactions(t *testing.T) {
+	s := newTestServer(t)
+
+	token, err := s.issueAccessToken(api.User{ID: "user-1"}, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/v1/users/transactions?limit=5", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := s.app.Test(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", response.StatusCode)
+	}
+
+	var body struct {
+		Transactions []api.LedgerEntry `json:"transactions"`
+		Limit        int               `json:"limit"`
+	}
+	json.NewDecoder(response.Body).Decode(&body)
+
+	if body.Limit != 5 || len(body.Transactions) != 1 || body.Transactions[0].Amount != api.Cents(-250) {
+		t.Errorf("unexpected transactions %+v", body)
+	}
+}
+
+// Prices are public, no token needed
+func TestGetSkinPrices(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/v1/skins/1/prices", fiber.StatusOK},
+		{"/v1/skins/1/prices?range=2w", fiber.StatusBadRequest},
+		{"/v1/skins/abc/prices", fiber.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		response, err := s.app.Test(httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.StatusCode != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.url, tt.status, response.StatusCode)
+		}
+	}
+}
+
+func TestSellItems(t *testing.T) {
+	s := newTestServer(t)
+
+	token, err := s.issueAccessToken(api.User{ID: "user-1"}, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sell := func(body string) *http.Response {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodPost, "/v1/store/sell", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		response, err := s.app.Test(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	response := sell(`{"invIds": [3, 4]}`)
+	if response.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", response.StatusCode)
+	}
+
+	var body struct {
+		Balance api.Money      `json:"balance"`
+		Sold    []api.ItemSale `json:"sold"`
+		Total   api.Money      `json:"total"`
+	}
+	json.NewDecoder(response.Body).Decode(&body)
+
+	if len(body.Sold) != 2 || body.Total != api.Cents(200) || body.Balance != api.Cents(5000) {
+		t.Errorf("unexpected sale %+v", body)
+	}
+
+	if status := sell(`{"rarity": 5}`).StatusCode; status != fiber.StatusBadRequest {
+		t.Errorf("expected 400 for a malformed body, got %d", status)
+	}
+	if status := sell(`{}`).StatusCode; status != fiber.StatusBadRequest {
+		t.Errorf("expected 400 for an empty sale, got %d", status)
+	}
+	if status := sell(`{"below": {"cents": 100, "currency": "EUR"}}`).StatusCode; status != fiber.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a price in euros, got %d", status)
+	}
+}
+
+func TestStatsPrivacy(t *testing.T) {
+	s := newTestServer(t)
+	users := s.userService.(*fakeUserService).users
+	users["user-1"] = api.User{ID: "user-1"}
+	users["user-2"] = api.User{ID: "user-2", Privacy: api.PrivacySettings{HideStats: true}}
+
+	token, err := s.issueAccessToken(users["user-1"], "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]int{
+		"/v1/users/user-1/stats":    fiber.StatusOK,
+		"/v1/users/user-2/stats":    fiber.StatusForbidden,
+		"/v1/users/user-2/winnings": fiber.StatusOK,
+	}
+
+	for target, expected := range cases {
+		t.Run(target, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, target, nil)
+			request.Header.Set("Authorization", "Bearer "+token)
+
+			response, err := s.app.Test(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if response.StatusCode != expected {
+				t.Fatalf("expected %d, got %d", expected, response.StatusCode)
+			}
+		})
+	}
 }
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeyRing holds the RSA key new tokens are signed with plus any retired keys
+// whose tokens should still verify. Every key is identified by its RFC 7638
+// thumbprint, which is sent as the kid header and published in the JWKS.
+//
+// To rotate: generate a new key, move the current RSA_PRIVATE_KEY into
+// RSA_PREVIOUS_KEYS and set the new one as RSA_PRIVATE_KEY. Once the longest
+// lived token signed with the old key has expired it can be dropped from
+// RSA_PREVIOUS_KEYS.
+type KeyRing struct {
+	activeID   string
+	active     *rsa.PrivateKey
+	publicKeys map[string]*rsa.PublicKey
+	order      []string
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewKeyRing(active *rsa.PrivateKey, previous ...*rsa.PublicKey) *KeyRing {
+	k := &KeyRing{
+		activeID:   KeyID(&active.PublicKey),
+		active:     active,
+		publicKeys: make(map[string]*rsa.PublicKey),
+	}
+
+	k.add(&active.PublicKey)
+	for _, pub := range previous {
+		k.add(pub)
+	}
+
+	return k
+}
+
+// Builds a key ring from the PEM encoded active private key and zero or more
+// concatenated PEM blocks (private or public) of retired keys
+func ParseKeyRing(activePEM, previousPEM string) (*KeyRing, error) {
+	active, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(activePEM))
+	if err != nil {
+		return nil, fmt.Errorf("active key: %w", err)
+	}
+
+	var previous []*rsa.PublicKey
+	rest := []byte(strings.TrimSpace(previousPEM))
+	for len(rest) > 0 {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, errors.New("previous keys: invalid PEM block")
+		}
+
+		pub, err := parseRSAPublicKey(block)
+		if err != nil {
+			return nil, fmt.Errorf("previous keys: %w", err)
+		}
+		previous = append(previous, pub)
+	}
+
+	return NewKeyRing(active, previous...), nil
+}
+
+// Signs claims with the active key and tags the token with its kid
+func (k *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = k.activeID
+	return token.SignedString(k.active)
+}
+
+// Resolves the verification key for a token. Tokens issued before kids were
+// added only verify against the active key.
+func (k *KeyRing) Keyfunc(token *jwt.Token) (any, error) {
+	if token.Method.Alg() != jwt.SigningMethodRS256.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return &k.active.PublicKey, nil
+	}
+
+	pub, ok := k.publicKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+
+	return pub, nil
+}
+
+// Public half of every key in the ring, active key first
+func (k *KeyRing) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(k.order))}
+	for _, kid := range k.order {
+		pub := k.publicKeys[kid]
+		jwks.Keys = append(jwks.Keys, JWK{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: jwt.SigningMethodRS256.Alg(),
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		})
+	}
+
+	return jwks
+}
+
+// RFC 7638 JWK thumbprint of an RSA public key
+func KeyID(pub *rsa.PublicKey) string {
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	n := base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+	sum := sha256.Sum256([]byte(`{"e":"` + e + `","kty":"RSA","n":"` + n + `"}`))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (k *KeyRing) add(pub *rsa.PublicKey) {
+	kid := KeyID(pub)
+	if _, exists := k.publicKeys[kid]; exists {
+		return
+	}
+
+	k.publicKeys[kid] = pub
+	k.order = append(k.order, kid)
+}
+
+func parseRSAPublicKey(block *pem.Block) (*rsa.PublicKey, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return &priv.PublicKey, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("not an RSA key")
+		}
+		return &priv.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("not an RSA key")
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %s", block.Type)
+	}
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func encodeKey(key *rsa.PrivateKey) string {
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}))
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	oldKey := generateKey(t)
+	newKey := generateKey(t)
+
+	oldRing := NewKeyRing(oldKey)
+	oldToken, err := oldRing.Sign(jwt.MapClaims{"id": "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := ParseKeyRing(encodeKey(newKey), encodeKey(oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("old tokens still verify", func(t *testing.T) {
+		if _, err := jwt.Parse(oldToken, rotated.Keyfunc); err != nil {
+			t.Fatalf("expected old token to verify, got %v", err)
+		}
+	})
+
+	t.Run("new tokens use the new key", func(t *testing.T) {
+		newToken, err := rotated.Sign(jwt.MapClaims{"id": "user-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := jwt.Parse(newToken, rotated.Keyfunc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token.Header["kid"] != KeyID(&newKey.PublicKey) {
+			t.Errorf("expected kid of new key, got %v", token.Header["kid"])
+		}
+
+		if _, err := jwt.Parse(newToken, oldRing.Keyfunc); err == nil {
+			t.Error("expected old ring to reject token signed with new key")
+		}
+	})
+
+	t.Run("publishes both keys", func(t *testing.T) {
+		jwks := rotated.JWKS()
+		if len(jwks.Keys) != 2 {
+			t.Fatalf("expected 2 keys, got %d", len(jwks.Keys))
+		}
+
+		if jwks.Keys[0].Kid != KeyID(&newKey.PublicKey) {
+			t.Error("expected active key first")
+		}
+
+		n, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[1].N)
+		if new(big.Int).SetBytes(n).Cmp(oldKey.N) != 0 {
+			t.Error("expected modulus of old key")
+		}
+	})
+}
+
+func TestKeyRingRejectsUnknownKid(t *testing.T) {
+	other := NewKeyRing(generateKey(t))
+	token, err := other.Sign(jwt.MapClaims{"id": "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ring := NewKeyRing(generateKey(t))
+	if _, err := jwt.Parse(token, ring.Keyfunc); err == nil {
+		t.Fatal("expected token with unknown kid to be rejected")
+	}
+}
@@ -1,24 +1,44 @@
 package app
 
 import (
-	"log"
-	"strings"
+	"context"
+	"errors"
 	"time"
 
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/erobx/csupgrade-go-api/pkg/ratelimit"
 	jwtware "github.com/gofiber/contrib/jwt"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// Access tokens are short-lived, sessions are kept alive via /auth/refresh
+	accessTokenTTL = 15 * time.Minute
+	// Time a user has to enter their 2FA code after their password
+	challengeTokenTTL = 5 * time.Minute
+	// Codes that can be tried against one challenge before the user has to
+	// log in again
+	maxChallengeAttempts = 5
+
+	tokenTypeAccess    = "access"
+	tokenTypeChallenge = "2fa_challenge"
 )
 
 func (s *Server) UseMiddleware() {
+	// a panicking handler is reported as a 500 instead of taking the process
+	// down
+	s.app.Use(recover.New())
+
 	s.app.Use(cors.New(cors.Config{
 		AllowOrigins:     "https://csupgrade.ebob.dev, http://localhost:5173",
 		AllowCredentials: true,
 		AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
-		AllowMethods:     "GET, POST, PUT, DELETE, OPTIONS",
-		ExposeHeaders:    "X-New-Token",
+		AllowMethods:     "GET, POST, PUT, PATCH, DELETE, OPTIONS",
 	}))
 
 	s.app.Use("/ws", func(c *fiber.Ctx) error {
@@ -32,62 +52,147 @@ func (s *Server) UseMiddleware() {
 
 func (s *Server) Protect() {
 	s.app.Use(jwtware.New(jwtware.Config{
-		SigningKey: jwtware.SigningKey{
-			JWTAlg: jwtware.RS256,
-			Key:    s.privateKey.Public(),
-		},
+		KeyFunc:      s.keys.Keyfunc,
 		ErrorHandler: s.InvalidJWT(),
+		// 2FA challenge tokens are signed with the same keys but must never
+		// grant access. Access tokens die with their session, so logging out,
+		// bans and role changes don't wait for them to expire.
+		SuccessHandler: func(c *fiber.Ctx) error {
+			claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+			if typ, ok := claims["typ"]; ok && typ != tokenTypeAccess {
+				return fiber.ErrUnauthorized
+			}
+
+			sessionID := GetSessionIDFromClaims(c)
+			if sessionID == "" {
+				return fiber.ErrUnauthorized
+			}
+
+			active, err := s.sessionService.IsActive(sessionID)
+			if err != nil {
+				return err
+			}
+			if !active {
+				return fiber.ErrUnauthorized
+			}
+
+			return c.Next()
+		},
 	}))
 }
 
+// Only lets through users whose token carries at least the required role.
+// Roles are read from the access token, changing one ends the user's sessions
+// so a demoted user's token stops working.
+func RequireRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !api.HasRole(GetRoleFromClaims(c), role) {
+			return api.ErrForbidden
+		}
+		return c.Next()
+	}
+}
+
 func (s *Server) InvalidJWT() fiber.ErrorHandler {
 	return func(c *fiber.Ctx, err error) error {
-		// Only handle JWT errors
-		if err == nil || !strings.Contains(err.Error(), "token is expired") {
-			return fiber.ErrUnauthorized
+		// Expired access tokens are never re-signed here, clients have to
+		// exchange their refresh token at /auth/refresh
+		if err != nil && errors.Is(err, jwt.ErrTokenExpired) {
+			return c.Status(fiber.StatusUnauthorized).SendString("Token expired")
 		}
 
-		// Get the expired token from header (or cookie if you use that)
-		authHeader := c.Get("Authorization")
-		if authHeader == "" {
-			return fiber.ErrUnauthorized
-		}
+		return fiber.ErrUnauthorized
+	}
+}
 
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-		token, _ := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			return s.privateKey.Public(), nil
-		})
+// Signs a short-lived access token for the user bound to a refresh session
+func (s *Server) issueAccessToken(user api.User, sessionID string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"id":                  user.ID,
+		"email":               user.Email,
+		"refreshTokenVersion": user.RefreshTokenVersion,
+		"sid":                 sessionID,
+		"role":                user.Role,
+		"typ":                 tokenTypeAccess,
+		"iat":                 now.Unix(),
+		"exp":                 now.Add(accessTokenTTL).Unix(),
+	}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok || token.Method.Alg() != jwt.SigningMethodRS256.Alg() {
-			return fiber.ErrUnauthorized
-		}
+	return s.keys.Sign(claims)
+}
 
-		// Re-issue new token
-		newTokenStr, err := s.issueNewToken(claims)
-		if err != nil {
-			return fiber.ErrUnauthorized
-		}
+// Proves the user got past their password, exchanged for real tokens once the
+// 2FA code is checked
+func (s *Server) issueChallengeToken(user api.User) (string, error) {
+	now := time.Now()
+	return s.keys.Sign(jwt.MapClaims{
+		"id":  user.ID,
+		"jti": uuid.NewString(),
+		"typ": tokenTypeChallenge,
+		"iat": now.Unix(),
+		"exp": now.Add(challengeTokenTTL).Unix(),
+	})
+}
+
+// Returns the user ID and challenge ID from a valid, unexpired challenge token
+func (s *Server) parseChallengeToken(tokenStr string) (string, string, error) {
+	token, err := jwt.Parse(tokenStr, s.keys.Keyfunc, jwt.WithExpirationRequired())
+	if err != nil {
+		return "", "", err
+	}
 
-		// Return new token in response header or body
-		c.Set("X-New-Token", newTokenStr)
-		log.Printf("issued new token for %s\n", claims["id"].(string))
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != tokenTypeChallenge {
+		return "", "", errors.New("not a challenge token")
+	}
 
-		// Optional: continue to next middleware/handler
-		// return c.Next()
-		// But Fiber JWT middleware doesn't allow continuing after error, so:
-		return c.Status(fiber.StatusUnauthorized).SendString("Token expired, issued new token")
+	userID, ok := claims["id"].(string)
+	if !ok {
+		return "", "", errors.New("challenge token missing user")
 	}
+
+	challengeID, ok := claims["jti"].(string)
+	if !ok || challengeID == "" {
+		return "", "", errors.New("challenge token missing id")
+	}
+
+	return userID, challengeID, nil
 }
 
-func (s *Server) issueNewToken(claims jwt.MapClaims) (string, error) {
-	newClaims := jwt.MapClaims{
-		"id":                  claims["id"],
-		"email":               claims["email"],
-		"refreshTokenVersion": claims["refreshTokenVersion"],
-		"exp":                 time.Now().Add(23 * time.Hour).Unix(),
+// Uses up one of the challenge's attempts. The bucket refills slower than a
+// challenge lives, so a challenge gets maxChallengeAttempts codes, give or
+// take one, before it's no good.
+func (s *Server) takeChallengeAttempt(challengeID string) error {
+	if s.limiter == nil {
+		return nil
+	}
+
+	limit := ratelimit.Limit{Requests: maxChallengeAttempts, Per: maxChallengeAttempts * challengeTokenTTL}
+	result, err := s.limiter.Take(context.Background(), limitLogin2FA+":challenge:"+challengeID, limit)
+	if err != nil {
+		s.logger.Error("rate limiter unavailable", "error", err)
+		return nil
+	}
+
+	if !result.Allowed {
+		return api.ErrChallengeExhausted
+	}
+	return nil
+}
+
+// Starts a new refresh session and returns an access token and refresh token
+func (s *Server) issueTokens(c *fiber.Ctx, user api.User) (string, string, error) {
+	refreshToken, session, err := s.sessionService.Create(user.ID, c.Get(fiber.HeaderUserAgent),
+		ClientIP(c))
+	if err != nil {
+		return "", "", err
+	}
+
+	t, err := s.issueAccessToken(user, session.ID)
+	if err != nil {
+		return "", "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, newClaims)
-	return token.SignedString(s.privateKey)
+	return t, refreshToken, nil
 }
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"strconv"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/gofiber/fiber/v2"
+)
+
+func (s *Server) getSkinPrices() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		skinID, err := strconv.Atoi(c.Params("skinId"))
+		if err != nil {
+			return api.ErrSkinNotFound
+		}
+
+		rangeName := c.Query("range", api.DefaultPriceRange)
+		series, err := s.pricingService.GetSkinPrices(skinID, rangeName)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"skinId": skinID,
+			"range":  rangeName,
+			"series": series,
+		})
+	}
+}
+
+func (s *Server) getInventoryValueHistory() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rangeName := c.Query("range", api.DefaultPriceRange)
+		points, err := s.pricingService.GetInventoryValueHistory(GetUserIDFromClaims(c), rangeName)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"range":  rangeName,
+			"points": points,
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package app
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+const problemContentType = "application/problem+json"
+
+// RFC 7807 problem details. Code is an extension member with the stable
+// error code, Errors lists every invalid field.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Returned by the validator when one or more fields are invalid
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Fields) == 0 {
+		return "validation failed"
+	}
+	return e.Fields[0].Field + ": " + e.Fields[0].Message
+}
+
+// Status for each kind of catalog error
+var kindStatus = map[api.Kind]int{
+	api.KindValidation:        fiber.StatusBadRequest,
+	api.KindUnauthorized:      fiber.StatusUnauthorized,
+	api.KindForbidden:         fiber.StatusForbidden,
+	api.KindNotFound:          fiber.StatusNotFound,
+	api.KindConflict:          fiber.StatusConflict,
+	api.KindInsufficientFunds: fiber.StatusConflict,
+	api.KindTradeupFull:       fiber.StatusConflict,
+	api.KindTradeupLocked:     fiber.StatusLocked,
+	api.KindRateLimited:       fiber.StatusTooManyRequests,
+	api.KindUnprocessable:     fiber.StatusUnprocessableEntity,
+}
+
+// Turns whatever a handler returns into a problem response. Catalog errors
+// get the status for their kind, anything else is logged and reported as a
+// bare 500 so internals don't leak.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var validation *ValidationError
+	if errors.As(err, &validation) {
+		return validationProblem(c, validation)
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return sendProblem(c, fiberErr.Code, statusCode(fiberErr.Code), fiberErr.Message)
+	}
+
+	var catalogErr *api.Error
+	if !errors.As(err, &catalogErr) || catalogErr.Kind == api.KindInternal {
+		log.Println(err)
+		return sendProblem(c, fiber.StatusInternalServerError, api.CodeInternal, "")
+	}
+
+	if wait, ok := api.RetryAfter(err); ok {
+		setRetryAfter(c, wait)
+	}
+
+	field := FieldError{Field: catalogErr.Field, Code: catalogErr.Code, Message: catalogErr.Message}
+	if catalogErr.Field == "" {
+		return sendProblem(c, kindStatus[catalogErr.Kind], catalogErr.Code, catalogErr.Message)
+	}
+
+	if catalogErr.Kind == api.KindValidation {
+		return validationProblem(c, &ValidationError{Fields: []FieldError{field}})
+	}
+
+	return sendProblem(c, kindStatus[catalogErr.Kind], catalogErr.Code, catalogErr.Message, field)
+}
+
+// Codes for errors raised by fiber itself, like unknown routes
+func statusCode(status int) string {
+	return strings.ToLower(strings.ReplaceAll(utils.StatusMessage(status), " ", "_"))
+}
+
+func sendProblem(c *fiber.Ctx, status int, code, detail string, fields ...FieldError) error {
+	return c.Status(status).JSON(Problem{
+		Type:     "about:blank",
+		Title:    utils.StatusMessage(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: c.OriginalURL(),
+		Code:     code,
+		Errors:   fields,
+	}, problemContentType)
+}
+
+// Responds with every field error at once so forms can mark them together
+func validationProblem(c *fiber.Ctx, err error) error {
+	var validation *ValidationError
+	if !errors.As(err, &validation) {
+		return sendProblem(c, fiber.StatusBadRequest, api.CodeValidationFailed, err.Error())
+	}
+
+	return sendProblem(c, fiber.StatusBadRequest, api.CodeValidationFailed,
+		"one or more fields are invalid", validation.Fields...)
+}
+
+func malformedBody(c *fiber.Ctx) error {
+	return sendProblem(c, fiber.StatusBadRequest, api.CodeMalformedBody, "request body could not be parsed")
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/erobx/csupgrade-go-api/pkg/ratelimit"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Limits for a single route. Each request takes a token from the caller's IP
+// bucket and, when the route can tell who the request is for, that account's
+// bucket.
+type RouteLimit struct {
+	PerIP      ratelimit.Limit
+	PerAccount ratelimit.Limit
+}
+
+// Route limits keyed by route name
+type RateLimits map[string]RouteLimit
+
+const (
+	limitLogin    = "login"
+	limitLogin2FA = "login_2fa"
+	limitRegister = "register"
+	limitForgot   = "forgot"
+	limitBuy      = "buy"
+	limitSell     = "sell"
+)
+
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		limitLogin: {
+			PerIP:      ratelimit.Limit{Requests: 20, Per: time.Minute},
+			PerAccount: ratelimit.Limit{Requests: 10, Per: 15 * time.Minute},
+		},
+		limitLogin2FA: {
+			PerIP: ratelimit.Limit{Requests: 10, Per: time.Minute},
+		},
+		limitRegister: {
+			PerIP: ratelimit.Limit{Requests: 5, Per: time.Hour},
+		},
+		limitForgot: {
+			PerIP:      ratelimit.Limit{Requests: 5, Per: 15 * time.Minute},
+			PerAccount: ratelimit.Limit{Requests: 3, Per: time.Hour},
+		},
+		limitBuy: {
+			PerIP:      ratelimit.Limit{Requests: 60, Per: time.Minute},
+			PerAccount: ratelimit.Limit{Requests: 30, Per: time.Minute},
+		},
+		limitSell: {
+			PerIP:      ratelimit.Limit{Requests: 60, Per: time.Minute},
+			PerAccount: ratelimit.Limit{Requests: 30, Per: time.Minute},
+		},
+	}
+}
+
+// Overrides limits from a spec like "login.ip=10/1m,buy.account=60/1m". A
+// limit of 0/1s turns that bucket off.
+func (r RateLimits) Apply(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		target, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid rate limit %q", entry)
+		}
+
+		route, bucket, ok := strings.Cut(target, ".")
+		if !ok {
+			return fmt.Errorf("invalid rate limit %q", entry)
+		}
+
+		limit, err := ratelimit.ParseLimit(value)
+		if err != nil {
+			return err
+		}
+
+		routeLimit := r[route]
+		switch bucket {
+		case "ip":
+			routeLimit.PerIP = limit
+		case "account":
+			routeLimit.PerAccount = limit
+		default:
+			return fmt.Errorf("invalid rate limit bucket %q", bucket)
+		}
+		r[route] = routeLimit
+	}
+
+	return nil
+}
+
+// Throttles the route named name. account returns the key for the per-account
+// bucket, or an empty string when there's nothing to key on.
+func (s *Server) rateLimit(name string, account func(c *fiber.Ctx) string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if s.limiter == nil {
+			return c.Next()
+		}
+
+		limit := s.rateLimits[name]
+
+		keys := []string{name + ":ip:" + ClientIP(c)}
+		limits := []ratelimit.Limit{limit.PerIP}
+		if account != nil {
+			if key := account(c); key != "" {
+				keys = append(keys, name+":account:"+key)
+				limits = append(limits, limit.PerAccount)
+			}
+		}
+
+		for i, key := range keys {
+			result, err := s.limiter.Take(context.Background(), key, limits[i])
+			if err != nil {
+				// a limiter outage shouldn't take logins down with it
+				s.logger.Error("rate limiter unavailable", "error", err)
+				continue
+			}
+
+			if !result.Allowed {
+				return tooManyRequests(c, result.RetryAfter)
+			}
+		}
+
+		return c.Next()
+	}
+}
+
+func tooManyRequests(c *fiber.Ctx, retryAfter time.Duration) error {
+	setRetryAfter(c, retryAfter)
+	return sendProblem(c, fiber.StatusTooManyRequests, api.CodeRateLimited, "too many requests")
+}
+
+func setRetryAfter(c *fiber.Ctx, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(max(seconds, 1)))
+}
+
+// Account key for requests that carry an email in the body
+func emailFromBody(c *fiber.Ctx) string {
+	var body struct {
+		Email string `json:"email"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return ""
+	}
+
+	return strings.ToLower(strings.TrimSpace(body.Email))
+}
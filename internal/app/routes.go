@@ -1,15 +1,37 @@
 package app
 
 import (
+	"github.com/erobx/csupgrade-go-api/pkg/api"
 	"github.com/gofiber/contrib/websocket"
 )
 
 func (s *Server) Routes() {
     s.app.Get("/ws", websocket.New(s.handleWebSocket))
+	s.app.Get("/.well-known/jwks.json", s.getJWKS())
 
 	auth := s.app.Group("auth")
-	auth.Post("/register", s.register())
-	auth.Post("/login", s.login())
+	auth.Post("/register", s.rateLimit(limitRegister, nil), s.register())
+	auth.Post("/login", s.rateLimit(limitLogin, emailFromBody), s.login())
+	auth.Post("/login/2fa", s.rateLimit(limitLogin2FA, nil), s.loginTwoFactor())
+	auth.Post("/refresh", s.refresh())
+	auth.Post("/logout", s.logout())
+	auth.Post("/logout-all", s.logoutAll())
+	auth.Post("/verify", s.verifyEmail())
+	auth.Post("/email/confirm", s.confirmEmailChange())
+	auth.Post("/forgot", s.rateLimit(limitForgot, emailFromBody), s.forgotPassword())
+	auth.Post("/reset", s.resetPassword())
+
+	// public profiles, the crate catalog and skin prices don't need a token
+	// so they're registered before Protect
+	s.app.Get("/v1/profiles/:username", s.getProfile())
+	s.app.Get("/v1/store/crates", s.getCrates())
+	s.app.Get("/v1/store/crates/:crateId", s.getCrate())
+	s.app.Get("/v1/skins/:skinId/prices", s.getSkinPrices())
+
+	if s.steamService != nil {
+		auth.Get("/steam", s.steamRedirect())
+		auth.Post("/steam/verify", s.steamLogin())
+	}
 }
 
 func (s *Server) ProtectedRoutes() {
@@ -19,16 +41,66 @@ func (s *Server) ProtectedRoutes() {
 	users := v1.Group("users")
 
 	users.Get("/", s.getUser())
+	users.Delete("/", s.deleteAccount())
+	users.Delete("/deletion", s.cancelAccountDeletion())
+	users.Get("/export", s.exportAccount())
+	users.Get("/transactions", s.getTransactions())
     users.Get("/inventory", s.getInventory())
+	users.Get("/inventory/value-history", s.getInventoryValueHistory())
 	users.Get("/:userId/recents", s.getRecentTradeups())
 	users.Get("/:userId/stats", s.getUserStats())
+	users.Get("/:userId/winnings", s.getRecentWinnings())
+	users.Put("/privacy", s.updatePrivacy())
+	users.Put("/showcase", s.setShowcase())
+	users.Put("/avatar", s.uploadAvatar())
+	users.Put("/username", s.changeUsername())
+	users.Put("/email", s.changeEmail())
+	users.Put("/password", s.changePassword())
+	users.Get("/sessions", s.getSessions())
+	users.Delete("/sessions/:sessionId", s.revokeSession())
+	users.Post("/verify/resend", s.resendVerification())
+	users.Post("/2fa/enroll", s.enrollTwoFactor())
+	users.Post("/2fa/confirm", s.confirmTwoFactor())
+	users.Post("/2fa/disable", s.disableTwoFactor())
+	users.Post("/2fa/recovery-codes", s.regenerateRecoveryCodes())
+
+	if s.steamService != nil {
+		users.Post("/steam", s.linkSteam())
+		users.Delete("/steam", s.unlinkSteam())
+	}
 
 	// v1/store/*
 	store := v1.Group("store")
-	store.Post("/buy", s.buyCrate())
+	store.Post("/buy", s.rateLimit(limitBuy, GetUserIDFromClaims), s.buyCrate())
+	store.Post("/sell", s.rateLimit(limitSell, GetUserIDFromClaims), s.sellItems())
 
 	// v1/tradeups/*
 	tradeups := v1.Group("tradeups")
 	tradeups.Put("/:tradeupId/add", s.addSkinToTradeup())
 	tradeups.Delete("/:tradeupId/remove", s.removeSkinFromTradeup())
+
+	// v1/fairness/*
+	fairness := v1.Group("fairness")
+	fairness.Get("/seed", s.getSeed())
+	fairness.Put("/seed", s.rotateSeed())
+	fairness.Get("/rolls", s.getRolls())
+	fairness.Post("/verify", s.verifyRoll())
+
+	// v1/admin/*
+	admin := v1.Group("admin", RequireRole(api.RoleModerator))
+	admin.Get("/users", s.searchUsers())
+	admin.Get("/users/:userId", s.adminGetUser())
+	admin.Post("/users/:userId/ban", s.banUser())
+	admin.Delete("/users/:userId/ban", s.unbanUser())
+	admin.Get("/audit", s.getAuditLog())
+	admin.Get("/ledger/drift", RequireRole(api.RoleAdmin), s.getBalanceDrift())
+
+	admin.Put("/users/:userId/role", RequireRole(api.RoleAdmin), s.setRole())
+	admin.Post("/users/:userId/balance", RequireRole(api.RoleAdmin), s.adjustBalance())
+	admin.Post("/tradeups/:tradeupId/complete", RequireRole(api.RoleAdmin), s.forceCompleteTradeup())
+	admin.Post("/tradeups/:tradeupId/cancel", RequireRole(api.RoleAdmin), s.cancelTradeup())
+	admin.Patch("/crates/:crateId", RequireRole(api.RoleAdmin), s.updateCrate())
+	admin.Put("/crates/:crateId/skins", RequireRole(api.RoleAdmin), s.setCrateSkins())
+	admin.Post("/prices", RequireRole(api.RoleAdmin), s.importPrices())
+	admin.Delete("/prices", RequireRole(api.RoleAdmin), s.clearImportedPrices())
 }
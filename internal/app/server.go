@@ -2,32 +2,58 @@ package app
 
 import (
 	"context"
-	"crypto/rsa"
 	"encoding/json"
 	"log"
 	"time"
 
 	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/erobx/csupgrade-go-api/pkg/ratelimit"
 	"github.com/gofiber/fiber/v2"
 	"github.com/valkey-io/valkey-go"
 )
 
 type Server struct {
 	addr       		string
-	privateKey 		*rsa.PrivateKey
+	keys 			*KeyRing
 	app        		*fiber.App
 	validator  		Validator
 	logger         	api.LogService
 	userService    	api.UserService
+	sessionService	api.SessionService
+	steamService	api.SteamService
+	accountService	api.AccountService
+	twoFactorService api.TwoFactorService
+	adminService	api.AdminService
+	profileService	api.ProfileService
 	storeService   	api.StoreService
 	tradeupService 	api.TradeupService
+	fairnessService	api.FairnessService
+	ledgerService	api.LedgerService
+	pricingService	api.PricingService
 	wsManager		*WebSocketManager
 	valkeyClient	valkey.Client
+	limiter			ratelimit.Store
+	rateLimits		RateLimits
 	winnings chan 	api.Winnings
 }
 
-func NewServer(addr string, privKey *rsa.PrivateKey, logger api.LogService, us api.UserService,
-	ss api.StoreService, ts api.TradeupService, w chan api.Winnings, valkeyUrl string) *Server {
+// Behind Fly the client address comes from the proxy in Fly-Client-IP. The
+// header is only believed from the given proxies, without any the connection
+// address is used.
+func newApp(trustedProxies []string) *fiber.App {
+	config := fiber.Config{ErrorHandler: ErrorHandler}
+	if len(trustedProxies) > 0 {
+		config.ProxyHeader = "Fly-Client-IP"
+		config.EnableTrustedProxyCheck = true
+		config.TrustedProxies = trustedProxies
+	}
+	return fiber.New(config)
+}
+
+func NewServer(addr string, keys *KeyRing, logger api.LogService, us api.UserService,
+	sess api.SessionService, steam api.SteamService, as api.AccountService, tf api.TwoFactorService,
+	admin api.AdminService, ps api.ProfileService, ss api.StoreService, ts api.TradeupService, fs api.FairnessService, ls api.LedgerService, pr api.PricingService, w chan api.Winnings, valkeyUrl string,
+	limiter ratelimit.Store, limits RateLimits, trustedProxies []string) *Server {
 
 	valkeyClient, err := valkey.NewClient(valkey.ClientOption{InitAddress: []string{valkeyUrl}})
 	if err != nil {
@@ -36,17 +62,34 @@ func NewServer(addr string, privKey *rsa.PrivateKey, logger api.LogService, us a
 
 	wsManager := NewWebSocketManager(valkeyClient, logger)
 
+	// Buckets live in Valkey unless a store is given, so limits are shared
+	// between instances
+	if limiter == nil {
+		limiter = ratelimit.NewValkeyStore(valkeyClient)
+	}
+
 	s := &Server{
 		addr:           addr,
-		app:            fiber.New(),
+		app:            newApp(trustedProxies),
 		validator:      NewValidator(),
-		privateKey:     privKey,
+		keys:           keys,
 		logger:         logger,
 		userService:    us,
+		sessionService: sess,
+		steamService:   steam,
+		accountService: as,
+		twoFactorService: tf,
+		adminService:   admin,
+		profileService: ps,
 		storeService:   ss,
 		tradeupService: ts,
+		fairnessService: fs,
+		ledgerService:  ls,
+		pricingService: pr,
 		wsManager: 		wsManager,
 		valkeyClient: 	valkeyClient,
+		limiter:        limiter,
+		rateLimits:     limits,
 		winnings:       w,
 	}
 
@@ -71,6 +114,9 @@ func (s *Server) Run() {
 
 	go s.tradeupService.MaintainTradeupCount()
 	go s.tradeupService.ProcessWinners()
+	go s.accountService.PurgeDeletedAccounts()
+	go s.ledgerService.Reconcile()
+	go s.pricingService.RefreshPrices()
 	go s.notifyWinners()
 
 	log.Fatal(s.app.Listen(":" + s.addr))
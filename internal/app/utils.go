@@ -15,6 +15,47 @@ func GetUserIDFromClaims(c *fiber.Ctx) string {
 	return userID
 }
 
+// Session the access token was issued for
+func GetSessionIDFromClaims(c *fiber.Ctx) string {
+	user := c.Locals("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+	sessionID, _ := claims["sid"].(string)
+	return sessionID
+}
+
+// Tokens issued before roles existed belong to regular users
+func GetRoleFromClaims(c *fiber.Ctx) string {
+	user := c.Locals("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+	role, ok := claims["role"].(string)
+	if !ok {
+		return api.RoleUser
+	}
+	return role
+}
+
+// Reads limit and offset query params, clamping limit to max
+func Pagination(c *fiber.Ctx, defaultLimit, max int) (int, int) {
+	limit := c.QueryInt("limit", defaultLimit)
+	if limit <= 0 || limit > max {
+		limit = max
+	}
+
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	return limit, offset
+}
+
+// Address the request came from. Fly-Client-IP is only read when the
+// connection is from one of the server's trusted proxies, anyone can set it
+// otherwise.
+func ClientIP(c *fiber.Ctx) string {
+	return c.IP()
+}
+
 func TradeupEqual(t1, t2 api.Tradeup) bool {
 	return reflect.DeepEqual(t1, t2)
 }
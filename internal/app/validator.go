@@ -3,10 +3,18 @@ package app
 import (
 	"errors"
 	"log"
+	"strconv"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
 )
 
 type Validator interface {
 	ValidateUserID(userID, jwtUserID string) error
+	ValidateNewUser(request *api.NewUserRequest) error
+	ValidateLogin(request *api.NewLoginRequest) error
+	ValidatePassword(field, password string) error
+	ValidateBuyCrate(crateID, amount string) error
+	ValidateTradeupItem(tradeupID, invID string) error
 }
 
 type validator struct{}
@@ -23,3 +31,123 @@ func (v *validator) ValidateUserID(userID, jwtUserID string) error {
 	}
 	return nil
 }
+
+// Checks fields the way userService.New will see them, after normalization
+func (v *validator) ValidateNewUser(request *api.NewUserRequest) error {
+	var fields fieldErrors
+	fields.email("email", api.NormalizeEmail(request.Email))
+	fields.username("username", api.NormalizeUsername(request.Username))
+	fields.password("password", request.Password)
+	return fields.err()
+}
+
+// Only checks what's needed to attempt a login. Strength rules aren't applied
+// so older passwords keep working.
+func (v *validator) ValidateLogin(request *api.NewLoginRequest) error {
+	var fields fieldErrors
+	fields.email("email", api.NormalizeEmail(request.Email))
+	if request.Password == "" {
+		fields.add("password", api.CodeRequired, "password is required")
+	}
+	return fields.err()
+}
+
+func (v *validator) ValidatePassword(field, password string) error {
+	var fields fieldErrors
+	fields.password(field, password)
+	return fields.err()
+}
+
+func (v *validator) ValidateBuyCrate(crateID, amount string) error {
+	var fields fieldErrors
+	fields.positiveInt("crateId", crateID)
+	fields.positiveInt("amount", amount)
+	if n, err := strconv.Atoi(amount); err == nil && n > api.MaxCrateAmount {
+		fields.add("amount", api.CodeTooLarge,
+			"at most "+strconv.Itoa(api.MaxCrateAmount)+" crates can be opened at once")
+	}
+	return fields.err()
+}
+
+func (v *validator) ValidateTradeupItem(tradeupID, invID string) error {
+	var fields fieldErrors
+	fields.positiveInt("tradeupId", tradeupID)
+	fields.positiveInt("invId", invID)
+	return fields.err()
+}
+
+// Collects every failing field so they're reported together
+type fieldErrors []FieldError
+
+func (f *fieldErrors) add(field, code, message string) {
+	*f = append(*f, FieldError{Field: field, Code: code, Message: message})
+}
+
+func (f fieldErrors) err() error {
+	if len(f) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: f}
+}
+
+func (f *fieldErrors) email(field, email string) {
+	if email == "" {
+		f.add(field, api.CodeRequired, "email is required")
+		return
+	}
+
+	if err := api.ValidateEmail(email); err != nil {
+		f.add(field, api.CodeInvalidEmail, err.Error())
+	}
+}
+
+func (f *fieldErrors) username(field, username string) {
+	switch {
+	case username == "":
+		f.add(field, api.CodeRequired, "username is required")
+	case len(username) < api.MinUsernameLength:
+		f.add(field, api.CodeTooShort, "username must be at least "+strconv.Itoa(api.MinUsernameLength)+" characters")
+	case len(username) > api.MaxUsernameLength:
+		f.add(field, api.CodeTooLong, "username must be at most "+strconv.Itoa(api.MaxUsernameLength)+" characters")
+	default:
+		switch err := api.ValidateUsername(username); err {
+		case api.ErrInvalidUsername:
+			f.add(field, api.CodeInvalidCharacters, err.Error())
+		case api.ErrUsernameNotAllowed:
+			f.add(field, api.CodeNotAllowed, err.Error())
+		}
+	}
+}
+
+func (f *fieldErrors) password(field, password string) {
+	if password == "" {
+		f.add(field, api.CodeRequired, "password is required")
+		return
+	}
+
+	switch err := api.ValidatePassword(password); err {
+	case api.ErrPasswordTooShort:
+		f.add(field, api.CodeTooShort, err.Error())
+	case api.ErrPasswordTooLong:
+		f.add(field, api.CodeTooLong, err.Error())
+	case api.ErrWeakPassword:
+		f.add(field, api.CodeWeakPassword, err.Error())
+	}
+}
+
+func (f *fieldErrors) positiveInt(field, value string) {
+	if value == "" {
+		f.add(field, api.CodeRequired, field+" is required")
+		return
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		f.add(field, api.CodeNotANumber, field+" must be a whole number")
+		return
+	}
+
+	if n <= 0 {
+		f.add(field, api.CodeMustBePositive, field+" must be greater than zero")
+	}
+}
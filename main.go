@@ -8,12 +8,19 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/erobx/csupgrade-go-api/internal/app"
 	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/erobx/csupgrade-go-api/pkg/blob"
 	"github.com/erobx/csupgrade-go-api/pkg/db"
+	"github.com/erobx/csupgrade-go-api/pkg/mailer"
+	"github.com/erobx/csupgrade-go-api/pkg/pricing"
+	"github.com/erobx/csupgrade-go-api/pkg/ratelimit"
 	"github.com/erobx/csupgrade-go-api/pkg/repository"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/erobx/csupgrade-go-api/pkg/steam"
 )
 
 func main() {
@@ -23,24 +30,165 @@ func main() {
 	}
 	defer db.Close()
 
-	winnings := make(chan api.Winnings, 1)
+	winnings := make(chan api.Winnings, 64)
 
 	cdnUrl := os.Getenv("SKINS_CDN_URL")
 	storage := repository.NewStorage(db, cdnUrl)
 	logService := api.NewLogger()
-	userService := api.NewUserService(storage, logService)
-	storeService := api.NewStoreService(storage, logService)
-	tradeupService := api.NewTradeupService(storage, winnings, logService)
+	// stats are cached per instance and dropped when a tradeup completes or a
+	// crate is opened
+	statsCache := api.NewStatsCache(5 * time.Minute)
 
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(os.Getenv("RSA_PRIVATE_KEY")))
+	// Prices are loaded from PRICE_SOURCE at startup and every
+	// PRICE_REFRESH_INTERVAL after, and can be imported by admins. An import
+	// is used instead of the source until it's cleared.
+	priceSource, refreshInterval, err := newPriceSource()
+	if err != nil {
+		log.Fatal(err)
+	}
+	pricingService := api.NewPricingService(pricing.NewEngine(pricing.DefaultConfig()), priceSource,
+		storage, refreshInterval, logService)
+	if err := pricingService.Load(); err != nil {
+		log.Println("couldn't load prices:", err)
+	}
+
+	userService := api.NewUserService(storage, pricingService, statsCache, logService)
+	sessionService := api.NewSessionService(storage, logService)
+
+	mailer, err := newMailer()
+	if err != nil {
+		log.Fatal(err)
+	}
+	twoFactorService := api.NewTwoFactorService(storage, logService)
+	blobs, err := newBlobStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	accountService := api.NewAccountService(storage, mailer, twoFactorService, blobs, os.Getenv("APP_URL"),
+		logService)
+
+	// Sign in with Steam is only enabled when a return url is configured
+	var steamService api.SteamService
+	if returnTo := os.Getenv("STEAM_RETURN_URL"); returnTo != "" {
+		steamClient, err := steam.NewClient(os.Getenv("STEAM_OPENID_URL"), os.Getenv("STEAM_API_URL"),
+			os.Getenv("STEAM_API_KEY"), returnTo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		steamService = api.NewSteamService(steamClient, storage, logService)
+	}
+
+	// CRATE_RARITY_WEIGHTS overrides the CS2 case odds, e.g. "Covert=64"
+	rarityWeights := api.DefaultRarityWeights()
+	if err := rarityWeights.Apply(os.Getenv("CRATE_RARITY_WEIGHTS")); err != nil {
+		log.Fatalln(err)
+	}
+	fairnessService := api.NewFairnessService(storage, logService)
+	ledgerService := api.NewLedgerService(storage, logService)
+	// SELL_PERCENT is how much of an item's current price the house pays for it
+	sellPercent := api.DefaultSellPercent
+	if s := os.Getenv("SELL_PERCENT"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 100 {
+			log.Fatalf("invalid SELL_PERCENT %q, expected 1-100", s)
+		}
+		sellPercent = n
+	}
+	storeService := api.NewStoreService(storage, rarityWeights, fairnessService, pricingService, sellPercent,
+		statsCache, logService)
+	tradeupService := api.NewTradeupService(storage, fairnessService, pricingService, winnings, statsCache,
+		logService)
+	adminService := api.NewAdminService(storage, tradeupService, pricingService, logService)
+	profileService := api.NewProfileService(storage, userService, pricingService, blobs, logService)
+
+	// Tokens are signed with RSA_PRIVATE_KEY, keys in RSA_PREVIOUS_KEYS are
+	// only used to verify tokens issued before a rotation
+	keys, err := app.ParseKeyRing(os.Getenv("RSA_PRIVATE_KEY"), os.Getenv("RSA_PREVIOUS_KEYS"))
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	server := app.NewServer("8080", privateKey, logService, userService, storeService, tradeupService, winnings)
+	// RATE_LIMIT_STORE=memory keeps buckets in process for single instance
+	// deployments, RATE_LIMITS overrides the per-route defaults
+	var limiter ratelimit.Store
+	if os.Getenv("RATE_LIMIT_STORE") == "memory" {
+		limiter = ratelimit.NewMemoryStore()
+	}
+
+	limits := app.DefaultRateLimits()
+	if err := limits.Apply(os.Getenv("RATE_LIMITS")); err != nil {
+		log.Fatalln(err)
+	}
+
+	// Comma separated IPs or CIDRs of the proxies allowed to set the client
+	// address
+	var trustedProxies []string
+	if s := os.Getenv("TRUSTED_PROXIES"); s != "" {
+		for _, proxy := range strings.Split(s, ",") {
+			trustedProxies = append(trustedProxies, strings.TrimSpace(proxy))
+		}
+	}
+
+	server := app.NewServer("8080", keys, logService, userService, sessionService, steamService,
+		accountService, twoFactorService, adminService, profileService, storeService, tradeupService, fairnessService, ledgerService, pricingService, winnings, os.Getenv("VALKEY_URL"),
+		limiter, limits, trustedProxies)
 	server.Run()
 }
 
+// SMTP when SMTP_ADDR is set, otherwise mail is written to MAIL_DIR for
+// local development
+func newMailer() (api.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		return mailer.NewSMTPMailer(addr, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from)
+	}
+
+	dir := os.Getenv("MAIL_DIR")
+	if dir == "" {
+		dir = "tmp/mail"
+	}
+	return mailer.NewFileMailer(dir, from)
+}
+
+// S3-compatible storage when BLOB_S3_ENDPOINT is set, otherwise blobs are
+// written to BLOB_DIR for local development
+func newBlobStore() (api.BlobStore, error) {
+	if endpoint := os.Getenv("BLOB_S3_ENDPOINT"); endpoint != "" {
+		return blob.NewS3Store(endpoint, os.Getenv("BLOB_S3_BUCKET"), os.Getenv("BLOB_S3_REGION"),
+			os.Getenv("BLOB_S3_ACCESS_KEY"), os.Getenv("BLOB_S3_SECRET_KEY"))
+	}
+
+	dir := os.Getenv("BLOB_DIR")
+	if dir == "" {
+		dir = "tmp/blobs"
+	}
+	return blob.NewFSStore(dir)
+}
+
+// An http(s) url or a CSV or JSON file. Without PRICE_SOURCE skins are only
+// priced once a list is imported.
+func newPriceSource() (pricing.PriceSource, time.Duration, error) {
+	interval := time.Hour
+	if s := os.Getenv("PRICE_REFRESH_INTERVAL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, 0, fmt.Errorf("invalid PRICE_REFRESH_INTERVAL %q", s)
+		}
+		interval = d
+	}
+
+	src := os.Getenv("PRICE_SOURCE")
+	switch {
+	case src == "":
+		return nil, interval, nil
+	case strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://"):
+		return pricing.NewHTTPSource(src), interval, nil
+	case pricing.FormatOf(src) == "":
+		return nil, 0, fmt.Errorf("PRICE_SOURCE %q isn't a url, .csv or .json file", src)
+	}
+	return pricing.FileSource{Path: src}, interval, nil
+}
+
 func generate() {
 	priv, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
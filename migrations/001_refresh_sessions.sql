@@ -0,0 +1,14 @@
+-- Long-lived refresh sessions. Only a hash of the refresh token is stored and
+-- token_version snapshots users.refresh_token_version at issue time.
+create table if not exists refresh_sessions (
+	id uuid primary key,
+	user_id uuid not null references users(id) on delete cascade,
+	token_hash text not null unique,
+	token_version int not null,
+	created_at timestamptz not null default now(),
+	last_used_at timestamptz not null default now(),
+	expires_at timestamptz not null,
+	revoked_at timestamptz
+);
+
+create index if not exists refresh_sessions_user_id_idx on refresh_sessions(user_id);
@@ -0,0 +1,3 @@
+-- Device details shown on the sessions page
+alter table refresh_sessions add column if not exists user_agent text not null default '';
+alter table refresh_sessions add column if not exists ip_address text not null default '';
@@ -0,0 +1,5 @@
+-- Sign in with Steam. Steam-only users have neither email nor password.
+alter table users add column if not exists steam_id text unique;
+alter table users add column if not exists steam_persona text;
+alter table users alter column email drop not null;
+alter table users alter column hash drop not null;
@@ -0,0 +1,15 @@
+alter table users add column if not exists email_verified boolean not null default false;
+
+-- Single-use tokens mailed to users. email records the address the token was
+-- sent to so a verification can't apply to an address changed since.
+create table if not exists user_tokens (
+	token_hash text primary key,
+	user_id uuid not null references users(id) on delete cascade,
+	purpose text not null,
+	email text not null default '',
+	created_at timestamptz not null default now(),
+	expires_at timestamptz not null,
+	used_at timestamptz
+);
+
+create index if not exists user_tokens_user_id_idx on user_tokens(user_id, purpose);
@@ -0,0 +1,12 @@
+-- TOTP 2FA. totp_secret is set on enrollment, totp_enabled only flips once a
+-- code is confirmed. totp_last_step stops a code being used twice.
+alter table users add column if not exists totp_secret text;
+alter table users add column if not exists totp_enabled boolean not null default false;
+alter table users add column if not exists totp_last_step bigint not null default 0;
+
+create table if not exists user_recovery_codes (
+	user_id uuid not null references users(id) on delete cascade,
+	code_hash text not null,
+	used_at timestamptz,
+	primary key (user_id, code_hash)
+);
@@ -0,0 +1,17 @@
+alter table users add column if not exists role text not null default 'user'
+	check (role in ('user', 'moderator', 'admin'));
+alter table users add column if not exists banned_at timestamptz;
+alter table users add column if not exists ban_reason text;
+
+-- Who did what through the admin API. Never updated or deleted.
+create table if not exists admin_audit_log (
+	id bigserial primary key,
+	actor_id uuid not null references users(id),
+	action text not null,
+	target_type text not null,
+	target_id text not null,
+	details jsonb not null default '{}',
+	created_at timestamptz not null default now()
+);
+
+create index if not exists admin_audit_log_target_idx on admin_audit_log(target_type, target_id);
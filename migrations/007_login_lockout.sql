@@ -0,0 +1,3 @@
+-- Progressive lockout after repeated failed logins
+alter table users add column if not exists failed_logins int not null default 0;
+alter table users add column if not exists locked_until timestamptz;
@@ -0,0 +1,11 @@
+-- One row per crate purchase so stats can count openings and spend
+create table if not exists crate_openings (
+	id bigserial primary key,
+	user_id uuid not null references users(id),
+	crate_id int not null references crates(id),
+	amount int not null,
+	cost numeric not null,
+	created_at timestamptz not null default now()
+);
+
+create index if not exists crate_openings_user_idx on crate_openings(user_id);
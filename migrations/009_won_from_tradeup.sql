@@ -0,0 +1,5 @@
+-- Links items won through a tradeup back to it. Older won items stay null.
+alter table inventory add column if not exists won_from_tradeup int references tradeups(id);
+alter table inventory add column if not exists won_avg_float numeric;
+
+create index if not exists inventory_won_idx on inventory(user_id, created_at desc) where was_won;
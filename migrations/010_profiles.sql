@@ -0,0 +1,13 @@
+-- Privacy settings for the public profile
+alter table users add column if not exists hide_inventory boolean not null default false;
+alter table users add column if not exists hide_stats boolean not null default false;
+
+-- Items a user pins to their profile, in display order
+create table if not exists user_showcase (
+	user_id uuid not null references users(id) on delete cascade,
+	inv_id int not null references inventory(id) on delete cascade,
+	position int not null,
+	primary key (user_id, inv_id)
+);
+
+create index if not exists users_username_lower_idx on users(lower(username));
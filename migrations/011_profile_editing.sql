@@ -0,0 +1,38 @@
+-- Emails are compared case-insensitively from now on. Registration already
+-- refused exact duplicates, but addresses that only differ by case would make
+-- the index fail halfway through. Both accounts log in with that email, so
+-- they can't be renamed automatically; the migration stops and lists them to
+-- be merged or changed by hand first.
+do $$
+declare
+	duplicates text;
+begin
+	select string_agg(email, ', ') into duplicates
+	from (
+		select lower(trim(email)) as email from users
+		where email is not null
+		group by lower(trim(email))
+		having count(*) > 1
+	) d;
+
+	if duplicates is not null then
+		raise exception 'emails used by more than one account ignoring case, fix them before migrating: %', duplicates;
+	end if;
+end $$;
+
+update users set email = lower(trim(email)) where email is not null;
+create unique index if not exists users_email_unique_idx on users(email);
+
+-- Usernames become unique ignoring case. Older duplicates (mostly Steam
+-- personas) get a suffix, the oldest account keeps the name.
+update users u set username = left(u.username, 13) || '_' || left(replace(u.id::text, '-', ''), 6)
+from (
+	select id, row_number() over (partition by lower(username) order by created_at) as n
+	from users
+) d
+where d.id = u.id and d.n > 1;
+
+drop index if exists users_username_lower_idx;
+create unique index if not exists users_username_unique_idx on users(lower(username));
+
+alter table users add column if not exists username_changed_at timestamptz;
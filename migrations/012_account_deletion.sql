@@ -0,0 +1,7 @@
+-- Self-service deletion. delete_after is set when the user asks, the account
+-- is anonymized once it passes and deleted_at records when that happened.
+alter table users add column if not exists delete_after timestamptz;
+alter table users add column if not exists deleted_at timestamptz;
+
+create index if not exists users_delete_after_idx on users(delete_after)
+	where delete_after is not null;
@@ -0,0 +1,4 @@
+-- Relative drop weight of each skin in a crate. A skin's odds are its weight
+-- over the crate's total, existing pools keep equal odds.
+alter table crate_skins add column if not exists weight int not null default 1
+	check (weight > 0);
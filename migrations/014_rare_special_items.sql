@@ -0,0 +1,20 @@
+-- Knives and gloves get their own tier above Covert. They were stored as
+-- Covert, which also let Covert tradeups pay out knives.
+--
+-- Knives already sitting in open Covert tradeups would go in as Rare Special,
+-- so they go back to their owners' inventories first. Waiting tradeups that
+-- are no longer full stop their timer, the way pulling an item out does.
+with pulled as (
+	delete from tradeups_skins ts
+	using tradeups t, inventory i, skins s
+	where t.id = ts.tradeup_id and i.id = ts.inv_id and s.id = i.skin_id
+		and t.current_status not in ('Completed', 'Cancelled')
+		and s.name like '★%' and s.rarity <> 'Rare Special'
+	returning ts.tradeup_id, ts.inv_id
+), shown as (
+	update inventory set visible = true where id in (select inv_id from pulled)
+)
+update tradeups set stop_time = now() + interval '5 year', current_status = 'Active'
+where id in (select tradeup_id from pulled) and current_status = 'Waiting';
+
+update skins set rarity = 'Rare Special' where name like '★%' and rarity <> 'Rare Special';
@@ -0,0 +1,37 @@
+-- Server seeds are committed to by their hash before any roll uses them and
+-- revealed when rotated out. User seeds roll crates, one seed per tradeup
+-- rolls its winner and prize.
+create table if not exists fair_seeds (
+	id bigserial primary key,
+	user_id uuid references users(id),
+	tradeup_id int references tradeups(id),
+	server_seed text not null,
+	server_seed_hash text not null,
+	client_seed text not null default '',
+	-- next nonce to hand out
+	nonce bigint not null default 0,
+	created_at timestamptz not null default now(),
+	revealed_at timestamptz,
+	check ((user_id is null) <> (tradeup_id is null))
+);
+
+create unique index if not exists fair_seeds_active_user_idx on fair_seeds(user_id)
+	where revealed_at is null and user_id is not null;
+create unique index if not exists fair_seeds_tradeup_idx on fair_seeds(tradeup_id)
+	where tradeup_id is not null;
+
+-- Every random outcome with what it was rolled from, so it can be recomputed
+-- once its seed is revealed
+create table if not exists fair_rolls (
+	id bigserial primary key,
+	seed_id bigint not null references fair_seeds(id),
+	user_id uuid references users(id),
+	game text not null check (game in ('crate', 'tradeup')),
+	reference text not null,
+	nonce bigint not null,
+	inputs jsonb not null,
+	outcome jsonb not null,
+	created_at timestamptz not null default now()
+);
+
+create index if not exists fair_rolls_user_idx on fair_rolls(user_id, id desc);
@@ -0,0 +1,81 @@
+-- Every balance change is a transaction of entries that sum to zero: one on
+-- the user and one on the system account paying or being paid. Amounts are
+-- in cents, positive credits the account.
+create table if not exists ledger_transactions (
+	id bigserial primary key,
+	reason text not null check (reason in ('crate_purchase', 'sale', 'deposit',
+		'admin_adjustment', 'tradeup_payout', 'opening_balance')),
+	-- crate opening, inventory item, admin and so on, depending on reason
+	reference text not null default '',
+	created_at timestamptz not null default now()
+);
+
+create table if not exists ledger_entries (
+	id bigserial primary key,
+	transaction_id bigint not null references ledger_transactions(id),
+	user_id uuid references users(id),
+	account text,
+	amount bigint not null check (amount <> 0),
+	-- the user's balance once this entry was applied
+	balance_after bigint,
+	created_at timestamptz not null default now(),
+	check ((user_id is null) <> (account is null)),
+	check ((user_id is null) = (balance_after is null))
+);
+
+create index if not exists ledger_entries_user_idx on ledger_entries(user_id, id desc)
+	where user_id is not null;
+create index if not exists ledger_entries_transaction_idx on ledger_entries(transaction_id);
+
+-- users.balance becomes a cache of the user's ledger entries, kept in cents
+alter table users add column if not exists balance_cents bigint not null default 0
+	check (balance_cents >= 0);
+update users set balance_cents = round(balance * 100);
+
+-- Existing balances are carried over as one opening entry each
+with opening as (
+	insert into ledger_transactions(reason, reference)
+	select 'opening_balance', id::text from users where balance_cents <> 0
+	returning id, reference
+)
+insert into ledger_entries(transaction_id, user_id, account, amount, balance_after)
+select o.id, u.id, null, u.balance_cents, u.balance_cents
+from opening o join users u on u.id::text = o.reference
+union all
+select o.id, null, 'adjustments', -u.balance_cents, null
+from opening o join users u on u.id::text = o.reference;
+
+alter table users drop column if exists balance;
+
+-- Checked at commit so both sides of a transaction can be inserted first
+create or replace function ledger_check_balanced() returns trigger as $$
+begin
+	if (select sum(amount) from ledger_entries where transaction_id = new.transaction_id) <> 0 then
+		raise exception 'ledger transaction % does not balance', new.transaction_id;
+	end if;
+	return null;
+end;
+$$ language plpgsql;
+
+drop trigger if exists ledger_entries_balanced on ledger_entries;
+create constraint trigger ledger_entries_balanced
+	after insert on ledger_entries
+	deferrable initially deferred
+	for each row execute function ledger_check_balanced();
+
+-- Mistakes are fixed with a new transaction, never by editing an old one
+create or replace function ledger_immutable() returns trigger as $$
+begin
+	raise exception 'ledger rows cannot be changed';
+end;
+$$ language plpgsql;
+
+drop trigger if exists ledger_transactions_immutable on ledger_transactions;
+create trigger ledger_transactions_immutable
+	before update or delete on ledger_transactions
+	for each row execute function ledger_immutable();
+
+drop trigger if exists ledger_entries_immutable on ledger_entries;
+create trigger ledger_entries_immutable
+	before update or delete on ledger_entries
+	for each row execute function ledger_immutable();
@@ -0,0 +1,10 @@
+-- Prices and costs move to integer cents like balances, summing floats
+-- drifted after enough purchases
+alter table inventory alter column price type bigint using round(price * 100);
+alter table inventory rename column price to price_cents;
+
+alter table crates alter column cost type bigint using round(cost * 100);
+alter table crates rename column cost to cost_cents;
+
+alter table crate_openings alter column cost type bigint using round(cost * 100);
+alter table crate_openings rename column cost to cost_cents;
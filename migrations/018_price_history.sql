@@ -0,0 +1,25 @@
+-- Every price the pricing engine had after each update, one row per skin,
+-- wear and StatTrak. Prices are for the middle of the wear, in cents.
+create table if not exists price_snapshots (
+	id bigserial primary key,
+	skin_id int not null references skins(id),
+	wear text not null,
+	is_stattrak boolean not null,
+	price_cents bigint not null check (price_cents > 0),
+	taken_at timestamptz not null default now()
+);
+
+create index if not exists price_snapshots_skin_idx on price_snapshots(skin_id, taken_at);
+
+-- When an item left the inventory, so past inventory values only count what
+-- the user held at the time
+alter table inventory add column if not exists removed_at timestamptz;
+
+update inventory i set removed_at = t.stop_time
+from tradeups_skins ts
+join tradeups t on t.id = ts.tradeup_id
+where ts.inv_id = i.id and i.was_used and t.current_status = 'Completed'
+	and i.removed_at is null;
+
+-- nothing better to go on for the rest
+update inventory set removed_at = created_at where was_used and removed_at is null;
@@ -0,0 +1,3 @@
+-- Items sold back to the house. They're marked used as well so everything
+-- that skips used items skips sold ones.
+alter table inventory add column if not exists was_sold boolean not null default false;
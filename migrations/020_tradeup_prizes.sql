@@ -0,0 +1,5 @@
+-- The skins a tradeup can give out, fixed when its seed is committed so the
+-- prize pool can't change between the first item going in and the roll.
+-- Null for tradeups from before, which roll from the skins there are when
+-- they complete.
+alter table fair_seeds add column if not exists prizes jsonb;
@@ -0,0 +1,14 @@
+-- Failed logins are counted per email and IP instead of per account, so a
+-- guesser can't lock the owner out everywhere and unknown emails lock the
+-- same way as real ones
+create table if not exists login_failures (
+	email text not null,
+	ip_address text not null,
+	failures int not null default 0,
+	locked_until timestamptz,
+	last_failed_at timestamptz not null default now(),
+	primary key (email, ip_address)
+);
+
+alter table users drop column if exists failed_logins;
+alter table users drop column if exists locked_until;
@@ -0,0 +1,8 @@
+-- Price lists imported by admins. The latest uncleared one is used instead of
+-- the price source, and loaded again on startup.
+create table if not exists price_imports (
+	id bigserial primary key,
+	entries jsonb not null,
+	imported_at timestamptz not null default now(),
+	cleared_at timestamptz
+);
@@ -0,0 +1,26 @@
+-- Price history only ever shows one price per day, so only the day's close is
+-- kept instead of every update. A later update the same day replaces it.
+-- Closes older than the retention period (two years, PriceHistoryRetention)
+-- are deleted whenever prices are updated.
+create table if not exists price_closes (
+	skin_id int not null references skins(id),
+	wear text not null,
+	is_stattrak boolean not null,
+	-- UTC
+	day date not null,
+	price_cents bigint not null check (price_cents > 0),
+	taken_at timestamptz not null,
+	primary key (skin_id, wear, is_stattrak, day)
+);
+
+create index if not exists price_closes_day_idx on price_closes(day);
+
+insert into price_closes(skin_id, wear, is_stattrak, day, price_cents, taken_at)
+select distinct on (skin_id, wear, is_stattrak, (taken_at at time zone 'UTC')::date)
+	skin_id, wear, is_stattrak, (taken_at at time zone 'UTC')::date, price_cents, taken_at
+from price_snapshots
+where taken_at >= now() - interval '2 years'
+order by skin_id, wear, is_stattrak, (taken_at at time zone 'UTC')::date, taken_at desc
+on conflict do nothing;
+
+drop table if exists price_snapshots;
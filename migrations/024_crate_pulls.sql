@@ -0,0 +1,10 @@
+-- Links skins pulled from crates to the opening they came from, so crate
+-- spend can be split by the rarity of what was pulled
+alter table inventory add column if not exists crate_opening_id bigint references crate_openings(id);
+
+-- Crate skins and their opening were written in one transaction, so they
+-- share its now()
+update inventory i set crate_opening_id = co.id
+from crate_openings co
+where co.user_id = i.user_id and co.created_at = i.created_at
+	and i.crate_opening_id is null and not i.was_won;
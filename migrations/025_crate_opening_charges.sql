@@ -0,0 +1,14 @@
+-- Bulk purchases used to charge for one crate whatever the amount, and
+-- recorded that one crate's cost. cost_cents is still what was charged, so
+-- those rows are marked rather than rewritten with money nobody paid.
+-- Purchases charged for every crate already cost at least amount crates, so
+-- only the ones short of that are marked. Rows that already exist get marked
+-- once, later ones default to false.
+alter table crate_openings add column if not exists charged_once boolean;
+
+update crate_openings co set charged_once = co.amount > 1 and co.cost_cents < c.cost_cents * co.amount
+from crates c
+where c.id = co.crate_id and co.charged_once is null;
+
+alter table crate_openings alter column charged_once set default false;
+alter table crate_openings alter column charged_once set not null;
@@ -0,0 +1,391 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+	TokenChangeEmail   = "change_email"
+
+	verifyEmailTTL   = 24 * time.Hour
+	resetPasswordTTL = time.Hour
+
+	// How long users wait between username changes
+	UsernameCooldown = 30 * 24 * time.Hour
+
+	// How recently Steam-only accounts, which have no password to confirm,
+	// must have logged in to change their login details
+	ReauthWindow = 10 * time.Minute
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Delivers transactional email
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Responsible for email verification, password recovery and changes to a
+// user's login details
+type AccountService interface {
+	SendVerification(userID string) error
+	VerifyEmail(token string) error
+	ForgotPassword(email string) error
+	ResetPassword(request *ResetPasswordRequest) error
+	ChangeUsername(userID, username string) (User, error)
+	ChangeEmail(userID, sessionID string, request *ChangeEmailRequest) error
+	ConfirmEmailChange(token string) error
+	ChangePassword(userID, sessionID string, request *ChangePasswordRequest) error
+	Export(userID string) (AccountExport, error)
+	RequestDeletion(userID, sessionID string, request *DeleteAccountRequest) (time.Time, error)
+	CancelDeletion(userID string) error
+	PurgeDeletedAccounts()
+}
+
+type AccountRepository interface {
+	GetUserByID(userID string) (User, error)
+	GetUserAndHashByEmail(email string) (User, string, error)
+	GetUserAndHashByID(userID string) (User, string, error)
+	CreateUserToken(userID, tokenHash, purpose, email string, expiresAt time.Time) error
+	GetUserToken(tokenHash, purpose string) (UserToken, error)
+	UseUserToken(tokenHash, purpose string) (UserToken, error)
+	UseVerifyEmailToken(tokenHash string) (UserToken, error)
+	UpdatePassword(userID, password string) error
+	UpdateUsername(userID, username string, recase bool) error
+	UseEmailChangeToken(tokenHash string) (UserToken, error)
+	GetInventory(userID string) (Inventory, error)
+	GetActiveSessions(userID string) ([]Session, error)
+	GetTradeupEntries(userID string) ([]TradeupEntry, error)
+	GetCratePurchases(userID string) ([]CratePurchase, error)
+	GetBalanceHistory(userID string) ([]BalanceChange, error)
+	ScheduleDeletion(userID string, at time.Time) error
+	CancelDeletion(userID string) error
+	GetDueDeletions() ([]string, error)
+	PurgeUser(userID string) (string, error)
+}
+
+type accountService struct {
+	storage   AccountRepository
+	mailer    Mailer
+	twoFactor TwoFactorService
+	blobs     BlobStore
+	appUrl    string
+	logger    LogService
+}
+
+// appUrl is the frontend base url links in emails point to. Uploaded avatars
+// are removed from blobs when an account is deleted.
+func NewAccountService(accountRepo AccountRepository, mailer Mailer, twoFactor TwoFactorService,
+	blobs BlobStore, appUrl string, logger LogService) AccountService {
+	return &accountService{
+		storage:   accountRepo,
+		mailer:    mailer,
+		twoFactor: twoFactor,
+		blobs:     blobs,
+		appUrl:    strings.TrimSuffix(appUrl, "/"),
+		logger:    logger,
+	}
+}
+
+// Mails a verification link for the user's current email
+func (a *accountService) SendVerification(userID string) error {
+	user, err := a.storage.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if user.Email == "" || user.EmailVerified {
+		return nil
+	}
+
+	token, err := a.issueToken(user.ID, TokenVerifyEmail, user.Email, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	return a.mailer.Send(Message{
+		To:      user.Email,
+		Subject: "Verify your csupgrade email",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening the link below:\n\n%s/verify-email?token=%s\n\nThe link expires in 24 hours.\n",
+			user.Username, a.appUrl, token),
+	})
+}
+
+// Marks the email the link was sent to as verified. Links for an email the
+// user has since moved away from are invalid.
+func (a *accountService) VerifyEmail(token string) error {
+	userToken, err := a.storage.UseVerifyEmailToken(HashToken(token))
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	a.logger.Info("verified email", "user", userToken.UserID)
+	return nil
+}
+
+// Mails a password reset link. Unknown emails are not reported back so the
+// endpoint can't be used to find out who has an account.
+func (a *accountService) ForgotPassword(email string) error {
+	email = NormalizeEmail(email)
+
+	user, _, err := a.storage.GetUserAndHashByEmail(email)
+	if err != nil {
+		a.logger.Info("password reset requested for unknown email")
+		return nil
+	}
+
+	token, err := a.issueToken(user.ID, TokenResetPassword, user.Email, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+
+	return a.mailer.Send(Message{
+		To:      user.Email,
+		Subject: "Reset your csupgrade password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your account. If that was you, open the link below:\n\n%s/reset-password?token=%s\n\nThe link expires in 1 hour. If you didn't ask for this you can ignore this email.\n",
+			user.Username, a.appUrl, token),
+	})
+}
+
+// Sets a new password from a reset token. Accounts with 2FA also need a code
+// so a compromised inbox isn't enough. Changing the password logs the user out
+// everywhere.
+func (a *accountService) ResetPassword(request *ResetPasswordRequest) error {
+	if err := ValidatePassword(request.Password); err != nil {
+		return err
+	}
+
+	tokenHash := HashToken(request.Token)
+	userToken, err := a.storage.GetUserToken(tokenHash, TokenResetPassword)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	user, err := a.storage.GetUserByID(userToken.UserID)
+	if err != nil {
+		return err
+	}
+
+	if user.TwoFactorEnabled {
+		if request.Code == "" {
+			return ErrTwoFactorRequired
+		}
+
+		if err := a.twoFactor.Verify(user.ID, request.Code); err != nil {
+			return err
+		}
+	}
+
+	// consuming is what makes the token single-use, the lookup above only
+	// keeps a wrong 2FA code from burning it
+	userToken, err = a.storage.UseUserToken(tokenHash, TokenResetPassword)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	err = a.storage.UpdatePassword(userToken.UserID, request.Password)
+	if err != nil {
+		return err
+	}
+
+	a.logger.Info("reset password", "user", userToken.UserID)
+	return nil
+}
+
+// Usernames can only change once per UsernameCooldown. Changing only the case
+// of the current name is always allowed and doesn't restart the cooldown.
+func (a *accountService) ChangeUsername(userID, username string) (User, error) {
+	username = NormalizeUsername(username)
+	if err := ValidateUsername(username); err != nil {
+		return User{}, err
+	}
+
+	user, err := a.storage.GetUserByID(userID)
+	if err != nil {
+		return user, err
+	}
+
+	if username == user.Username {
+		return user, nil
+	}
+
+	recase := strings.EqualFold(username, user.Username)
+	if !recase && user.UsernameChangedAt != nil {
+		next := user.UsernameChangedAt.Add(UsernameCooldown)
+		if time.Now().Before(next) {
+			return user, &CooldownError{RetryAfter: time.Until(next)}
+		}
+	}
+
+	err = a.storage.UpdateUsername(userID, username, recase)
+	if err != nil {
+		return user, err
+	}
+
+	a.logger.Info("changed username", "user", userID, "from", user.Username, "to", username)
+	return a.storage.GetUserByID(userID)
+}
+
+// Starts moving the account to a new email. The user has to reauthenticate,
+// and nothing changes until the link mailed to the new address is opened. A
+// taken address is mailed a notice instead of a link, so the caller can't
+// tell whether it has an account.
+func (a *accountService) ChangeEmail(userID, sessionID string, request *ChangeEmailRequest) error {
+	email := NormalizeEmail(request.Email)
+	if err := ValidateEmail(email); err != nil {
+		return err
+	}
+
+	user, err := a.reauthenticate(userID, sessionID, request.Password, request.Code)
+	if err != nil {
+		return err
+	}
+
+	if email == user.Email {
+		return nil
+	}
+
+	if _, _, err := a.storage.GetUserAndHashByEmail(email); err == nil {
+		a.logger.Info("email change requested to a taken address", "user", userID)
+		return a.mailer.Send(Message{
+			To:      email,
+			Subject: "Someone tried to use your email on csupgrade",
+			Body:    "Hi,\n\nSomeone asked to move their csupgrade account to this address, but it already belongs to an account. If that was you, log in with this address instead. Otherwise you can ignore this email.\n",
+		})
+	}
+
+	token, err := a.issueToken(userID, TokenChangeEmail, email, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	err = a.mailer.Send(Message{
+		To:      email,
+		Subject: "Confirm your new csupgrade email",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm this is your new email address by opening the link below:\n\n%s/confirm-email?token=%s\n\nThe link expires in 24 hours.\n",
+			user.Username, a.appUrl, token),
+	})
+	if err != nil {
+		return err
+	}
+	a.logger.Info("requested email change", "user", userID)
+
+	if user.Email != "" {
+		err = a.mailer.Send(Message{
+			To:      user.Email,
+			Subject: "Your csupgrade email is being changed",
+			Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to change the email on your account to %s. It changes once the new address is confirmed. If you didn't do this, reset your password and contact support.\n",
+				user.Username, email),
+		})
+		if err != nil {
+			a.logger.Error("couldn't notify old email", "user", userID, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// Moves the account to the email a change link was sent to. Opening the link
+// proves the address, so it's verified straight away.
+func (a *accountService) ConfirmEmailChange(token string) error {
+	userToken, err := a.storage.UseEmailChangeToken(HashToken(token))
+	if errors.Is(err, ErrEmailTaken) {
+		return err
+	}
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	a.logger.Info("changed email", "user", userToken.UserID)
+	return nil
+}
+
+// Sets a new password after checking the current one. Like a reset, this logs
+// the user out everywhere.
+func (a *accountService) ChangePassword(userID, sessionID string, request *ChangePasswordRequest) error {
+	if err := ValidatePassword(request.NewPassword); err != nil {
+		return onField(err, "newPassword")
+	}
+
+	_, err := a.reauthenticate(userID, sessionID, request.CurrentPassword, request.Code)
+	if err != nil {
+		return err
+	}
+
+	err = a.storage.UpdatePassword(userID, request.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	a.logger.Info("changed password", "user", userID)
+	return nil
+}
+
+// Confirms the user knows their password, and has their 2FA device if it's on.
+// Steam-only accounts have no password, so they have to have logged in within
+// ReauthWindow instead and a stolen access token isn't enough to take over
+// the account.
+func (a *accountService) reauthenticate(userID, sessionID, password, code string) (User, error) {
+	user, hash, err := a.storage.GetUserAndHashByID(userID)
+	if err != nil {
+		return user, err
+	}
+
+	if hash != "" {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return user, ErrInvalidPassword
+		}
+	} else if err := a.requireRecentLogin(userID, sessionID); err != nil {
+		return user, err
+	}
+
+	if user.TwoFactorEnabled {
+		if err := a.twoFactor.RequireFreshCode(userID, code); err != nil {
+			return user, err
+		}
+	}
+
+	return user, nil
+}
+
+// The session has to have started within ReauthWindow. Refreshing keeps a
+// session going, only logging in again starts a new one.
+func (a *accountService) requireRecentLogin(userID, sessionID string) error {
+	sessions, err := a.storage.GetActiveSessions(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.ID == sessionID && time.Since(session.CreatedAt) < ReauthWindow {
+			return nil
+		}
+	}
+
+	return ErrReauthRequired
+}
+
+func (a *accountService) issueToken(userID, purpose, email string, ttl time.Duration) (string, error) {
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = a.storage.CreateUserToken(userID, hash, purpose, email, time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
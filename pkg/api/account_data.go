@@ -0,0 +1,126 @@
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// How long a deletion request can be cancelled for
+	AccountDeletionGrace = 14 * 24 * time.Hour
+
+	purgeInterval = time.Hour
+)
+
+// Everything stored about the user, for data export requests
+func (a *accountService) Export(userID string) (AccountExport, error) {
+	var export AccountExport
+	var err error
+
+	export.ExportedAt = time.Now()
+
+	export.User, err = a.storage.GetUserByID(userID)
+	if err != nil {
+		return export, err
+	}
+
+	export.Inventory, err = a.storage.GetInventory(userID)
+	if err != nil {
+		return export, err
+	}
+
+	export.Tradeups, err = a.storage.GetTradeupEntries(userID)
+	if err != nil {
+		return export, err
+	}
+
+	export.CratePurchases, err = a.storage.GetCratePurchases(userID)
+	if err != nil {
+		return export, err
+	}
+
+	export.BalanceHistory, err = a.storage.GetBalanceHistory(userID)
+	if err != nil {
+		return export, err
+	}
+
+	export.Sessions, err = a.storage.GetActiveSessions(userID)
+	if err != nil {
+		return export, err
+	}
+
+	a.logger.Info("exported account data", "user", userID)
+	return export, nil
+}
+
+// Schedules the account for deletion once AccountDeletionGrace has passed.
+// Needs the same confirmation as changing the password. Returns when the
+// account will be deleted.
+func (a *accountService) RequestDeletion(userID, sessionID string, request *DeleteAccountRequest) (time.Time, error) {
+	user, err := a.reauthenticate(userID, sessionID, request.Password, request.Code)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if user.DeleteAfter != nil {
+		return *user.DeleteAfter, nil
+	}
+
+	deleteAfter := time.Now().Add(AccountDeletionGrace)
+	err = a.storage.ScheduleDeletion(userID, deleteAfter)
+	if err != nil {
+		return deleteAfter, err
+	}
+	a.logger.Info("scheduled account deletion", "user", userID, "at", deleteAfter)
+
+	if user.Email != "" {
+		err = a.mailer.Send(Message{
+			To:      user.Email,
+			Subject: "Your csupgrade account will be deleted",
+			Body: fmt.Sprintf("Hi %s,\n\nYour account will be deleted on %s. Log in and cancel the deletion from your settings before then if you change your mind.\n",
+				user.Username, deleteAfter.Format("January 2, 2006")),
+		})
+		if err != nil {
+			a.logger.Error("couldn't send deletion notice", "user", userID, "error", err)
+		}
+	}
+
+	return deleteAfter, nil
+}
+
+func (a *accountService) CancelDeletion(userID string) error {
+	err := a.storage.CancelDeletion(userID)
+	if err != nil {
+		return err
+	}
+
+	a.logger.Info("cancelled account deletion", "user", userID)
+	return nil
+}
+
+// Anonymizes accounts whose grace period has run out
+func (a *accountService) PurgeDeletedAccounts() {
+	ticker := time.NewTicker(purgeInterval)
+	for range ticker.C {
+		userIDs, err := a.storage.GetDueDeletions()
+		if err != nil {
+			a.logger.Error("couldn't get due deletions", "error", err)
+			continue
+		}
+
+		for _, userID := range userIDs {
+			a.purge(userID)
+		}
+	}
+}
+
+func (a *accountService) purge(userID string) {
+	avatarKey, err := a.storage.PurgeUser(userID)
+	if err != nil {
+		a.logger.Error("couldn't delete account", "user", userID, "error", err)
+		return
+	}
+
+	deleteAvatar(a.blobs, avatarKey, a.logger)
+	a.logger.Info("deleted account", "user", userID)
+}
@@ -0,0 +1,474 @@
+package api_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/erobx/csupgrade-go-api/pkg/mailer"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type storedToken struct {
+	token     api.UserToken
+	purpose   string
+	expiresAt time.Time
+	used      bool
+}
+
+type fakeAccountRepo struct {
+	user       api.User
+	hash       string
+	tokens     map[string]*storedToken
+	passwords  []string
+	sessions   []api.Session
+	takenEmail string
+}
+
+func (f *fakeAccountRepo) GetUserByID(userID string) (api.User, error) {
+	return f.user, nil
+}
+
+func (f *fakeAccountRepo) GetUserAndHashByEmail(email string) (api.User, string, error) {
+	if email == f.takenEmail {
+		return api.User{ID: "user-2", Email: email}, "hash", nil
+	}
+	if email != f.user.Email {
+		return api.User{}, "", errors.New("no rows")
+	}
+	return f.user, "hash", nil
+}
+
+func (f *fakeAccountRepo) GetUserAndHashByID(userID string) (api.User, string, error) {
+	return f.user, f.hash, nil
+}
+
+func (f *fakeAccountRepo) CreateUserToken(userID, tokenHash, purpose, email string, expiresAt time.Time) error {
+	f.tokens[tokenHash] = &storedToken{
+		token:     api.UserToken{UserID: userID, Email: email},
+		purpose:   purpose,
+		expiresAt: expiresAt,
+	}
+	return nil
+}
+
+func (f *fakeAccountRepo) GetUserToken(tokenHash, purpose string) (api.UserToken, error) {
+	t, ok := f.tokens[tokenHash]
+	if !ok || t.used || t.purpose != purpose || time.Now().After(t.expiresAt) {
+		return api.UserToken{}, errors.New("no rows")
+	}
+	return t.token, nil
+}
+
+func (f *fakeAccountRepo) UseUserToken(tokenHash, purpose string) (api.UserToken, error) {
+	token, err := f.GetUserToken(tokenHash, purpose)
+	if err != nil {
+		return token, err
+	}
+	f.tokens[tokenHash].used = true
+	return token, nil
+}
+
+// Leaves the token unused when its email is no longer the user's
+func (f *fakeAccountRepo) UseVerifyEmailToken(tokenHash string) (api.UserToken, error) {
+	token, err := f.GetUserToken(tokenHash, api.TokenVerifyEmail)
+	if err != nil {
+		return token, err
+	}
+	if token.Email != f.user.Email {
+		return token, api.ErrInvalidToken
+	}
+	f.tokens[tokenHash].used = true
+	f.user.EmailVerified = true
+	return token, nil
+}
+
+func (f *fakeAccountRepo) UpdatePassword(userID, password string) error {
+	f.passwords = append(f.passwords, password)
+	return nil
+}
+
+func (f *fakeAccountRepo) UpdateUsername(userID, username string, recase bool) error {
+	f.user.Username = username
+	if !recase {
+		now := time.Now()
+		f.user.UsernameChangedAt = &now
+	}
+	return nil
+}
+
+func (f *fakeAccountRepo) UseEmailChangeToken(tokenHash string) (api.UserToken, error) {
+	token, err := f.UseUserToken(tokenHash, api.TokenChangeEmail)
+	if err != nil {
+		return token, err
+	}
+	f.user.Email = token.Email
+	f.user.EmailVerified = true
+	return token, nil
+}
+
+func (f *fakeAccountRepo) GetInventory(userID string) (api.Inventory, error) {
+	return api.Inventory{UserID: userID}, nil
+}
+
+func (f *fakeAccountRepo) GetActiveSessions(userID string) ([]api.Session, error) {
+	return f.sessions, nil
+}
+
+func (f *fakeAccountRepo) GetTradeupEntries(userID string) ([]api.TradeupEntry, error) {
+	return nil, nil
+}
+
+func (f *fakeAccountRepo) GetCratePurchases(userID string) ([]api.CratePurchase, error) {
+	return nil, nil
+}
+
+func (f *fakeAccountRepo) GetBalanceHistory(userID string) ([]api.BalanceChange, error) {
+	return nil, nil
+}
+
+func (f *fakeAccountRepo) ScheduleDeletion(userID string, at time.Time) error {
+	f.user.DeleteAfter = &at
+	return nil
+}
+
+func (f *fakeAccountRepo) CancelDeletion(userID string) error {
+	f.user.DeleteAfter = nil
+	return nil
+}
+
+func (f *fakeAccountRepo) GetDueDeletions() ([]string, error) {
+	return nil, nil
+}
+
+func (f *fakeAccountRepo) PurgeUser(userID string) (string, error) {
+	return f.user.AvatarSrc, nil
+}
+
+var tokenPattern = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+func mailedToken(t *testing.T, m *mailer.MemoryMailer) string {
+	t.Helper()
+
+	messages := m.Messages()
+	if len(messages) == 0 {
+		t.Fatal("expected an email")
+	}
+
+	match := tokenPattern.FindStringSubmatch(messages[len(messages)-1].Body)
+	if match == nil {
+		t.Fatal("expected a token link in the email")
+	}
+	return match[1]
+}
+
+func newAccountService() (api.AccountService, *fakeAccountRepo, *mailer.MemoryMailer) {
+	repo := &fakeAccountRepo{
+		user:   api.User{ID: "user-1", Username: "testing", Email: "test@test.com"},
+		tokens: make(map[string]*storedToken),
+	}
+	m := mailer.NewMemoryMailer()
+	return api.NewAccountService(repo, m, nil, nil, "https://csupgrade.test/", api.NewLogger()), repo, m
+}
+
+func TestVerifyEmail(t *testing.T) {
+	accounts, repo, m := newAccountService()
+
+	if err := accounts.SendVerification("user-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	token := mailedToken(t, m)
+	if err := accounts.VerifyEmail(token); err != nil {
+		t.Fatal(err)
+	}
+
+	if !repo.user.EmailVerified {
+		t.Error("expected email to be verified")
+	}
+
+	if err := accounts.VerifyEmail(token); err != api.ErrInvalidToken {
+		t.Errorf("expected token to be single-use, got %v", err)
+	}
+
+	// a link for an email the user has moved away from
+	repo.user.EmailVerified = false
+	if err := accounts.SendVerification("user-1"); err != nil {
+		t.Fatal(err)
+	}
+	token = mailedToken(t, m)
+	repo.user.Email = "new@test.com"
+
+	if err := accounts.VerifyEmail(token); err != api.ErrInvalidToken || repo.user.EmailVerified {
+		t.Errorf("expected a link for the old email to be invalid, got %v", err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	t.Run("resets with mailed token once", func(t *testing.T) {
+		accounts, repo, m := newAccountService()
+
+		if err := accounts.ForgotPassword(" test@test.com "); err != nil {
+			t.Fatal(err)
+		}
+
+		request := &api.ResetPasswordRequest{Token: mailedToken(t, m), Password: "new-password"}
+		if err := accounts.ResetPassword(request); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(repo.passwords) != 1 || repo.passwords[0] != "new-password" {
+			t.Errorf("expected password to be updated, got %v", repo.passwords)
+		}
+
+		if err := accounts.ResetPassword(request); err != api.ErrInvalidToken {
+			t.Errorf("expected token to be single-use, got %v", err)
+		}
+	})
+
+	t.Run("does not reveal unknown emails", func(t *testing.T) {
+		accounts, _, m := newAccountService()
+
+		if err := accounts.ForgotPassword("nobody@test.com"); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(m.Messages()) != 0 {
+			t.Error("expected no email for unknown address")
+		}
+	})
+
+	t.Run("rejects expired tokens", func(t *testing.T) {
+		accounts, repo, m := newAccountService()
+
+		accounts.ForgotPassword("test@test.com")
+		token := mailedToken(t, m)
+		repo.tokens[api.HashToken(token)].expiresAt = time.Now().Add(-time.Minute)
+
+		err := accounts.ResetPassword(&api.ResetPasswordRequest{Token: token, Password: "new-password"})
+		if err != api.ErrInvalidToken {
+			t.Errorf("expected expired token to be rejected, got %v", err)
+		}
+	})
+}
+
+func TestChangeUsername(t *testing.T) {
+	t.Run("applies cooldown between changes", func(t *testing.T) {
+		accounts, repo, _ := newAccountService()
+
+		user, err := accounts.ChangeUsername("user-1", " first_name ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Username != "first_name" {
+			t.Errorf("expected trimmed username, got %q", user.Username)
+		}
+
+		_, err = accounts.ChangeUsername("user-1", "second_name")
+		var cooldown *api.CooldownError
+		if !errors.As(err, &cooldown) {
+			t.Fatalf("expected cooldown, got %v", err)
+		}
+		if cooldown.RetryAfter <= api.UsernameCooldown-time.Minute {
+			t.Errorf("expected about %s left, got %s", api.UsernameCooldown, cooldown.RetryAfter)
+		}
+
+		changedAt := repo.user.UsernameChangedAt
+		if _, err := accounts.ChangeUsername("user-1", "First_Name"); err != nil {
+			t.Errorf("expected case change to skip cooldown, got %v", err)
+		}
+		if repo.user.UsernameChangedAt != changedAt {
+			t.Error("expected case change to keep the cooldown running")
+		}
+
+		past := time.Now().Add(-api.UsernameCooldown)
+		repo.user.UsernameChangedAt = &past
+		if _, err := accounts.ChangeUsername("user-1", "second_name"); err != nil {
+			t.Errorf("expected change after cooldown, got %v", err)
+		}
+	})
+
+	t.Run("rejects reserved names", func(t *testing.T) {
+		accounts, repo, _ := newAccountService()
+
+		if _, err := accounts.ChangeUsername("user-1", "Admin"); err != api.ErrUsernameNotAllowed {
+			t.Errorf("expected reserved name to be rejected, got %v", err)
+		}
+		if repo.user.Username != "testing" {
+			t.Errorf("expected username to be unchanged, got %q", repo.user.Username)
+		}
+	})
+}
+
+func TestChangeEmail(t *testing.T) {
+	t.Run("changes once the new address is confirmed", func(t *testing.T) {
+		accounts, repo, m := newAccountService()
+		repo.hash = hashPassword(t, "password")
+
+		request := &api.ChangeEmailRequest{Email: "New@Test.com", Password: "wrong"}
+		if err := accounts.ChangeEmail("user-1", "session-1", request); err != api.ErrInvalidPassword {
+			t.Fatalf("expected wrong password to be rejected, got %v", err)
+		}
+
+		request.Password = "password"
+		if err := accounts.ChangeEmail("user-1", "session-1", request); err != nil {
+			t.Fatal(err)
+		}
+
+		if repo.user.Email != "test@test.com" {
+			t.Fatalf("expected email to wait for confirmation, got %q", repo.user.Email)
+		}
+
+		messages := m.Messages()
+		if len(messages) != 2 || messages[0].To != "new@test.com" || messages[1].To != "test@test.com" {
+			t.Fatalf("expected a link to the new email and a notice to the old one, got %v", messages)
+		}
+
+		token := tokenPattern.FindStringSubmatch(messages[0].Body)[1]
+		if err := accounts.ConfirmEmailChange(token); err != nil {
+			t.Fatal(err)
+		}
+		if repo.user.Email != "new@test.com" || !repo.user.EmailVerified {
+			t.Errorf("expected verified normalized email, got %q verified=%v", repo.user.Email, repo.user.EmailVerified)
+		}
+
+		if err := accounts.ConfirmEmailChange(token); err != api.ErrInvalidToken {
+			t.Errorf("expected token to be single-use, got %v", err)
+		}
+	})
+
+	t.Run("does not reveal taken emails", func(t *testing.T) {
+		accounts, repo, m := newAccountService()
+		repo.hash = hashPassword(t, "password")
+		repo.takenEmail = "taken@test.com"
+
+		request := &api.ChangeEmailRequest{Email: "taken@test.com", Password: "password"}
+		if err := accounts.ChangeEmail("user-1", "session-1", request); err != nil {
+			t.Fatalf("expected taken email to look accepted, got %v", err)
+		}
+
+		messages := m.Messages()
+		if len(messages) != 1 || messages[0].To != "taken@test.com" || tokenPattern.MatchString(messages[0].Body) {
+			t.Errorf("expected only a notice without a link, got %v", messages)
+		}
+	})
+
+	t.Run("steam-only accounts must have logged in recently", func(t *testing.T) {
+		accounts, repo, _ := newAccountService()
+		repo.sessions = []api.Session{
+			{ID: "old", CreatedAt: time.Now().Add(-api.ReauthWindow - time.Minute)},
+			{ID: "new", CreatedAt: time.Now()},
+		}
+
+		request := &api.ChangeEmailRequest{Email: "new@test.com"}
+		if err := accounts.ChangeEmail("user-1", "old", request); err != api.ErrReauthRequired {
+			t.Fatalf("expected reauth to be required, got %v", err)
+		}
+
+		if err := accounts.ChangeEmail("user-1", "new", request); err != nil {
+			t.Errorf("expected fresh session to be enough, got %v", err)
+		}
+	})
+}
+
+func TestChangePassword(t *testing.T) {
+	accounts, repo, _ := newAccountService()
+	repo.hash = hashPassword(t, "password")
+
+	short := &api.ChangePasswordRequest{CurrentPassword: "password", NewPassword: "short"}
+	err := accounts.ChangePassword("user-1", "session-1", short)
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != api.CodeTooShort || apiErr.Field != "newPassword" {
+		t.Fatalf("expected a too short newPassword, got %v", err)
+	}
+
+	request := &api.ChangePasswordRequest{CurrentPassword: "wrong", NewPassword: "new-password"}
+	if err := accounts.ChangePassword("user-1", "session-1", request); err != api.ErrInvalidPassword {
+		t.Fatalf("expected wrong password to be rejected, got %v", err)
+	}
+	if len(repo.passwords) != 0 {
+		t.Fatal("expected password to be unchanged")
+	}
+
+	request.CurrentPassword = "password"
+	if err := accounts.ChangePassword("user-1", "session-1", request); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.passwords) != 1 || repo.passwords[0] != "new-password" {
+		t.Errorf("expected password to be updated, got %v", repo.passwords)
+	}
+}
+
+func TestRequestDeletion(t *testing.T) {
+	accounts, repo, m := newAccountService()
+	repo.hash = hashPassword(t, "password")
+
+	_, err := accounts.RequestDeletion("user-1", "session-1", &api.DeleteAccountRequest{Password: "wrong"})
+	if err != api.ErrInvalidPassword {
+		t.Fatalf("expected wrong password to be rejected, got %v", err)
+	}
+	if repo.user.DeleteAfter != nil {
+		t.Fatal("expected no deletion to be scheduled")
+	}
+
+	deleteAfter, err := accounts.RequestDeletion("user-1", "session-1", &api.DeleteAccountRequest{Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if until := time.Until(deleteAfter); until < api.AccountDeletionGrace-time.Minute {
+		t.Errorf("expected deletion after the grace period, got %s", until)
+	}
+	if len(m.Messages()) != 1 {
+		t.Errorf("expected a deletion notice, got %d emails", len(m.Messages()))
+	}
+
+	again, err := accounts.RequestDeletion("user-1", "session-1", &api.DeleteAccountRequest{Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Equal(deleteAfter) {
+		t.Errorf("expected repeat request to keep the original date, got %s", again)
+	}
+
+	if err := accounts.CancelDeletion("user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if repo.user.DeleteAfter != nil {
+		t.Error("expected deletion to be cancelled")
+	}
+}
+
+func TestRequestDeletionSteamOnly(t *testing.T) {
+	accounts, repo, _ := newAccountService()
+	repo.sessions = []api.Session{
+		{ID: "old", CreatedAt: time.Now().Add(-api.ReauthWindow - time.Minute)},
+		{ID: "new", CreatedAt: time.Now()},
+	}
+
+	if _, err := accounts.RequestDeletion("user-1", "old", &api.DeleteAccountRequest{}); err != api.ErrReauthRequired {
+		t.Fatalf("expected reauth to be required, got %v", err)
+	}
+	if repo.user.DeleteAfter != nil {
+		t.Fatal("expected no deletion to be scheduled")
+	}
+
+	if _, err := accounts.RequestDeletion("user-1", "new", &api.DeleteAccountRequest{}); err != nil {
+		t.Fatalf("expected fresh session to be enough, got %v", err)
+	}
+	if repo.user.DeleteAfter == nil {
+		t.Error("expected deletion to be scheduled")
+	}
+}
+
+func hashPassword(t *testing.T, password string) string {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
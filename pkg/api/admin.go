@@ -0,0 +1,272 @@
+package api
+
+import "io"
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// Whether role grants at least the powers of required. Unknown roles grant
+// nothing.
+func HasRole(role, required string) bool {
+	rank, ok := roleRanks[role]
+	if !ok {
+		return false
+	}
+	return rank >= roleRanks[required]
+}
+
+// Responsible for moderation and back office actions. Every change is written
+// to the audit log along with the acting user.
+type AdminService interface {
+	SearchUsers(query string, limit, offset int) ([]User, error)
+	GetUser(userID string) (User, error)
+	SetRole(actorID, userID, role string) error
+	AdjustBalance(actorID, userID string, request *AdjustBalanceRequest) (Money, error)
+	BanUser(actorID, userID, reason string) error
+	UnbanUser(actorID, userID string) error
+	CompleteTradeup(actorID, tradeupID string) error
+	CancelTradeup(actorID, tradeupID string) error
+	UpdateCrate(actorID, crateID string, update *CrateUpdate) error
+	SetCrateSkins(actorID, crateID string, request *CrateSkinsRequest) error
+	ImportPrices(actorID string, r io.Reader, format string) (int, error)
+	ClearImportedPrices(actorID string) error
+	GetAuditLog(limit, offset int) ([]AuditEntry, error)
+}
+
+type AdminRepository interface {
+	SearchUsers(query string, limit, offset int) ([]User, error)
+	GetUserByID(userID string) (User, error)
+	// Changes write their audit entry in the same transaction
+	SetRole(userID, role string, audit AuditEntry) error
+	AdjustBalance(userID string, delta Money, audit AuditEntry) (Money, error)
+	BanUser(userID, reason string, audit AuditEntry) error
+	UnbanUser(userID string, audit AuditEntry) error
+	UpdateCrate(crateID string, update *CrateUpdate, audit AuditEntry) error
+	SetCrateSkins(crateID string, drops DropTable, audit AuditEntry) error
+	RecordAudit(entry AuditEntry) error
+	GetAuditLog(limit, offset int) ([]AuditEntry, error)
+}
+
+type adminService struct {
+	storage  AdminRepository
+	tradeups TradeupService
+	pricing  PricingService
+	logger   LogService
+}
+
+func NewAdminService(adminRepo AdminRepository, tradeups TradeupService, pricing PricingService,
+	logger LogService) AdminService {
+	return &adminService{storage: adminRepo, tradeups: tradeups, pricing: pricing, logger: logger}
+}
+
+func (a *adminService) SearchUsers(query string, limit, offset int) ([]User, error) {
+	return a.storage.SearchUsers(query, limit, offset)
+}
+
+func (a *adminService) GetUser(userID string) (User, error) {
+	return a.storage.GetUserByID(userID)
+}
+
+// Changing a role logs the user out so their tokens pick up the new role
+func (a *adminService) SetRole(actorID, userID, role string) error {
+	if _, ok := roleRanks[role]; !ok {
+		return ErrInvalidRole
+	}
+
+	if actorID == userID {
+		return ErrForbidden
+	}
+
+	user, err := a.storage.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	entry := a.auditEntry(actorID, "user.set_role", "user", userID, map[string]any{
+		"from": user.Role,
+		"to":   role,
+	})
+	return a.logged(entry, a.storage.SetRole(userID, role, entry))
+}
+
+// The audit entry gets the balance the adjustment left
+func (a *adminService) AdjustBalance(actorID, userID string, request *AdjustBalanceRequest) (Money, error) {
+	if request.Delta.IsZero() || request.Reason == "" {
+		return Money{}, ErrInvalidBalanceAdjustment
+	}
+	if !request.Delta.IsSupported() {
+		return Money{}, UnsupportedCurrency("delta")
+	}
+
+	if actorID == userID {
+		return Money{}, ErrForbidden
+	}
+
+	entry := a.auditEntry(actorID, "user.adjust_balance", "user", userID, map[string]any{
+		"delta":  request.Delta,
+		"reason": request.Reason,
+	})
+	balance, err := a.storage.AdjustBalance(userID, request.Delta, entry)
+	return balance, a.logged(entry, err)
+}
+
+// Staff can only ban users below their own role
+func (a *adminService) BanUser(actorID, userID, reason string) error {
+	if err := a.checkOutranks(actorID, userID); err != nil {
+		return err
+	}
+
+	entry := a.auditEntry(actorID, "user.ban", "user", userID, map[string]any{"reason": reason})
+	return a.logged(entry, a.storage.BanUser(userID, reason, entry))
+}
+
+// Same as banning, so a moderator can't lift a ban on their peers or admins
+func (a *adminService) UnbanUser(actorID, userID string) error {
+	if err := a.checkOutranks(actorID, userID); err != nil {
+		return err
+	}
+
+	entry := a.auditEntry(actorID, "user.unban", "user", userID, nil)
+	return a.logged(entry, a.storage.UnbanUser(userID, entry))
+}
+
+// ErrForbidden unless the actor's role is above the user's
+func (a *adminService) checkOutranks(actorID, userID string) error {
+	if actorID == userID {
+		return ErrForbidden
+	}
+
+	actor, err := a.storage.GetUserByID(actorID)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.storage.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if roleRanks[user.Role] >= roleRanks[actor.Role] {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+func (a *adminService) CompleteTradeup(actorID, tradeupID string) error {
+	entry := a.auditEntry(actorID, "tradeup.complete", "tradeup", tradeupID, nil)
+	return a.logged(entry, a.tradeups.ForceComplete(tradeupID, entry))
+}
+
+func (a *adminService) CancelTradeup(actorID, tradeupID string) error {
+	entry := a.auditEntry(actorID, "tradeup.cancel", "tradeup", tradeupID, nil)
+	return a.logged(entry, a.tradeups.Cancel(tradeupID, entry))
+}
+
+func (a *adminService) UpdateCrate(actorID, crateID string, update *CrateUpdate) error {
+	if update.Cost != nil && !update.Cost.IsSupported() {
+		return UnsupportedCurrency("cost")
+	}
+
+	details := make(map[string]any)
+	if update.Name != nil {
+		details["name"] = *update.Name
+	}
+	if update.Cost != nil {
+		details["cost"] = *update.Cost
+	}
+
+	entry := a.auditEntry(actorID, "crate.update", "crate", crateID, details)
+	return a.logged(entry, a.storage.UpdateCrate(crateID, update, entry))
+}
+
+// Replaces the crate's drop table. Skins without a weight get a weight of 1.
+func (a *adminService) SetCrateSkins(actorID, crateID string, request *CrateSkinsRequest) error {
+	if len(request.Weights) != 0 && len(request.Weights) != len(request.SkinIDs) {
+		return ErrInvalidDropWeights
+	}
+
+	drops := make(DropTable, len(request.SkinIDs))
+	for i, skinID := range request.SkinIDs {
+		drops[i] = Drop{SkinID: skinID, Weight: 1}
+		if len(request.Weights) != 0 {
+			drops[i].Weight = request.Weights[i]
+		}
+
+		if drops[i].Weight <= 0 {
+			return ErrInvalidDropWeights
+		}
+	}
+
+	entry := a.auditEntry(actorID, "crate.set_skins", "crate", crateID, map[string]any{
+		"skinIds": request.SkinIDs,
+		"weights": request.Weights,
+	})
+	return a.logged(entry, a.storage.SetCrateSkins(crateID, drops, entry))
+}
+
+// Replaces every skin price with an uploaded price list. It stays in use,
+// instead of the source's prices, until it's cleared.
+func (a *adminService) ImportPrices(actorID string, r io.Reader, format string) (int, error) {
+	imported, err := a.pricing.Import(r, format)
+	if err != nil {
+		return 0, err
+	}
+
+	// the import is saved by the pricing service, so there's no transaction to
+	// share
+	entry := a.auditEntry(actorID, "prices.import", "prices", "", map[string]any{
+		"format":  format,
+		"entries": imported,
+	})
+	return imported, a.logged(entry, a.storage.RecordAudit(entry))
+}
+
+// Goes back to the price source's prices
+func (a *adminService) ClearImportedPrices(actorID string) error {
+	if err := a.pricing.ClearImport(); err != nil {
+		return err
+	}
+
+	entry := a.auditEntry(actorID, "prices.clear_import", "prices", "", nil)
+	return a.logged(entry, a.storage.RecordAudit(entry))
+}
+
+func (a *adminService) GetAuditLog(limit, offset int) ([]AuditEntry, error) {
+	return a.storage.GetAuditLog(limit, offset)
+}
+
+// Actions are written to the audit log in the same transaction as the change
+// they record, so neither happens without the other
+func (a *adminService) auditEntry(actorID, action, targetType, targetID string,
+	details map[string]any) AuditEntry {
+	if details == nil {
+		details = make(map[string]any)
+	}
+
+	return AuditEntry{
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    details,
+	}
+}
+
+// Passes err through, logging the action if it went ahead
+func (a *adminService) logged(entry AuditEntry, err error) error {
+	if err == nil {
+		a.logger.Info("admin action", "actor", entry.ActorID, "action", entry.Action,
+			"target", entry.TargetType+":"+entry.TargetID)
+	}
+	return err
+}
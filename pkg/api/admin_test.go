@@ -0,0 +1,129 @@
+package api_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+)
+
+// Keeps users, balances and the audit log in memory
+type fakeAdminRepo struct {
+	api.AdminRepository
+	users    map[string]api.User
+	balances map[string]api.Money
+	audit    []api.AuditEntry
+}
+
+func (f *fakeAdminRepo) GetUserByID(userID string) (api.User, error) {
+	user, ok := f.users[userID]
+	if !ok {
+		return user, api.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (f *fakeAdminRepo) AdjustBalance(userID string, delta api.Money, audit api.AuditEntry) (api.Money, error) {
+	f.balances[userID] = f.balances[userID].Add(delta)
+	f.audit = append(f.audit, audit)
+	return f.balances[userID], nil
+}
+
+func (f *fakeAdminRepo) BanUser(userID, reason string, audit api.AuditEntry) error {
+	user := f.users[userID]
+	user.BanReason = reason
+	f.users[userID] = user
+	f.audit = append(f.audit, audit)
+	return nil
+}
+
+func (f *fakeAdminRepo) UnbanUser(userID string, audit api.AuditEntry) error {
+	user := f.users[userID]
+	user.BanReason = ""
+	f.users[userID] = user
+	f.audit = append(f.audit, audit)
+	return nil
+}
+
+func (f *fakeAdminRepo) RecordAudit(entry api.AuditEntry) error {
+	f.audit = append(f.audit, entry)
+	return nil
+}
+
+func newAdminService(repo api.AdminRepository) api.AdminService {
+	return api.NewAdminService(repo, nil, nil, api.NewLogger())
+}
+
+func TestAdjustBalance(t *testing.T) {
+	repo := &fakeAdminRepo{balances: map[string]api.Money{"user-1": api.Cents(1000)}}
+	admin := newAdminService(repo)
+
+	balance, err := admin.AdjustBalance("admin-1", "user-1",
+		&api.AdjustBalanceRequest{Delta: api.Cents(-250), Reason: "chargeback"})
+	if err != nil || balance != api.Cents(750) {
+		t.Fatalf("expected 7.50, got %v %v", balance, err)
+	}
+	if len(repo.audit) != 1 {
+		t.Errorf("expected the adjustment audited, got %+v", repo.audit)
+	}
+
+	// stored as cents, 5 euros would have become $5
+	_, err = admin.AdjustBalance("admin-1", "user-1",
+		&api.AdjustBalanceRequest{Delta: api.Money{Cents: 500, Currency: "EUR"}, Reason: "refund"})
+	if !errors.Is(err, api.UnsupportedCurrency("delta")) {
+		t.Errorf("expected unsupported currency, got %v", err)
+	}
+	if repo.balances["user-1"] != api.Cents(750) {
+		t.Errorf("expected the balance untouched, got %v", repo.balances["user-1"])
+	}
+
+	_, err = admin.AdjustBalance("admin-1", "admin-1",
+		&api.AdjustBalanceRequest{Delta: api.Cents(100000), Reason: "bonus"})
+	if !errors.Is(err, api.ErrForbidden) {
+		t.Errorf("expected adjusting your own balance to be forbidden, got %v", err)
+	}
+}
+
+// Staff can't ban or unban their peers or anyone above them
+func TestBanUser(t *testing.T) {
+	repo := &fakeAdminRepo{users: map[string]api.User{
+		"user-1":  {ID: "user-1", Role: api.RoleUser},
+		"mod-1":   {ID: "mod-1", Role: api.RoleModerator},
+		"mod-2":   {ID: "mod-2", Role: api.RoleModerator},
+		"admin-1": {ID: "admin-1", Role: api.RoleAdmin},
+	}}
+	admin := newAdminService(repo)
+
+	tests := []struct {
+		actor, target string
+		want          error
+	}{
+		{"mod-1", "user-1", nil},
+		{"admin-1", "mod-1", nil},
+		{"mod-1", "admin-1", api.ErrForbidden},
+		{"mod-1", "mod-2", api.ErrForbidden},
+		{"mod-1", "mod-1", api.ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		err := admin.BanUser(tt.actor, tt.target, "spam")
+		if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s banning %s: expected %v, got %v", tt.actor, tt.target, tt.want, err)
+		}
+	}
+
+	if len(repo.audit) != 2 {
+		t.Errorf("expected the two bans audited, got %+v", repo.audit)
+	}
+
+	for _, tt := range tests {
+		err := admin.UnbanUser(tt.actor, tt.target)
+		if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s unbanning %s: expected %v, got %v", tt.actor, tt.target, tt.want, err)
+		}
+	}
+
+	if len(repo.audit) != 4 || repo.users["mod-1"].BanReason != "" {
+		t.Errorf("expected the two unbans audited, got %+v", repo.audit)
+	}
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+)
+
+const (
+	MaxAvatarBytes = 2 << 20
+	// Larger images are rejected before decoding so a small file can't
+	// expand into a huge bitmap
+	maxAvatarDimension = 4096
+)
+
+// Every avatar is stored at these sizes. The first is what avatar_key points
+// to, smaller ones get a -<size> suffix.
+var AvatarSizes = []int{256, 64}
+
+var avatarTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// Stores uploaded files
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Validates an uploaded image and re-encodes it as square PNGs at each of
+// AvatarSizes. Re-encoding drops anything in the upload that isn't pixels.
+func ProcessAvatar(data []byte) (map[int][]byte, error) {
+	if len(data) > MaxAvatarBytes {
+		return nil, ErrImageTooLarge
+	}
+
+	// sniff the bytes, the client's content type can't be trusted
+	if !avatarTypes[http.DetectContentType(data)] {
+		return nil, ErrInvalidImage
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	if config.Width > maxAvatarDimension || config.Height > maxAvatarDimension {
+		return nil, ErrImageTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	square := cropSquare(src)
+	encoded := make(map[int][]byte, len(AvatarSizes))
+	for _, size := range AvatarSizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, resize(square, size)); err != nil {
+			return nil, err
+		}
+		encoded[size] = buf.Bytes()
+	}
+
+	return encoded, nil
+}
+
+// Blob keys for an avatar, keyed by size. Avatars live under avatars/ on the
+// CDN, the same place createAvatarSrc points.
+func avatarBlobKeys(avatarKey string) map[int]string {
+	keys := make(map[int]string, len(AvatarSizes))
+	base := strings.TrimSuffix(avatarKey, ".png")
+	for i, size := range AvatarSizes {
+		if i == 0 {
+			keys[size] = "avatars/" + avatarKey
+			continue
+		}
+		keys[size] = fmt.Sprintf("avatars/%s-%d.png", base, size)
+	}
+	return keys
+}
+
+// Each upload gets a new key so CDN caches never serve the old image
+func newAvatarKey(userID string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return userID + "/" + hex.EncodeToString(b) + ".png", nil
+}
+
+// Keys that point at uploaded avatars, as opposed to the default or a Steam
+// avatar url
+func isUploadedAvatar(avatarKey string) bool {
+	return avatarKey != "" && avatarKey != "none" && !strings.Contains(avatarKey, "://")
+}
+
+// Removes every size of an uploaded avatar. Failures are only logged, the
+// avatar is no longer referenced by the time this runs.
+func deleteAvatar(blobs BlobStore, avatarKey string, logger LogService) {
+	if !isUploadedAvatar(avatarKey) {
+		return
+	}
+
+	for _, key := range avatarBlobKeys(avatarKey) {
+		if err := blobs.Delete(context.Background(), key); err != nil {
+			logger.Error("couldn't delete avatar", "key", key, "error", err)
+		}
+	}
+}
+
+// Largest centered square
+func cropSquare(src image.Image) image.Image {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	return subImage(src, image.Rect(x, y, x+side, y+side))
+}
+
+func subImage(src image.Image, r image.Rectangle) image.Image {
+	if s, ok := src.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	return src
+}
+
+// Scales a square image to size x size by averaging the source pixels each
+// destination pixel covers. Good enough for downscaling avatars without
+// pulling in an imaging library.
+func resize(src image.Image, size int) *image.NRGBA {
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	scale := float64(b.Dx()) / float64(size)
+
+	for dy := range size {
+		y0 := b.Min.Y + int(float64(dy)*scale)
+		y1 := max(b.Min.Y+int(float64(dy+1)*scale), y0+1)
+
+		for dx := range size {
+			x0 := b.Min.X + int(float64(dx)*scale)
+			x1 := max(b.Min.X+int(float64(dx+1)*scale), x0+1)
+
+			var r, g, bl, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					cr, cg, cb, ca := src.At(x, y).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			i := dst.PixOffset(dx, dy)
+			if a == 0 {
+				continue
+			}
+			// RGBA() is premultiplied, NRGBA isn't
+			dst.Pix[i+0] = uint8(r * 0xff / a)
+			dst.Pix[i+1] = uint8(g * 0xff / a)
+			dst.Pix[i+2] = uint8(bl * 0xff / a)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,134 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+)
+
+func encodePNG(t *testing.T, w, h int, c color.Color) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessAvatar(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	images, err := api.ProcessAvatar(encodePNG(t, 300, 200, red))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range api.AvatarSizes {
+		img, err := png.Decode(bytes.NewReader(images[size]))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+
+		if b := img.Bounds(); b.Dx() != size || b.Dy() != size {
+			t.Errorf("expected %dx%d, got %v", size, size, b)
+		}
+
+		if got := color.NRGBAModel.Convert(img.At(size/2, size/2)); got != red {
+			t.Errorf("size %d: expected colour preserved, got %v", size, got)
+		}
+	}
+
+	t.Run("rejects non images", func(t *testing.T) {
+		if _, err := api.ProcessAvatar([]byte("<svg></svg>")); err != api.ErrInvalidImage {
+			t.Fatalf("expected ErrInvalidImage, got %v", err)
+		}
+	})
+
+	t.Run("rejects huge dimensions", func(t *testing.T) {
+		if _, err := api.ProcessAvatar(encodePNG(t, 5000, 1, red)); err != api.ErrImageTooLarge {
+			t.Fatalf("expected ErrImageTooLarge, got %v", err)
+		}
+	})
+
+	t.Run("rejects large files", func(t *testing.T) {
+		data := append(encodePNG(t, 1, 1, red), make([]byte, api.MaxAvatarBytes)...)
+		if _, err := api.ProcessAvatar(data); err != api.ErrImageTooLarge {
+			t.Fatalf("expected ErrImageTooLarge, got %v", err)
+		}
+	})
+}
+
+type fakeBlobStore struct {
+	blobs map[string][]byte
+}
+
+func (f *fakeBlobStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	f.blobs[key] = data
+	return nil
+}
+
+func (f *fakeBlobStore) Delete(ctx context.Context, key string) error {
+	delete(f.blobs, key)
+	return nil
+}
+
+type fakeProfileRepo struct {
+	api.ProfileRepository
+	avatarKey string
+}
+
+func (f *fakeProfileRepo) SetAvatarKey(userID, avatarKey string) (string, error) {
+	old := f.avatarKey
+	f.avatarKey = avatarKey
+	return old, nil
+}
+
+func (f *fakeProfileRepo) GetUserByID(userID string) (api.User, error) {
+	return api.User{ID: userID, AvatarSrc: "https://cdn.test/avatars/" + f.avatarKey}, nil
+}
+
+func TestSetAvatar(t *testing.T) {
+	blobs := &fakeBlobStore{blobs: make(map[string][]byte)}
+	repo := &fakeProfileRepo{avatarKey: "none"}
+	profiles := api.NewProfileService(repo, nil, fakePricer{}, blobs, api.NewLogger())
+
+	data := encodePNG(t, 64, 64, color.NRGBA{B: 255, A: 255})
+	if _, err := profiles.SetAvatar("user-1", data); err != nil {
+		t.Fatal(err)
+	}
+
+	first := repo.avatarKey
+	if !strings.HasPrefix(first, "user-1/") || len(blobs.blobs) != len(api.AvatarSizes) {
+		t.Fatalf("expected avatar stored under user key, got %q %d blobs", first, len(blobs.blobs))
+	}
+
+	user, err := profiles.SetAvatar("user-1", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if repo.avatarKey == first || len(blobs.blobs) != len(api.AvatarSizes) {
+		t.Fatalf("expected old avatar replaced, got %d blobs", len(blobs.blobs))
+	}
+
+	if _, ok := blobs.blobs["avatars/"+repo.avatarKey]; !ok {
+		t.Errorf("expected main size at avatars/%s", repo.avatarKey)
+	}
+
+	if user.AvatarSrc != "https://cdn.test/avatars/"+repo.avatarKey {
+		t.Errorf("unexpected avatar src %s", user.AvatarSrc)
+	}
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Most crates opened in one purchase
+	MaxCrateAmount = 10
+
+	ReelLength = 50
+	// Far enough in that the reel spins for a while, with a few slots after
+	// the winner so it doesn't stop on the edge
+	ReelStop = 44
+)
+
+// Knives and gloves. They sit above Covert in cases but never come out of
+// tradeups.
+const RarityRareSpecial = "Rare Special"
+
+// Relative chance of each rarity tier when a crate is opened. Rarities without
+// a weight never drop.
+type RarityWeights map[string]int
+
+// CS2 case odds, in hundredths of a percent. Consumer and Industrial skins
+// only come from collections, never cases, so they're off until a weight is
+// set for them.
+func DefaultRarityWeights() RarityWeights {
+	return RarityWeights{
+		"Consumer":        0,
+		"Industrial":      0,
+		"Mil-Spec":        7992,
+		"Restricted":      1598,
+		"Classified":      320,
+		"Covert":          64,
+		RarityRareSpecial: 26,
+	}
+}
+
+// Overrides weights from a spec like "Covert=64,Rare Special=26". A weight of
+// 0 turns the tier off.
+func (w RarityWeights) Apply(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		rarity, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid rarity weight %q", entry)
+		}
+
+		weight, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || weight < 0 {
+			return fmt.Errorf("invalid rarity weight %q", entry)
+		}
+
+		w[strings.TrimSpace(rarity)] = weight
+	}
+
+	return nil
+}
+
+// One entry in a crate's drop table. Weight is relative to the other skins of
+// the same rarity.
+type Drop struct {
+	SkinID        int    `json:"skinId"`
+	Rarity        string `json:"rarity"`
+	Weight        int    `json:"weight"`
+	CanBeStatTrak bool   `json:"canBeStatTrak"`
+}
+
+// Every skin a crate can drop
+type DropTable []Drop
+
+// Builds the table the crate's contents describe
+func NewDropTable(contents []CrateDrop) DropTable {
+	table := make(DropTable, len(contents))
+	for i, drop := range contents {
+		table[i] = Drop{SkinID: drop.SkinID, Rarity: drop.Rarity, Weight: drop.Weight,
+			CanBeStatTrak: drop.CanBeStatTrak}
+	}
+	return table
+}
+
+func (d DropTable) TotalWeight() int {
+	total := 0
+	for _, drop := range d {
+		total += drop.Weight
+	}
+	return total
+}
+
+// Chance of the drop at i
+func (d DropTable) Probability(i int) float64 {
+	total := d.TotalWeight()
+	if total == 0 {
+		return 0
+	}
+	return float64(d[i].Weight) / float64(total)
+}
+
+// Picks the drop that roll lands on. Rolls are in [0, TotalWeight) and each
+// drop owns a run of them as long as its weight, in table order.
+func (d DropTable) Pick(roll int) Drop {
+	for _, drop := range d {
+		if roll < drop.Weight {
+			return drop
+		}
+		roll -= drop.Weight
+	}
+	return d[len(d)-1]
+}
+
+// Picks a drop using intN, which returns a uniform int in [0, n). The table
+// must not be empty.
+func (d DropTable) Roll(intN func(n int) int) Drop {
+	return d.Pick(intN(d.TotalWeight()))
+}
+
+// The skins of one rarity in a crate
+type Tier struct {
+	Rarity string    `json:"rarity"`
+	Weight int       `json:"weight"`
+	Drops  DropTable `json:"drops"`
+}
+
+// How a crate rolls. A tier is picked by its rarity's weight, then a skin in
+// the tier by its own weight. The published odds and the rolls in BuyCrate
+// both come from here, so they can't drift apart.
+type DropOdds []Tier
+
+// Groups the table into tiers, lowest rarity first. Rarities the crate has no
+// skins for don't take part, so the remaining tiers share their odds.
+func NewDropOdds(table DropTable, weights RarityWeights) DropOdds {
+	odds := make(DropOdds, 0)
+	for _, drop := range table {
+		i := slices.IndexFunc(odds, func(t Tier) bool { return t.Rarity == drop.Rarity })
+		if i == -1 {
+			odds = append(odds, Tier{Rarity: drop.Rarity, Weight: weights[drop.Rarity]})
+			i = len(odds) - 1
+		}
+		odds[i].Drops = append(odds[i].Drops, drop)
+	}
+
+	slices.SortStableFunc(odds, func(a, b Tier) int {
+		return RarityRank(a.Rarity) - RarityRank(b.Rarity)
+	})
+
+	return odds
+}
+
+// Sum of the weights of every tier that can drop
+func (o DropOdds) TotalWeight() int {
+	total := 0
+	for _, tier := range o {
+		if tier.Drops.TotalWeight() > 0 {
+			total += tier.Weight
+		}
+	}
+	return total
+}
+
+// Chance of rolling the rarity
+func (o DropOdds) TierProbability(rarity string) float64 {
+	total := o.TotalWeight()
+	for _, tier := range o {
+		if tier.Rarity == rarity && total > 0 && tier.Drops.TotalWeight() > 0 {
+			return float64(tier.Weight) / float64(total)
+		}
+	}
+	return 0
+}
+
+// Chance of rolling the skin, the odds of its tier times its odds within it
+func (o DropOdds) Probability(skinID int) float64 {
+	for _, tier := range o {
+		for i, drop := range tier.Drops {
+			if drop.SkinID == skinID {
+				return o.TierProbability(tier.Rarity) * tier.Drops.Probability(i)
+			}
+		}
+	}
+	return 0
+}
+
+// Rolls a tier then a skin in it, using intN for both. Nothing can drop when
+// TotalWeight is 0.
+func (o DropOdds) Roll(intN func(n int) int) Drop {
+	roll := intN(o.TotalWeight())
+	for _, tier := range o {
+		if tier.Weight == 0 || tier.Drops.TotalWeight() == 0 {
+			continue
+		}
+
+		if roll < tier.Weight {
+			return tier.Drops.Roll(intN)
+		}
+		roll -= tier.Weight
+	}
+
+	// unreachable for rolls in range
+	return Drop{}
+}
+
+// Fills in each skin's probability and the odds of each rarity, lowest rarity
+// first
+func WithOdds(crate Crate, weights RarityWeights) Crate {
+	odds := NewDropOdds(NewDropTable(crate.Contents), weights)
+	crate.TotalWeight = odds.TotalWeight()
+
+	for i := range crate.Contents {
+		crate.Contents[i].Probability = odds.Probability(crate.Contents[i].SkinID)
+	}
+
+	crate.Rarities = make([]RarityOdds, 0, len(odds))
+	for _, tier := range odds {
+		crate.Rarities = append(crate.Rarities, RarityOdds{
+			Rarity:      tier.Rarity,
+			Skins:       len(tier.Drops),
+			Weight:      tier.Weight,
+			Probability: odds.TierProbability(tier.Rarity),
+		})
+	}
+
+	return crate
+}
+
+// Lays out the reel for an opening. Filler is rolled from the crate's odds so
+// the reel looks like the crate, it plays no part in what was won.
+func NewReel(contents []CrateDrop, odds DropOdds, skinID int, intN func(n int) int) []ReelSlot {
+	bySkin := make(map[int]CrateDrop, len(contents))
+	for _, drop := range contents {
+		bySkin[drop.SkinID] = drop
+	}
+
+	reel := make([]ReelSlot, ReelLength)
+	for i := range reel {
+		id := skinID
+		if i != ReelStop {
+			id = odds.Roll(intN).SkinID
+		}
+
+		drop := bySkin[id]
+		reel[i] = ReelSlot{SkinID: id, Name: drop.Name, Rarity: drop.Rarity, ImgSrc: drop.ImgSrc}
+	}
+
+	return reel
+}
@@ -0,0 +1,246 @@
+package api_test
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+)
+
+func TestDropTablePick(t *testing.T) {
+	table := api.DropTable{
+		{SkinID: 1, Weight: 3},
+		{SkinID: 2, Weight: 1},
+		{SkinID: 3, Weight: 6},
+	}
+
+	if table.TotalWeight() != 10 {
+		t.Fatalf("expected total weight 10, got %d", table.TotalWeight())
+	}
+
+	// each skin owns a run of rolls as long as its weight
+	expected := []int{1, 1, 1, 2, 3, 3, 3, 3, 3, 3}
+	for roll, skinID := range expected {
+		if got := table.Pick(roll).SkinID; got != skinID {
+			t.Errorf("roll %d: expected skin %d, got %d", roll, skinID, got)
+		}
+	}
+
+	if p := table.Probability(2); p != 0.6 {
+		t.Errorf("expected probability 0.6, got %v", p)
+	}
+}
+
+// A case laid out like the CS2 ones, with a knife
+func testCrate() api.Crate {
+	return api.Crate{
+		ID: 1,
+		Contents: []api.CrateDrop{
+			{SkinID: 1, Rarity: "Mil-Spec", Weight: 1},
+			{SkinID: 2, Rarity: "Mil-Spec", Weight: 1},
+			{SkinID: 3, Rarity: "Mil-Spec", Weight: 2},
+			{SkinID: 4, Rarity: "Restricted", Weight: 1},
+			{SkinID: 5, Rarity: "Restricted", Weight: 1},
+			{SkinID: 6, Rarity: "Classified", Weight: 1},
+			{SkinID: 7, Rarity: "Covert", Weight: 1},
+			{SkinID: 8, Rarity: api.RarityRareSpecial, Weight: 1},
+		},
+	}
+}
+
+func TestWithOdds(t *testing.T) {
+	crate := api.WithOdds(testCrate(), api.DefaultRarityWeights())
+
+	if crate.TotalWeight != 10000 {
+		t.Fatalf("expected total weight 10000, got %d", crate.TotalWeight)
+	}
+
+	sum := 0.0
+	for _, drop := range crate.Contents {
+		sum += drop.Probability
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Errorf("expected probabilities to sum to 1, got %v", sum)
+	}
+
+	// half the Mil-Spec weight of 79.92%
+	if p := crate.Contents[2].Probability; math.Abs(p-0.3996) > 1e-9 {
+		t.Errorf("expected 0.3996 for the heavier Mil-Spec, got %v", p)
+	}
+
+	order := []string{"Mil-Spec", "Restricted", "Classified", "Covert", api.RarityRareSpecial}
+	if len(crate.Rarities) != len(order) {
+		t.Fatalf("expected %d rarities, got %+v", len(order), crate.Rarities)
+	}
+
+	for i, rarity := range order {
+		if crate.Rarities[i].Rarity != rarity {
+			t.Errorf("expected %s at %d, got %s", rarity, i, crate.Rarities[i].Rarity)
+		}
+	}
+
+	if special := crate.Rarities[4]; special.Probability != 0.0026 {
+		t.Errorf("expected rare special odds of 0.0026, got %v", special.Probability)
+	}
+
+	t.Run("missing tiers share their odds", func(t *testing.T) {
+		crate := api.WithOdds(api.Crate{Contents: []api.CrateDrop{
+			{SkinID: 1, Rarity: "Classified", Weight: 1},
+			{SkinID: 2, Rarity: "Covert", Weight: 1},
+		}}, api.DefaultRarityWeights())
+
+		if p := crate.Contents[0].Probability; math.Abs(p-320.0/384) > 1e-9 {
+			t.Errorf("expected %v, got %v", 320.0/384, p)
+		}
+	})
+
+	t.Run("rarities without a weight never drop", func(t *testing.T) {
+		crate := api.WithOdds(api.Crate{Contents: []api.CrateDrop{
+			{SkinID: 1, Rarity: "Consumer", Weight: 1},
+			{SkinID: 2, Rarity: "Covert", Weight: 1},
+		}}, api.DefaultRarityWeights())
+
+		if crate.Contents[0].Probability != 0 || crate.Contents[1].Probability != 1 {
+			t.Errorf("unexpected odds %+v", crate.Contents)
+		}
+	})
+}
+
+func TestRarityWeightsApply(t *testing.T) {
+	weights := api.DefaultRarityWeights()
+	if err := weights.Apply("Covert=100, Rare Special=0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if weights["Covert"] != 100 || weights[api.RarityRareSpecial] != 0 || weights["Mil-Spec"] != 7992 {
+		t.Errorf("unexpected weights %v", weights)
+	}
+
+	for _, spec := range []string{"Covert", "Covert=-1", "Covert=many"} {
+		if err := weights.Apply(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+// Enough rolls that every tier, including the 0.26% one, is seen thousands
+// of times
+const rolls = 1_000_000
+
+// Upper critical value of the chi-square distribution at p = 0.001, using
+// the Wilson-Hilferty approximation
+func chiSquareCritical(df int) float64 {
+	const z = 3.0902
+	k := float64(df)
+	return k * math.Pow(1-2/(9*k)+z*math.Sqrt(2/(9*k)), 3)
+}
+
+// Pearson's chi-square statistic of the observed counts against the
+// expected probabilities
+func chiSquare(observed map[int]int, expected map[int]float64, n int) float64 {
+	stat := 0.0
+	for key, p := range expected {
+		e := p * float64(n)
+		d := float64(observed[key]) - e
+		stat += d * d / e
+	}
+	return stat
+}
+
+func TestDropOddsDistribution(t *testing.T) {
+	crate := testCrate()
+	weights := api.DefaultRarityWeights()
+	odds := api.NewDropOdds(api.NewDropTable(crate.Contents), weights)
+
+	// seeded so the suite is deterministic, the checks don't depend on the seed
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	skins := make(map[int]int)
+	tiers := make(map[string]int)
+	for range rolls {
+		drop := odds.Roll(rng.IntN)
+		skins[drop.SkinID]++
+		tiers[drop.Rarity]++
+	}
+
+	t.Run("rarities", func(t *testing.T) {
+		observed := make(map[int]int)
+		expected := make(map[int]float64)
+		for i, tier := range odds {
+			observed[i] = tiers[tier.Rarity]
+			expected[i] = float64(weights[tier.Rarity]) / 10000
+		}
+
+		stat := chiSquare(observed, expected, rolls)
+		if critical := chiSquareCritical(len(expected) - 1); stat > critical {
+			t.Errorf("rarity distribution is off, chi-square %.2f > %.2f: %v", stat, critical, tiers)
+		}
+	})
+
+	t.Run("skins", func(t *testing.T) {
+		expected := make(map[int]float64)
+		for _, drop := range crate.Contents {
+			expected[drop.SkinID] = odds.Probability(drop.SkinID)
+		}
+
+		stat := chiSquare(skins, expected, rolls)
+		if critical := chiSquareCritical(len(expected) - 1); stat > critical {
+			t.Errorf("skin distribution is off, chi-square %.2f > %.2f: %v", stat, critical, skins)
+		}
+	})
+
+	t.Run("detects a skewed table", func(t *testing.T) {
+		// the same rolls judged against uniform skin odds must fail, or the
+		// test above proves nothing
+		uniform := make(map[int]float64)
+		for _, drop := range crate.Contents {
+			uniform[drop.SkinID] = 1 / float64(len(crate.Contents))
+		}
+
+		if stat := chiSquare(skins, uniform, rolls); stat <= chiSquareCritical(len(uniform)-1) {
+			t.Errorf("expected uniform odds to be rejected, chi-square %.2f", stat)
+		}
+	})
+}
+
+// Rolls are independent, so a crate with one skin always gives that skin and
+// a bulk purchase can repeat skins
+func TestDropOddsWithReplacement(t *testing.T) {
+	odds := api.NewDropOdds(api.DropTable{{SkinID: 9, Rarity: "Covert", Weight: 1}},
+		api.DefaultRarityWeights())
+
+	rng := rand.New(rand.NewPCG(3, 4))
+	for range 100 {
+		if drop := odds.Roll(rng.IntN); drop.SkinID != 9 {
+			t.Fatalf("expected skin 9, got %d", drop.SkinID)
+		}
+	}
+}
+
+// Walks every tier roll once with a scripted source and checks each tier
+// owns exactly its weight in rolls
+func TestDropOddsTierRollsExact(t *testing.T) {
+	crate := testCrate()
+	weights := api.DefaultRarityWeights()
+	odds := api.NewDropOdds(api.NewDropTable(crate.Contents), weights)
+
+	counts := make(map[string]int)
+	for roll := range odds.TotalWeight() {
+		first := true
+		intN := func(n int) int {
+			if first {
+				first = false
+				return roll
+			}
+			return 0
+		}
+		counts[odds.Roll(intN).Rarity]++
+	}
+
+	for rarity, weight := range weights {
+		if counts[rarity] != weight {
+			t.Errorf("%s: expected %d rolls, got %d", rarity, weight, counts[rarity])
+		}
+	}
+}
@@ -1,7 +1,235 @@
 package api
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// What went wrong, in terms the HTTP layer can map to a status. Services pick
+// a kind, handlers never have to know about individual errors.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindValidation
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindInsufficientFunds
+	KindTradeupFull
+	KindTradeupLocked
+	KindRateLimited
+	// Well formed but can't be acted on, like an amount in another currency
+	KindUnprocessable
+)
+
+// Stable codes clients key their messages on. Changing one is a breaking
+// change for the frontend.
+const (
+	CodeInternal         = "internal_error"
+	CodeValidationFailed = "validation_failed"
+	CodeMalformedBody    = "malformed_body"
+	CodeRateLimited      = "rate_limited"
+
+	// Field level codes, shared between fields
+	CodeRequired          = "required"
+	CodeInvalidEmail      = "invalid_email"
+	CodeTooShort          = "too_short"
+	CodeTooLong           = "too_long"
+	CodeInvalidCharacters = "invalid_characters"
+	CodeNotAllowed        = "not_allowed"
+	CodeWeakPassword      = "weak_password"
+	CodeNotANumber        = "not_a_number"
+	CodeMustBePositive    = "must_be_positive"
+	CodeTaken             = "taken"
+	CodeTooLarge          = "too_large"
+)
+
+// An entry in the error catalog. Field is set when the error is about a
+// single request field. Err is the underlying cause, if any.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Field   string
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// A wrapped copy is still the same catalog error
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code && t.Field == e.Field
+}
+
+func newError(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func newFieldError(kind Kind, field, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Field: field, Message: message}
+}
+
+func errRequired(field string) *Error {
+	return newFieldError(KindValidation, field, CodeRequired, field+" cannot be empty")
+}
+
+// ErrUnsupportedCurrency on the field that carried the amount
+func UnsupportedCurrency(field string) *Error {
+	return newFieldError(ErrUnsupportedCurrency.Kind, field, ErrUnsupportedCurrency.Code,
+		ErrUnsupportedCurrency.Message)
+}
+
+// A catalog error reported on another field, for requests that name the
+// value differently. Other errors are returned as they are.
+func onField(err error, field string) error {
+	var catalogErr *Error
+	if !errors.As(err, &catalogErr) {
+		return err
+	}
+
+	moved := *catalogErr
+	moved.Field = field
+	return &moved
+}
+
+// Attaches a cause to a catalog error, keeping its kind and code
+func Wrap(catalogErr *Error, err error) error {
+	wrapped := *catalogErr
+	wrapped.Err = err
+	return &wrapped
+}
+
+// Errors outside the catalog are internal
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindInternal
+}
 
 var (
-	ErrMaxContribution = fmt.Errorf("reached max contribution to tradeup")
+	ErrNotFound        = newError(KindNotFound, "not_found", "not found")
+	ErrUserNotFound    = newError(KindNotFound, "user_not_found", "user not found")
+	ErrTradeupNotFound = newError(KindNotFound, "tradeup_not_found", "tradeup not found")
+	ErrCrateNotFound   = newError(KindNotFound, "crate_not_found", "crate not found")
+	ErrSessionNotFound = newError(KindNotFound, "session_not_found", "session not found")
+	ErrRollNotFound    = newError(KindNotFound, "roll_not_found", "roll not found")
+	ErrSkinNotFound    = newError(KindNotFound, "skin_not_found", "skin not found")
+
+	ErrInvalidCredentials  = newError(KindUnauthorized, "invalid_credentials", "invalid email or password")
+	ErrInvalidRefreshToken = newError(KindUnauthorized, "invalid_refresh_token", "invalid or expired refresh token")
+	ErrInvalidPassword     = newFieldError(KindUnauthorized, "password", "invalid_password", "current password is incorrect")
+	ErrInvalidToken        = newError(KindValidation, "invalid_token", "invalid or expired token")
+
+	ErrTwoFactorRequired    = newError(KindUnauthorized, "two_factor_required", "two factor code required")
+	ErrInvalidTwoFactorCode = newError(KindUnauthorized, "invalid_two_factor_code", "invalid two factor code")
+	ErrChallengeExhausted   = newError(KindUnauthorized, "challenge_exhausted", "too many codes tried, log in again")
+	ErrReauthRequired       = newError(KindUnauthorized, "reauth_required", "log in again to make this change")
+	ErrTwoFactorEnabled     = newError(KindConflict, "two_factor_enabled", "two factor already enabled")
+	ErrTwoFactorNotEnabled  = newError(KindConflict, "two_factor_not_enabled", "two factor not enabled")
+
+	ErrForbidden     = newError(KindForbidden, "forbidden", "action not allowed")
+	ErrAccountBanned = newError(KindForbidden, "account_banned", "account is banned")
+	ErrItemNotOwned  = newError(KindForbidden, "item_not_owned", "user does not own requested item")
+
+	ErrSteamAlreadyLinked = newError(KindConflict, "steam_already_linked", "steam account is linked to another user")
+	ErrSteamNotLinked     = newError(KindConflict, "steam_not_linked", "no steam account linked")
+	ErrNoPassword         = newError(KindConflict, "no_password", "account has no password set")
+	ErrTradeupClosed      = newError(KindConflict, "tradeup_closed", "tradeup is already completed or cancelled")
+	ErrTradeupEmpty       = newError(KindConflict, "tradeup_empty", "tradeup has no items")
+	ErrCrateEmpty         = newError(KindConflict, "crate_empty", "crate has no skins")
+	ErrItemUnavailable    = newError(KindConflict, "item_unavailable", "item is in a tradeup, used or sold")
+	ErrItemUnpriced       = newError(KindConflict, "item_unpriced", "item has no current price")
+	ErrNothingToSell      = newError(KindConflict, "nothing_to_sell", "no items matched")
+	ErrSeedNotRevealed    = newError(KindConflict, "seed_not_revealed", "rotate the seed to verify rolls made with it")
+	ErrSeedChanged        = newError(KindConflict, "seed_changed", "the seed changed while rolling, try again")
+	ErrUsernameTaken      = newFieldError(KindConflict, "username", CodeTaken, "username is taken")
+	ErrEmailTaken         = newFieldError(KindConflict, "email", CodeTaken, "email already used")
+
+	ErrInsufficientFunds = newError(KindInsufficientFunds, "insufficient_funds", "insufficient funds")
+
+	ErrUnsupportedCurrency = newError(KindUnprocessable, "unsupported_currency", "only "+CurrencyUSD+" amounts are supported")
+
+	ErrTradeupFull     = newError(KindTradeupFull, "tradeup_full", "tradeup is full")
+	ErrMaxContribution = newError(KindTradeupFull, "max_contribution", "reached max contribution to tradeup")
+
+	ErrTradeupLocked = newError(KindTradeupLocked, "tradeup_locked", "tradeup is no longer accepting changes")
+
+	ErrAccountLocked  = newError(KindRateLimited, "account_locked", "account locked")
+	ErrChangeCooldown = newError(KindRateLimited, "change_cooldown", "changed too recently")
+
+	ErrInvalidRole              = newError(KindValidation, "invalid_role", "invalid role")
+	ErrInvalidBalanceAdjustment = newError(KindValidation, "invalid_balance_adjustment", "balance adjustments need a non-zero delta and a reason")
+	ErrShowcaseFull             = newError(KindValidation, "showcase_full", "too many showcase items")
+	ErrInvalidDropWeights       = newError(KindValidation, "invalid_drop_weights", "drop weights must be positive and match the skins")
+	ErrInvalidCrateAmount       = newFieldError(KindValidation, "amount", "invalid_amount", fmt.Sprintf("between 1 and %d crates can be opened at once", MaxCrateAmount))
+	ErrInvalidClientSeed        = newFieldError(KindValidation, "clientSeed", "invalid_client_seed", "client seeds are 1-64 printable characters")
+	ErrInvalidImage             = newFieldError(KindValidation, "avatar", "invalid_image", "image must be a png, jpeg or gif")
+	ErrImageTooLarge            = newFieldError(KindValidation, "avatar", "image_too_large", "image is too large")
+	ErrInvalidSellRequest       = newError(KindValidation, "invalid_sell_request", fmt.Sprintf("sell up to %d invIds, everything below a price or everything of a rarity", MaxSellAmount))
+	ErrInvalidPriceRange        = newFieldError(KindValidation, "range", "invalid_range", "range must be 7d, 30d, 90d, 1y or all")
+	ErrInvalidPriceList         = newError(KindValidation, "invalid_price_list", "price list must be CSV or JSON with a positive price in cents for each skin and wear")
+
+	ErrInvalidUsername    = newFieldError(KindValidation, "username", CodeInvalidCharacters, "usernames are 3-20 letters, numbers, dots, dashes or underscores")
+	ErrUsernameNotAllowed = newFieldError(KindValidation, "username", CodeNotAllowed, "username is not allowed")
+	ErrInvalidEmail       = newFieldError(KindValidation, "email", CodeInvalidEmail, "invalid email address")
+	ErrPasswordTooShort   = newFieldError(KindValidation, "password", CodeTooShort, fmt.Sprintf("password must be at least %d characters", MinPasswordLength))
+	ErrPasswordTooLong    = newFieldError(KindValidation, "password", CodeTooLong, fmt.Sprintf("password must be at most %d characters", MaxPasswordLength))
+	ErrWeakPassword       = newFieldError(KindValidation, "password", CodeWeakPassword, "password needs at least two of lower case, upper case, digits and symbols")
 )
+
+// How long the caller should wait before retrying, for rate limited errors
+func RetryAfter(err error) (time.Duration, bool) {
+	var lockout *LockoutError
+	if errors.As(err, &lockout) {
+		return lockout.RetryAfter, true
+	}
+
+	var cooldown *CooldownError
+	if errors.As(err, &cooldown) {
+		return cooldown.RetryAfter, true
+	}
+
+	return 0, false
+}
+
+// Returned by Login while an account is locked out after too many failed
+// attempts
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("account locked, try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+func (e *LockoutError) Unwrap() error {
+	return ErrAccountLocked
+}
+
+// Returned when a change was made too recently to be made again
+type CooldownError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CooldownError) Error() string {
+	return fmt.Sprintf("changed too recently, try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+func (e *CooldownError) Unwrap() error {
+	return ErrChangeCooldown
+}
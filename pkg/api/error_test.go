@@ -0,0 +1,54 @@
+package api_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+)
+
+func TestWrap(t *testing.T) {
+	cause := errors.New("no rows in result set")
+	err := api.Wrap(api.ErrUserNotFound, cause)
+
+	if !errors.Is(err, api.ErrUserNotFound) {
+		t.Error("expected wrapped error to match its catalog entry")
+	}
+
+	if !errors.Is(err, cause) {
+		t.Error("expected wrapped error to keep its cause")
+	}
+
+	if errors.Is(err, api.ErrTradeupNotFound) {
+		t.Error("expected wrapped error not to match other entries of the same kind")
+	}
+
+	if api.KindOf(err) != api.KindNotFound {
+		t.Errorf("expected not found kind, got %v", api.KindOf(err))
+	}
+
+	if api.ErrUserNotFound.Err != nil {
+		t.Error("expected Wrap to leave the catalog entry untouched")
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	cases := map[error]api.Kind{
+		errors.New("boom"):                         api.KindInternal,
+		api.ErrInsufficientFunds:                   api.KindInsufficientFunds,
+		&api.CooldownError{RetryAfter: time.Hour}:  api.KindRateLimited,
+		&api.LockoutError{RetryAfter: time.Minute}: api.KindRateLimited,
+	}
+
+	for err, expected := range cases {
+		if kind := api.KindOf(err); kind != expected {
+			t.Errorf("%v: expected kind %v, got %v", err, expected, kind)
+		}
+	}
+
+	wait, ok := api.RetryAfter(&api.CooldownError{RetryAfter: time.Hour})
+	if !ok || wait != time.Hour {
+		t.Errorf("expected an hour to wait, got %v", wait)
+	}
+}
@@ -0,0 +1,371 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/erobx/csupgrade-go-api/pkg/fairness"
+)
+
+const (
+	GameCrate   = "crate"
+	GameTradeup = "tradeup"
+
+	maxClientSeedLength = 64
+)
+
+// Source of every random decision in a game. fairness.Roller in production,
+// anything scripted in tests.
+type Roller interface {
+	Float64() float64
+	IntN(n int) int
+}
+
+// What a crate purchase was rolled from
+type CrateRollInputs struct {
+	CrateID int      `json:"crateId"`
+	Amount  int      `json:"amount"`
+	Odds    DropOdds `json:"odds"`
+}
+
+// What came out of one crate
+type CrateRoll struct {
+	SkinID     int     `json:"skinId"`
+	Float      float64 `json:"float"`
+	IsStatTrak bool    `json:"isStatTrak"`
+	// What the skin is worth when it's added, not part of the roll
+	Price Money `json:"-"`
+}
+
+// Four rolls per crate, in order: rarity tier, skin, float and StatTrak. The
+// StatTrak roll is taken even for skins that can't be StatTrak so every crate
+// uses the same number of rolls.
+func RollCrates(odds DropOdds, amount int, roller Roller) []CrateRoll {
+	rolls := make([]CrateRoll, amount)
+	for i := range rolls {
+		drop := odds.Roll(roller.IntN)
+		float := roller.Float64()
+		statTrak := roller.Float64()
+
+		rolls[i] = CrateRoll{
+			SkinID:     drop.SkinID,
+			Float:      float,
+			IsStatTrak: drop.CanBeStatTrak && IsStatTrak(statTrak),
+		}
+	}
+	return rolls
+}
+
+// One ticket per item put into a tradeup, so more items mean better odds
+type TradeupTicket struct {
+	InvID  int    `json:"invId"`
+	UserID string `json:"userId,omitempty"`
+}
+
+// What a tradeup was rolled from. Prizes are the skins of the next rarity,
+// fixed when the tradeup's seed was committed.
+type TradeupRollInputs struct {
+	TradeupID int             `json:"tradeupId"`
+	Tickets   []TradeupTicket `json:"tickets"`
+	Prizes    DropTable       `json:"prizes"`
+}
+
+type TradeupOutcome struct {
+	Winner     string `json:"winner,omitempty"`
+	SkinID     int    `json:"skinId"`
+	IsStatTrak bool   `json:"isStatTrak"`
+}
+
+// Three rolls, in order: winning ticket, prize and StatTrak
+func RollTradeup(inputs TradeupRollInputs, roller Roller) TradeupOutcome {
+	ticket := inputs.Tickets[roller.IntN(len(inputs.Tickets))]
+	prize := inputs.Prizes.Roll(roller.IntN)
+	statTrak := roller.Float64()
+
+	return TradeupOutcome{
+		Winner:     ticket.UserID,
+		SkinID:     prize.SkinID,
+		IsStatTrak: prize.CanBeStatTrak && IsStatTrak(statTrak),
+	}
+}
+
+// A tradeup's client seed is its items in ticket order. Players can't steer
+// it because the server seed is committed before the first item goes in.
+func TradeupClientSeed(tickets []TradeupTicket) string {
+	ids := make([]string, len(tickets))
+	for i, ticket := range tickets {
+		ids[i] = strconv.Itoa(ticket.InvID)
+	}
+	return strings.Join(ids, ",")
+}
+
+// A roll ready to be saved along with whatever it decided
+func NewFairRoll(seed FairSeed, userID, game, reference string, inputs, outcome any) (FairRoll, error) {
+	roll := FairRoll{SeedID: seed.ID, UserID: userID, Game: game, Reference: reference, Nonce: seed.Nonce}
+
+	var err error
+	if roll.Inputs, err = json.Marshal(inputs); err != nil {
+		return roll, err
+	}
+	roll.Outcome, err = json.Marshal(outcome)
+	return roll, err
+}
+
+// Replays a recorded roll. This and pkg/fairness are all it takes to check an
+// outcome independently.
+func RecomputeRoll(game string, inputs json.RawMessage, roller Roller) (json.RawMessage, error) {
+	switch game {
+	case GameCrate:
+		var crate CrateRollInputs
+		if err := json.Unmarshal(inputs, &crate); err != nil {
+			return nil, err
+		}
+		return json.Marshal(RollCrates(crate.Odds, crate.Amount, roller))
+	case GameTradeup:
+		var tradeup TradeupRollInputs
+		if err := json.Unmarshal(inputs, &tradeup); err != nil {
+			return nil, err
+		}
+		return json.Marshal(RollTradeup(tradeup, roller))
+	}
+
+	return nil, fmt.Errorf("unknown game %q", game)
+}
+
+func ValidateClientSeed(clientSeed string) error {
+	if clientSeed == "" || len(clientSeed) > maxClientSeedLength {
+		return ErrInvalidClientSeed
+	}
+
+	for _, r := range clientSeed {
+		if r < ' ' || r > '~' {
+			return ErrInvalidClientSeed
+		}
+	}
+
+	return nil
+}
+
+// Commits to server seeds, hands out nonces and replays past rolls
+type FairnessService interface {
+	GetSeed(userID string) (FairSeed, error)
+	RotateSeed(userID, clientSeed string) (SeedRotation, error)
+	GetRolls(userID string, limit, offset int) ([]FairRoll, error)
+	Verify(userID string, rollID int64) (Verification, error)
+
+	// For services rolling on a user's behalf, from the seed's next nonce.
+	// The nonce is used up when the roll is saved along with what it paid
+	// for, which fails with ErrSeedChanged if another roll got it first.
+	NextRoller(userID string) (FairSeed, Roller, error)
+	// Fixes the tradeup's client seed and reveals its server seed
+	TradeupRoller(tradeupID int, clientSeed string) (FairSeed, Roller, error)
+}
+
+type FairnessRepository interface {
+	GetActiveSeed(userID string) (FairSeed, error)
+	CreateSeed(userID, serverSeed, serverSeedHash, clientSeed string) error
+	RotateSeed(userID, serverSeed, serverSeedHash, clientSeed string) (SeedRotation, error)
+	CreateTradeupSeed(tradeupID int, serverSeed, serverSeedHash string) error
+	RevealTradeupSeed(tradeupID int, clientSeed string) (FairSeed, error)
+	GetRoll(rollID int64) (FairRoll, FairSeed, error)
+	GetRolls(userID string, limit, offset int) ([]FairRoll, error)
+}
+
+type fairnessService struct {
+	storage FairnessRepository
+	logger  LogService
+}
+
+func NewFairnessService(fairRepo FairnessRepository, logger LogService) FairnessService {
+	return &fairnessService{storage: fairRepo, logger: logger}
+}
+
+// The user's current seed pair, made on first use. The server seed stays
+// hidden until it's rotated out.
+func (f *fairnessService) GetSeed(userID string) (FairSeed, error) {
+	seed, err := f.storage.GetActiveSeed(userID)
+	if errors.Is(err, ErrNotFound) {
+		if err := f.createSeed(userID); err != nil {
+			return seed, err
+		}
+		seed, err = f.storage.GetActiveSeed(userID)
+	}
+	if err != nil {
+		return seed, err
+	}
+
+	return hideServerSeed(seed), nil
+}
+
+// Reveals the current server seed and commits to a new one. An empty client
+// seed gets a random one.
+func (f *fairnessService) RotateSeed(userID, clientSeed string) (SeedRotation, error) {
+	var rotation SeedRotation
+
+	if clientSeed == "" {
+		clientSeed = randomClientSeed()
+	} else if err := ValidateClientSeed(clientSeed); err != nil {
+		return rotation, err
+	}
+
+	// a user who never rolled has nothing to reveal yet
+	if _, err := f.GetSeed(userID); err != nil {
+		return rotation, err
+	}
+
+	serverSeed, err := fairness.NewServerSeed()
+	if err != nil {
+		return rotation, err
+	}
+
+	rotation, err = f.storage.RotateSeed(userID, serverSeed, fairness.HashSeed(serverSeed), clientSeed)
+	if err != nil {
+		return rotation, err
+	}
+	rotation.Current = hideServerSeed(rotation.Current)
+
+	f.logger.Info("rotated seed", "user", userID, "revealed", rotation.Revealed.ID)
+	return rotation, nil
+}
+
+func (f *fairnessService) GetRolls(userID string, limit, offset int) ([]FairRoll, error) {
+	return f.storage.GetRolls(userID, limit, offset)
+}
+
+// Recomputes a roll from its seeds. Crate rolls can only be verified by the
+// user who made them, tradeup rolls by anyone, so those leave out who played.
+func (f *fairnessService) Verify(userID string, rollID int64) (Verification, error) {
+	var verification Verification
+
+	roll, seed, err := f.storage.GetRoll(rollID)
+	if err != nil {
+		return verification, err
+	}
+
+	if roll.UserID != "" && roll.UserID != userID {
+		return verification, ErrRollNotFound
+	}
+
+	if seed.RevealedAt == nil {
+		return verification, ErrSeedNotRevealed
+	}
+
+	roller := fairness.NewRoller(seed.ServerSeed, seed.ClientSeed, roll.Nonce)
+	outcome, err := RecomputeRoll(roll.Game, roll.Inputs, roller)
+	if err != nil {
+		return verification, err
+	}
+
+	verification = Verification{
+		Roll:        roll,
+		Seed:        seed,
+		SeedMatches: fairness.CheckSeed(seed.ServerSeed, seed.ServerSeedHash),
+		Rolls:       fairness.Rolls(seed.ServerSeed, seed.ClientSeed, roll.Nonce, roller.Cursor()),
+		Outcome:     outcome,
+		Matches:     sameJSON(outcome, roll.Outcome),
+	}
+	if roll.Game == GameTradeup {
+		hidePlayers(&verification)
+	}
+
+	return verification, nil
+}
+
+// Takes the user IDs out of a tradeup roll. The tickets still show which
+// items went in.
+func hidePlayers(v *Verification) {
+	var inputs TradeupRollInputs
+	if json.Unmarshal(v.Roll.Inputs, &inputs) == nil {
+		for i := range inputs.Tickets {
+			inputs.Tickets[i].UserID = ""
+		}
+		v.Roll.Inputs, _ = json.Marshal(inputs)
+	}
+
+	for _, raw := range []*json.RawMessage{&v.Roll.Outcome, &v.Outcome} {
+		var outcome TradeupOutcome
+		if json.Unmarshal(*raw, &outcome) == nil {
+			outcome.Winner = ""
+			*raw, _ = json.Marshal(outcome)
+		}
+	}
+}
+
+func (f *fairnessService) NextRoller(userID string) (FairSeed, Roller, error) {
+	seed, err := f.storage.GetActiveSeed(userID)
+	if errors.Is(err, ErrNotFound) {
+		if err := f.createSeed(userID); err != nil {
+			return seed, nil, err
+		}
+		seed, err = f.storage.GetActiveSeed(userID)
+	}
+	if err != nil {
+		return seed, nil, err
+	}
+
+	return seed, fairness.NewRoller(seed.ServerSeed, seed.ClientSeed, seed.Nonce), nil
+}
+
+func (f *fairnessService) TradeupRoller(tradeupID int, clientSeed string) (FairSeed, Roller, error) {
+	seed, err := f.storage.RevealTradeupSeed(tradeupID, clientSeed)
+	if errors.Is(err, ErrNotFound) {
+		// tradeups from before seeds were committed get one now, their
+		// rolls can be replayed but weren't committed to in advance
+		f.logger.Info("tradeup has no committed seed", "tradeup", tradeupID)
+		if err := f.createTradeupSeed(tradeupID); err != nil {
+			return seed, nil, err
+		}
+		seed, err = f.storage.RevealTradeupSeed(tradeupID, clientSeed)
+	}
+	if err != nil {
+		return seed, nil, err
+	}
+
+	return seed, fairness.NewRoller(seed.ServerSeed, seed.ClientSeed, seed.Nonce), nil
+}
+
+func (f *fairnessService) createSeed(userID string) error {
+	serverSeed, err := fairness.NewServerSeed()
+	if err != nil {
+		return err
+	}
+
+	return f.storage.CreateSeed(userID, serverSeed, fairness.HashSeed(serverSeed), randomClientSeed())
+}
+
+func (f *fairnessService) createTradeupSeed(tradeupID int) error {
+	serverSeed, err := fairness.NewServerSeed()
+	if err != nil {
+		return err
+	}
+
+	return f.storage.CreateTradeupSeed(tradeupID, serverSeed, fairness.HashSeed(serverSeed))
+}
+
+func hideServerSeed(seed FairSeed) FairSeed {
+	if seed.RevealedAt == nil {
+		seed.ServerSeed = ""
+	}
+	return seed
+}
+
+func randomClientSeed() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// jsonb doesn't keep key order or spacing, so compare values
+func sameJSON(a, b json.RawMessage) bool {
+	var x, y any
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
@@ -0,0 +1,252 @@
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/erobx/csupgrade-go-api/pkg/fairness"
+)
+
+// Keeps seeds and rolls in memory, one active seed per user
+type fakeFairnessRepo struct {
+	api.FairnessRepository
+	seeds []api.FairSeed
+	owner []string
+	rolls []api.FairRoll
+}
+
+func (f *fakeFairnessRepo) active(userID string) int {
+	for i, seed := range f.seeds {
+		if f.owner[i] == userID && seed.RevealedAt == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *fakeFairnessRepo) GetActiveSeed(userID string) (api.FairSeed, error) {
+	if i := f.active(userID); i >= 0 {
+		return f.seeds[i], nil
+	}
+	return api.FairSeed{}, api.ErrNotFound
+}
+
+func (f *fakeFairnessRepo) CreateSeed(userID, serverSeed, hash, clientSeed string) error {
+	if f.active(userID) < 0 {
+		f.seeds = append(f.seeds, api.FairSeed{ID: int64(len(f.seeds) + 1), ServerSeed: serverSeed,
+			ServerSeedHash: hash, ClientSeed: clientSeed})
+		f.owner = append(f.owner, userID)
+	}
+	return nil
+}
+
+func (f *fakeFairnessRepo) RotateSeed(userID, serverSeed, hash, clientSeed string) (api.SeedRotation, error) {
+	var rotation api.SeedRotation
+	i := f.active(userID)
+	if i < 0 {
+		return rotation, api.ErrNotFound
+	}
+
+	now := time.Now()
+	f.seeds[i].RevealedAt = &now
+	rotation.Revealed = f.seeds[i]
+
+	f.CreateSeed(userID, serverSeed, hash, clientSeed)
+	rotation.Current = f.seeds[len(f.seeds)-1]
+	return rotation, nil
+}
+
+// What a purchase does when it saves its roll
+func (f *fakeFairnessRepo) record(roll api.FairRoll) error {
+	if roll.UserID != "" {
+		seed := &f.seeds[roll.SeedID-1]
+		if seed.Nonce != roll.Nonce || seed.RevealedAt != nil {
+			return api.ErrSeedChanged
+		}
+		seed.Nonce++
+	}
+
+	roll.ID = int64(len(f.rolls) + 1)
+	f.rolls = append(f.rolls, roll)
+	return nil
+}
+
+func (f *fakeFairnessRepo) GetRoll(rollID int64) (api.FairRoll, api.FairSeed, error) {
+	if rollID < 1 || int(rollID) > len(f.rolls) {
+		return api.FairRoll{}, api.FairSeed{}, api.ErrRollNotFound
+	}
+	roll := f.rolls[rollID-1]
+	return roll, f.seeds[roll.SeedID-1], nil
+}
+
+func TestVerifyCrateRoll(t *testing.T) {
+	repo := &fakeFairnessRepo{}
+	service := api.NewFairnessService(repo, api.NewLogger())
+
+	committed, err := service.GetSeed("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if committed.ServerSeed != "" {
+		t.Fatal("expected the active server seed to be hidden")
+	}
+
+	// what BuyCrate does for a purchase of three
+	odds := api.NewDropOdds(api.NewDropTable(testCrate().Contents), api.DefaultRarityWeights())
+	seed, roller, err := service.NextRoller("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roll, err := api.NewFairRoll(seed, "u1", api.GameCrate, "1",
+		api.CrateRollInputs{CrateID: 1, Amount: 3, Odds: odds}, api.RollCrates(odds, 3, roller))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.record(roll); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.Verify("u1", 1); !errors.Is(err, api.ErrSeedNotRevealed) {
+		t.Fatalf("expected the seed to still be secret, got %v", err)
+	}
+
+	rotation, err := service.RotateSeed("u1", "my seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotation.Revealed.ServerSeedHash != committed.ServerSeedHash {
+		t.Error("expected the committed seed to be the one revealed")
+	}
+	if rotation.Current.ClientSeed != "my seed" || rotation.Current.ServerSeed != "" {
+		t.Errorf("unexpected new seed %+v", rotation.Current)
+	}
+
+	verification, err := service.Verify("u1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verification.SeedMatches || !verification.Matches {
+		t.Errorf("expected the roll to verify, got %+v", verification)
+	}
+	if len(verification.Rolls) != 12 {
+		t.Errorf("expected four rolls per crate, got %d", len(verification.Rolls))
+	}
+
+	t.Run("someone else's roll", func(t *testing.T) {
+		if _, err := service.Verify("u2", 1); !errors.Is(err, api.ErrRollNotFound) {
+			t.Errorf("expected roll not found, got %v", err)
+		}
+	})
+
+	t.Run("tampered outcome", func(t *testing.T) {
+		repo.rolls[0].Outcome = json.RawMessage(`[]`)
+		verification, err := service.Verify("u1", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if verification.Matches {
+			t.Error("expected a changed outcome not to match")
+		}
+	})
+}
+
+// A nonce is only used up by a roll that's saved, and only once
+func TestNextRollerUsesFreshNonces(t *testing.T) {
+	repo := &fakeFairnessRepo{}
+	service := api.NewFairnessService(repo, api.NewLogger())
+
+	first, _, _ := service.NextRoller("u1")
+	racing, _, _ := service.NextRoller("u1")
+	if first.Nonce != 0 || racing.Nonce != 0 {
+		t.Fatalf("expected nonce 0 until a roll is saved, got %+v and %+v", first, racing)
+	}
+
+	if err := repo.record(api.FairRoll{SeedID: first.ID, UserID: "u1", Nonce: first.Nonce}); err != nil {
+		t.Fatal(err)
+	}
+	err := repo.record(api.FairRoll{SeedID: racing.ID, UserID: "u1", Nonce: racing.Nonce})
+	if !errors.Is(err, api.ErrSeedChanged) {
+		t.Errorf("expected the second roll on nonce 0 to be refused, got %v", err)
+	}
+
+	second, _, _ := service.NextRoller("u1")
+	if second.Nonce != 1 || second.ID != first.ID {
+		t.Errorf("expected nonce 1 on the same seed, got %+v", second)
+	}
+}
+
+func TestRotateSeedRejectsBadClientSeed(t *testing.T) {
+	service := api.NewFairnessService(&fakeFairnessRepo{}, api.NewLogger())
+
+	if _, err := service.RotateSeed("u1", "tab\there"); !errors.Is(err, api.ErrInvalidClientSeed) {
+		t.Errorf("expected invalid client seed, got %v", err)
+	}
+}
+
+func TestRecomputeTradeupRoll(t *testing.T) {
+	inputs := api.TradeupRollInputs{
+		TradeupID: 4,
+		Tickets:   []api.TradeupTicket{{InvID: 10, UserID: "a"}, {InvID: 11, UserID: "b"}},
+		Prizes:    api.DropTable{{SkinID: 1, Weight: 1}, {SkinID: 2, Weight: 1, CanBeStatTrak: true}},
+	}
+	clientSeed := api.TradeupClientSeed(inputs.Tickets)
+	if clientSeed != "10,11" {
+		t.Fatalf("unexpected client seed %q", clientSeed)
+	}
+
+	outcome := api.RollTradeup(inputs, fairness.NewRoller("server", clientSeed, 0))
+	raw, _ := json.Marshal(inputs)
+
+	recomputed, err := api.RecomputeRoll(api.GameTradeup, raw, fairness.NewRoller("server", clientSeed, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := json.Marshal(outcome)
+	if string(recomputed) != string(expected) {
+		t.Errorf("expected %s, got %s", expected, recomputed)
+	}
+}
+
+// Anyone can verify a tradeup, but not find out who was in it
+func TestVerifyTradeupHidesPlayers(t *testing.T) {
+	repo := &fakeFairnessRepo{seeds: []api.FairSeed{{ID: 1}}, owner: []string{""}}
+	service := api.NewFairnessService(repo, api.NewLogger())
+
+	inputs := api.TradeupRollInputs{
+		TradeupID: 4,
+		Tickets:   []api.TradeupTicket{{InvID: 10, UserID: "a"}, {InvID: 11, UserID: "b"}},
+		Prizes:    api.DropTable{{SkinID: 1, Weight: 1}, {SkinID: 2, Weight: 1}},
+	}
+	seed, err := fairness.NewServerSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	repo.seeds[0] = api.FairSeed{ID: 1, ServerSeed: seed, ServerSeedHash: fairness.HashSeed(seed),
+		ClientSeed: api.TradeupClientSeed(inputs.Tickets), RevealedAt: &now}
+
+	outcome := api.RollTradeup(inputs, fairness.NewRoller(seed, repo.seeds[0].ClientSeed, 0))
+	roll, _ := api.NewFairRoll(repo.seeds[0], "", api.GameTradeup, "4", inputs, outcome)
+	repo.record(roll)
+
+	verification, err := service.Verify("someone", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verification.Matches {
+		t.Error("expected the roll to verify")
+	}
+
+	for _, raw := range []json.RawMessage{verification.Roll.Inputs, verification.Roll.Outcome,
+		verification.Outcome} {
+		if s := string(raw); strings.Contains(s, `"a"`) || strings.Contains(s, `"b"`) ||
+			strings.Contains(s, "userId") || strings.Contains(s, "winner") {
+			t.Errorf("expected no players in %s", s)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import "time"
+
+// Why a balance changed
+const (
+	ReasonCratePurchase   = "crate_purchase"
+	ReasonSale            = "sale"
+	ReasonDeposit         = "deposit"
+	ReasonAdminAdjustment = "admin_adjustment"
+	ReasonTradeupPayout   = "tradeup_payout"
+	ReasonOpeningBalance  = "opening_balance"
+)
+
+// System accounts on the other side of user entries
+const (
+	// The site itself. Takes crate money, pays for sales and tradeup payouts.
+	AccountHouse = "house"
+	// Money coming in from outside
+	AccountDeposits = "deposits"
+	// Admin corrections and balances from before the ledger
+	AccountAdjustments = "adjustments"
+)
+
+const reconcileInterval = time.Hour
+
+// The system account that pays or is paid for a reason
+func CounterAccount(reason string) string {
+	switch reason {
+	case ReasonDeposit:
+		return AccountDeposits
+	case ReasonAdminAdjustment, ReasonOpeningBalance:
+		return AccountAdjustments
+	}
+	return AccountHouse
+}
+
+// A balance change for the repository to post along with whatever caused it.
+// Amount is negative for debits.
+type Posting struct {
+	UserID    string
+	Amount    Money
+	Reason    string
+	Reference string
+}
+
+// Reads the ledger. Entries are written by the repositories that move money,
+// inside the same transaction as the change they pay for.
+type LedgerService interface {
+	GetTransactions(userID string, limit, offset int) ([]LedgerEntry, error)
+	FindDrift() ([]BalanceDrift, error)
+	Reconcile()
+}
+
+type LedgerRepository interface {
+	GetLedgerEntries(userID string, limit, offset int) ([]LedgerEntry, error)
+	FindBalanceDrift() ([]BalanceDrift, error)
+}
+
+type ledgerService struct {
+	storage LedgerRepository
+	logger  LogService
+}
+
+func NewLedgerService(ledgerRepo LedgerRepository, logger LogService) LedgerService {
+	return &ledgerService{storage: ledgerRepo, logger: logger}
+}
+
+// The user's side of their ledger, newest first
+func (l *ledgerService) GetTransactions(userID string, limit, offset int) ([]LedgerEntry, error) {
+	return l.storage.GetLedgerEntries(userID, limit, offset)
+}
+
+// Users whose cached balance doesn't match the sum of their entries
+func (l *ledgerService) FindDrift() ([]BalanceDrift, error) {
+	return l.storage.FindBalanceDrift()
+}
+
+// Checks every cached balance against the ledger. Drift means something
+// changed a balance without posting, so each one is flagged rather than fixed.
+func (l *ledgerService) Reconcile() {
+	ticker := time.NewTicker(reconcileInterval)
+	for range ticker.C {
+		drift, err := l.FindDrift()
+		if err != nil {
+			l.logger.Error("couldn't reconcile balances", "error", err)
+			continue
+		}
+
+		for _, d := range drift {
+			l.logger.Error("balance drifted from ledger", "user", d.UserID, "cached", d.Cached,
+				"ledger", d.Ledger)
+		}
+	}
+}
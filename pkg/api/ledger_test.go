@@ -0,0 +1,24 @@
+package api_test
+
+import (
+	"testing"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+)
+
+func TestCounterAccount(t *testing.T) {
+	cases := map[string]string{
+		api.ReasonCratePurchase:   api.AccountHouse,
+		api.ReasonSale:            api.AccountHouse,
+		api.ReasonTradeupPayout:   api.AccountHouse,
+		api.ReasonDeposit:         api.AccountDeposits,
+		api.ReasonAdminAdjustment: api.AccountAdjustments,
+		api.ReasonOpeningBalance:  api.AccountAdjustments,
+	}
+
+	for reason, account := range cases {
+		if got := api.CounterAccount(reason); got != account {
+			t.Errorf("%s: expected %s, got %s", reason, account, got)
+		}
+	}
+}
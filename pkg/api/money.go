@@ -0,0 +1,194 @@
+package api
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Everything is priced in dollars for now. An empty currency means USD so the
+// zero Money is $0.00.
+const CurrencyUSD = "USD"
+
+var ErrInvalidMoney = errors.New("invalid amount of money")
+
+// An amount of money in minor units. Floats drifted after enough purchases,
+// so arithmetic only ever happens on whole cents.
+type Money struct {
+	Cents    int64
+	Currency string
+}
+
+func Cents(cents int64) Money {
+	return Money{Cents: cents, Currency: CurrencyUSD}
+}
+
+// Parses a decimal amount like "12.34" or "-5" exactly, without going through
+// a float. More than two decimal places is an error rather than rounded.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	// anything longer would overflow int64 cents
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" || len(whole) > 16 || len(frac) > 2 {
+		return Money{}, ErrInvalidMoney
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	var cents int64
+	for _, r := range whole + frac {
+		if r < '0' || r > '9' {
+			return Money{}, ErrInvalidMoney
+		}
+		cents = cents*10 + int64(r-'0')
+	}
+
+	if negative {
+		cents = -cents
+	}
+	return Cents(cents), nil
+}
+
+func (m Money) currency() string {
+	if m.Currency == "" {
+		return CurrencyUSD
+	}
+	return m.Currency
+}
+
+// Whether the amount is in a currency the site deals in
+func (m Money) IsSupported() bool {
+	return m.currency() == CurrencyUSD
+}
+
+// Mixing currencies is a programming error, there's no exchange rate to use
+func (m Money) mustMatch(o Money) {
+	if m.currency() != o.currency() {
+		panic(fmt.Sprintf("money: mixing %s and %s", m.currency(), o.currency()))
+	}
+}
+
+func (m Money) Add(o Money) Money {
+	m.mustMatch(o)
+	return Money{Cents: m.Cents + o.Cents, Currency: m.currency()}
+}
+
+func (m Money) Sub(o Money) Money {
+	m.mustMatch(o)
+	return Money{Cents: m.Cents - o.Cents, Currency: m.currency()}
+}
+
+func (m Money) Mul(n int64) Money {
+	return Money{Cents: m.Cents * n, Currency: m.currency()}
+}
+
+func (m Money) Neg() Money {
+	return Money{Cents: -m.Cents, Currency: m.currency()}
+}
+
+func (m Money) IsZero() bool {
+	return m.Cents == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Cents < 0
+}
+
+// -1, 0 or 1 as m is less than, equal to or more than o
+func (m Money) Cmp(o Money) int {
+	m.mustMatch(o)
+	switch {
+	case m.Cents < o.Cents:
+		return -1
+	case m.Cents > o.Cents:
+		return 1
+	}
+	return 0
+}
+
+// Decimal amount without a symbol, e.g. "12.34" or "-0.05"
+func (m Money) String() string {
+	cents := m.Cents
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+type moneyJSON struct {
+	Cents    int64  `json:"cents"`
+	Currency string `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Cents: m.Cents, Currency: m.currency()})
+}
+
+// Accepts {"cents": 1234, "currency": "USD"} as sent, or a plain decimal as a
+// number or string, which is what people type into requests. Any currency but
+// USD is ErrUnsupportedCurrency, nothing downstream can convert it.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	switch data[0] {
+	case '{':
+		var v moneyJSON
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		if v.Currency != "" && v.Currency != CurrencyUSD {
+			return ErrUnsupportedCurrency
+		}
+		*m = Cents(v.Cents)
+		return nil
+	case '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		data = []byte(s)
+	}
+
+	parsed, err := ParseMoney(string(data))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Stored as bigint cents, the currency isn't stored while there's only one
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = Cents(0)
+	case int64:
+		*m = Cents(v)
+	case int32:
+		*m = Cents(int64(v))
+	case string:
+		cents, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*m = Cents(cents)
+	default:
+		return fmt.Errorf("money: can't scan %T", src)
+	}
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.Cents, nil
+}
@@ -0,0 +1,99 @@
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+)
+
+func TestParseMoney(t *testing.T) {
+	valid := map[string]int64{
+		"12.34": 1234,
+		"12.3":  1230,
+		"12":    1200,
+		".5":    50,
+		"-0.05": -5,
+		" 7.10": 710,
+	}
+
+	for s, cents := range valid {
+		m, err := api.ParseMoney(s)
+		if err != nil || m != api.Cents(cents) {
+			t.Errorf("%q: expected %d cents, got %+v %v", s, cents, m, err)
+		}
+	}
+
+	for _, s := range []string{"", "-", ".", "1.234", "1e3", "abc", "+1", "12345678901234567"} {
+		if _, err := api.ParseMoney(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+// Adding a cent a hundred thousand times is where floats started to drift
+func TestMoneyArithmetic(t *testing.T) {
+	var total api.Money
+	for range 100_000 {
+		total = total.Add(api.Cents(1))
+	}
+	if total != api.Cents(100_000) || total.String() != "1000.00" {
+		t.Errorf("expected 1000.00, got %v", total)
+	}
+
+	cost := api.Cents(250).Mul(3)
+	if cost.String() != "7.50" || cost.Neg().String() != "-7.50" {
+		t.Errorf("unexpected cost %v", cost)
+	}
+
+	if api.Cents(5).Sub(api.Cents(10)).Cmp(api.Money{}) != -1 {
+		t.Error("expected a negative difference")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected mixing currencies to panic")
+		}
+	}()
+	api.Cents(1).Add(api.Money{Cents: 1, Currency: "EUR"})
+}
+
+func TestMoneyJSON(t *testing.T) {
+	data, err := json.Marshal(api.Cents(1234))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"cents":1234,"currency":"USD"}` {
+		t.Errorf("unexpected json %s", data)
+	}
+
+	for _, input := range []string{`{"cents":1234,"currency":"USD"}`, `12.34`, `"12.34"`} {
+		var m api.Money
+		if err := json.Unmarshal([]byte(input), &m); err != nil || m != api.Cents(1234) {
+			t.Errorf("%s: expected 12.34, got %+v %v", input, m, err)
+		}
+	}
+
+	var m api.Money
+	if err := json.Unmarshal([]byte(`0.001`), &m); err == nil {
+		t.Error("expected fractions of a cent to be rejected")
+	}
+
+	err = json.Unmarshal([]byte(`{"cents":1234,"currency":"EUR"}`), &m)
+	if !errors.Is(err, api.ErrUnsupportedCurrency) {
+		t.Errorf("expected unsupported currency, got %v", err)
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	var m api.Money
+	if err := m.Scan(int64(999)); err != nil || m != api.Cents(999) {
+		t.Errorf("expected 9.99, got %+v %v", m, err)
+	}
+
+	value, _ := m.Value()
+	if value != int64(999) {
+		t.Errorf("expected 999 cents stored, got %v", value)
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 20
+
+	MinPasswordLength = 8
+	// bcrypt ignores everything past 72 bytes
+	MaxPasswordLength = 72
+
+	maxEmailLength = 254
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Names that could pass for staff or the site itself
+var reservedUsernames = map[string]bool{
+	"admin": true, "administrator": true, "moderator": true, "mod": true,
+	"support": true, "staff": true, "system": true, "root": true,
+	"csupgrade": true, "official": true, "steam": true, "valve": true,
+	"null": true, "undefined": true, "api": true, "me": true,
+}
+
+// Words that aren't allowed in a username. Matched against whole words so
+// names like Scunthorpe or grapefruit still get through, see usernameWords.
+var blockedWords = map[string]bool{
+	"fuck": true, "shit": true, "cunt": true, "nigger": true, "nigga": true,
+	"faggot": true, "retard": true, "whore": true, "rape": true,
+}
+
+// Emails are compared case-insensitively everywhere, so they're stored lower
+// case
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Usernames keep the case the user picked for display. Uniqueness is checked
+// on the case-folded form.
+func NormalizeUsername(username string) string {
+	return strings.TrimSpace(username)
+}
+
+func ValidateUsername(username string) error {
+	if len(username) < MinUsernameLength || len(username) > MaxUsernameLength {
+		return ErrInvalidUsername
+	}
+
+	if !usernamePattern.MatchString(username) {
+		return ErrInvalidUsername
+	}
+
+	folded := strings.ToLower(username)
+	if reservedUsernames[folded] {
+		return ErrUsernameNotAllowed
+	}
+
+	for _, word := range usernameWords(username) {
+		if blockedWords[word] {
+			return ErrUsernameNotAllowed
+		}
+	}
+
+	return nil
+}
+
+// Splits a username into lower case words at separators, digits and
+// camelCase humps. Runs of single letters are joined back up so spelling a
+// word out as f.u.c.k doesn't get it through.
+func usernameWords(username string) []string {
+	parts := make([]string, 0)
+	start := -1
+	for i, r := range username {
+		letter := unicode.IsLetter(r)
+		hump := start >= 0 && unicode.IsUpper(r) && unicode.IsLower(rune(username[i-1]))
+		if start >= 0 && (!letter || hump) {
+			parts = append(parts, strings.ToLower(username[start:i]))
+			start = -1
+		}
+		if letter && start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		parts = append(parts, strings.ToLower(username[start:]))
+	}
+
+	words := make([]string, 0, len(parts))
+	spelled := ""
+	for _, part := range parts {
+		if len(part) == 1 {
+			spelled += part
+			continue
+		}
+		if spelled != "" {
+			words = append(words, spelled)
+			spelled = ""
+		}
+		words = append(words, part)
+	}
+	if spelled != "" {
+		words = append(words, spelled)
+	}
+
+	return words
+}
+
+// Expects a normalized email. Only bare addresses are accepted, no display
+// names or comments.
+func ValidateEmail(email string) error {
+	if len(email) > maxEmailLength {
+		return ErrInvalidEmail
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return ErrInvalidEmail
+	}
+
+	_, domain, _ := strings.Cut(email, "@")
+	if !strings.Contains(domain, ".") {
+		return ErrInvalidEmail
+	}
+
+	return nil
+}
+
+// Passwords need MinPasswordLength characters from at least two of lower
+// case, upper case, digits and symbols
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+
+	if len(password) > MaxPasswordLength {
+		return ErrPasswordTooLong
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, symbol} {
+		if ok {
+			classes++
+		}
+	}
+
+	if classes < 2 {
+		return ErrWeakPassword
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"sort"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/pricing"
+)
+
+const DefaultPriceRange = "30d"
+
+// How many days back each chart range goes, all goes back to the first data
+// kept, up to PriceHistoryRetention
+var priceRanges = map[string]int{"7d": 7, "30d": 30, "90d": 90, "1y": 365, "all": 0}
+
+// Start of the first UTC day in the range, zero for all
+func rangeStart(rangeName string, now time.Time) (time.Time, error) {
+	if rangeName == "" {
+		rangeName = DefaultPriceRange
+	}
+
+	days, ok := priceRanges[rangeName]
+	if !ok {
+		return time.Time{}, ErrInvalidPriceRange
+	}
+	if days == 0 {
+		return time.Time{}, nil
+	}
+
+	return startOfDay(now).AddDate(0, 0, 1-days), nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// A series per wear and StatTrak, best wear first, with a point for each day
+// the skin was priced
+func (p *pricingService) GetSkinPrices(skinID int, rangeName string) ([]PriceSeries, error) {
+	since, err := rangeStart(rangeName, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	closes, err := p.storage.GetDailyPrices([]int{skinID}, since)
+	if err != nil {
+		return nil, err
+	}
+
+	series := make([]PriceSeries, 0)
+	index := make(map[priceKey]int)
+	for _, c := range closes {
+		k := priceKey{c.SkinID, c.Wear, c.IsStatTrak}
+		i, ok := index[k]
+		if !ok {
+			i = len(series)
+			index[k] = i
+			series = append(series, PriceSeries{Wear: c.Wear, IsStatTrak: c.IsStatTrak})
+		}
+		series[i].Points = append(series[i].Points, PricePoint{Date: c.TakenAt, Price: c.Price})
+	}
+
+	sort.SliceStable(series, func(i, j int) bool {
+		wi, _ := pricing.ParseWear(series[i].Wear)
+		wj, _ := pricing.ParseWear(series[j].Wear)
+		if wi != wj {
+			return wi < wj
+		}
+		return !series[i].IsStatTrak && series[j].IsStatTrak
+	})
+
+	return series, nil
+}
+
+// What the user's inventory was worth at the end of each day. Items are
+// valued at their wear's price that day, or the last one before it in the
+// range, and at what they were worth when added if there isn't one.
+func (p *pricingService) GetInventoryValueHistory(userID, rangeName string) ([]ValuePoint, error) {
+	now := time.Now()
+	since, err := rangeStart(rangeName, now)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := p.storage.GetHeldItems(userID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) == 0 {
+		return []ValuePoint{}, nil
+	}
+
+	// all starts the day the first item was added
+	if since.IsZero() {
+		since = startOfDay(items[0].AddedAt)
+		for _, item := range items {
+			if day := startOfDay(item.AddedAt); day.Before(since) {
+				since = day
+			}
+		}
+	}
+
+	seen := make(map[int]bool)
+	skinIDs := make([]int, 0)
+	for _, item := range items {
+		if !seen[item.SkinID] {
+			seen[item.SkinID] = true
+			skinIDs = append(skinIDs, item.SkinID)
+		}
+	}
+
+	closes, err := p.storage.GetDailyPrices(skinIDs, since)
+	if err != nil {
+		return nil, err
+	}
+
+	return inventoryValues(items, closes, since, startOfDay(now)), nil
+}
+
+type priceKey struct {
+	skinID   int
+	wear     string
+	statTrak bool
+}
+
+// A point per day from first to last. closes are in day order for each key.
+func inventoryValues(items []HeldItem, closes []PriceSnapshot, first, last time.Time) []ValuePoint {
+	history := make(map[priceKey][]PriceSnapshot)
+	for _, c := range closes {
+		k := priceKey{c.SkinID, c.Wear, c.IsStatTrak}
+		history[k] = append(history[k], c)
+	}
+
+	points := make([]ValuePoint, 0)
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		end := day.AddDate(0, 0, 1)
+		value := Cents(0)
+
+		for _, item := range items {
+			if !item.AddedAt.Before(end) || item.RemovedAt != nil && item.RemovedAt.Before(end) {
+				continue
+			}
+
+			price := item.Price
+			k := priceKey{item.SkinID, pricing.Wears[pricing.WearOf(item.Float)].Name, item.IsStatTrak}
+			h := history[k]
+			// first close after the day, the one before it is the day's price
+			if i := sort.Search(len(h), func(i int) bool { return h[i].TakenAt.After(day) }); i > 0 {
+				price = h[i-1].Price
+			}
+
+			value = value.Add(price)
+		}
+
+		points = append(points, ValuePoint{Date: day, Value: value})
+	}
+
+	return points
+}
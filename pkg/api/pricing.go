@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/pricing"
+)
+
+const (
+	priceFetchTimeout = time.Minute
+
+	// How long daily closes are kept for charts and inventory values
+	PriceHistoryRetention = 2 * 365 * 24 * time.Hour
+)
+
+// What a skin is worth right now. The zero Money and false for skins the
+// price list doesn't cover.
+type Pricer interface {
+	Price(skinID int, float float64, statTrak bool) (Money, bool)
+}
+
+// Keeps skin prices current from a price source, and takes price files
+// imported by hand. The last update of each day is kept as that day's close
+// for price history.
+//
+// An imported list is kept in the database and wins over the source, across
+// restarts, until it's cleared. Refreshes from the source are skipped while
+// it's in use.
+type PricingService interface {
+	Pricer
+	Load() error
+	Import(r io.Reader, format string) (int, error)
+	ClearImport() error
+	Refresh() error
+	RefreshPrices()
+	GetSkinPrices(skinID int, rangeName string) ([]PriceSeries, error)
+	GetInventoryValueHistory(userID, rangeName string) ([]ValuePoint, error)
+}
+
+type PricingRepository interface {
+	SavePriceImport(list pricing.PriceList) error
+	// nil when no import is in use
+	GetPriceImport() (pricing.PriceList, error)
+	ClearPriceImport() error
+	// Replaces the day's close for each snapshot's skin, wear and StatTrak
+	SaveDailyPrices(snapshots []PriceSnapshot) error
+	GetDailyPrices(skinIDs []int, since time.Time) ([]PriceSnapshot, error)
+	DeleteDailyPricesBefore(before time.Time) error
+	GetHeldItems(userID string, since time.Time) ([]HeldItem, error)
+}
+
+type pricingService struct {
+	engine   *pricing.Engine
+	source   pricing.PriceSource
+	storage  PricingRepository
+	interval time.Duration
+	logger   LogService
+
+	// held while loading a list so a refresh can't land on top of an import
+	mu       sync.Mutex
+	imported bool
+}
+
+// source is polled every interval. It can be nil, leaving prices to imports.
+func NewPricingService(engine *pricing.Engine, source pricing.PriceSource, pricingRepo PricingRepository,
+	interval time.Duration, logger LogService) PricingService {
+	return &pricingService{engine: engine, source: source, storage: pricingRepo, interval: interval,
+		logger: logger}
+}
+
+func (p *pricingService) Price(skinID int, float float64, statTrak bool) (Money, bool) {
+	cents, ok := p.engine.Price(skinID, float, statTrak)
+	if !ok {
+		return Money{}, false
+	}
+	return Cents(cents), true
+}
+
+// Loads the imported list if one is in use, the source's latest otherwise.
+// Run once at startup.
+func (p *pricingService) Load() error {
+	list, err := p.storage.GetPriceImport()
+	if err != nil {
+		return err
+	}
+
+	if list == nil {
+		return p.Refresh()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.engine.Load(list); err != nil {
+		return err
+	}
+	p.imported = true
+
+	p.logger.Info("loaded imported prices", "entries", len(list))
+	return nil
+}
+
+// Replaces every price with a CSV or JSON price list until the import is
+// cleared. Returns how many entries were loaded.
+func (p *pricingService) Import(r io.Reader, format string) (int, error) {
+	list, err := pricing.Parse(r, format)
+	if err != nil {
+		return 0, Wrap(ErrInvalidPriceList, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.engine.Load(list); err != nil {
+		return 0, Wrap(ErrInvalidPriceList, err)
+	}
+	p.imported = true
+
+	p.logger.Info("imported prices", "entries", len(list))
+	p.snapshot()
+
+	// the prices are already in use, they just won't survive a restart
+	if err := p.storage.SavePriceImport(list); err != nil {
+		return 0, err
+	}
+
+	return len(list), nil
+}
+
+// Goes back to the source's prices
+func (p *pricingService) ClearImport() error {
+	p.mu.Lock()
+	err := p.storage.ClearPriceImport()
+	if err == nil {
+		p.imported = false
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	p.logger.Info("cleared imported prices")
+	return p.Refresh()
+}
+
+// Loads the latest list from the source, unless an imported one is in use
+func (p *pricingService) Refresh() error {
+	if p.source == nil || p.usingImport() {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), priceFetchTimeout)
+	defer cancel()
+
+	list, err := p.source.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// imported while fetching
+	if p.imported {
+		return nil
+	}
+
+	if err := p.engine.Load(list); err != nil {
+		return err
+	}
+
+	p.logger.Info("refreshed prices", "entries", len(list))
+	p.snapshot()
+	return nil
+}
+
+func (p *pricingService) usingImport() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.imported
+}
+
+// Records the prices as today's close and drops closes past
+// PriceHistoryRetention. The new prices are already in use, so a failure here
+// is only logged.
+func (p *pricingService) snapshot() {
+	takenAt := time.Now()
+
+	quotes := p.engine.Quotes()
+	snapshots := make([]PriceSnapshot, len(quotes))
+	for i, quote := range quotes {
+		snapshots[i] = PriceSnapshot{SkinID: quote.SkinID, Wear: quote.Wear, IsStatTrak: quote.StatTrak,
+			Price: Cents(quote.Price), TakenAt: takenAt}
+	}
+
+	if err := p.storage.SaveDailyPrices(snapshots); err != nil {
+		p.logger.Error("couldn't save daily prices", "error", err)
+	}
+
+	if err := p.storage.DeleteDailyPricesBefore(takenAt.Add(-PriceHistoryRetention)); err != nil {
+		p.logger.Error("couldn't delete old daily prices", "error", err)
+	}
+}
+
+// Refreshes on a timer. A failed refresh keeps the old prices.
+func (p *pricingService) RefreshPrices() {
+	if p.source == nil {
+		return
+	}
+
+	ticker := time.NewTicker(p.interval)
+	for range ticker.C {
+		if err := p.Refresh(); err != nil {
+			p.logger.Error("couldn't refresh prices", "error", err)
+		}
+	}
+}
+
+// Values each skin at today's price. Skins the price list doesn't cover keep
+// what they were worth when they were added.
+func priceItems(p Pricer, items []Item) {
+	for i := range items {
+		skin, ok := items[i].Data.(Skin)
+		if !ok {
+			continue
+		}
+
+		if price, ok := p.Price(skin.ID, skin.Float, skin.IsStatTrak); ok {
+			skin.Price = price
+			items[i].Data = skin
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/erobx/csupgrade-go-api/pkg/pricing"
+)
+
+// Prices by skin ID alone, StatTrak doubles it
+type fakePricer map[int]api.Money
+
+func (f fakePricer) Price(skinID int, float float64, statTrak bool) (api.Money, bool) {
+	price, ok := f[skinID]
+	if ok && statTrak {
+		price = price.Mul(2)
+	}
+	return price, ok
+}
+
+// Prices every skin the same
+type flatPricer api.Money
+
+func (f flatPricer) Price(skinID int, float float64, statTrak bool) (api.Money, bool) {
+	return api.Money(f), true
+}
+
+// Keeps snapshots, serves back whatever history it's given
+type fakePricingRepo struct {
+	api.PricingRepository
+	snapshots []api.PriceSnapshot
+	closes    []api.PriceSnapshot
+	items     []api.HeldItem
+	imported  pricing.PriceList
+	// cutoff of the last retention run
+	prunedBefore time.Time
+}
+
+func (f *fakePricingRepo) SavePriceImport(list pricing.PriceList) error {
+	f.imported = list
+	return nil
+}
+
+func (f *fakePricingRepo) GetPriceImport() (pricing.PriceList, error) {
+	return f.imported, nil
+}
+
+func (f *fakePricingRepo) ClearPriceImport() error {
+	f.imported = nil
+	return nil
+}
+
+// Always hands back the same list
+type fakePriceSource pricing.PriceList
+
+func (f fakePriceSource) Fetch(ctx context.Context) (pricing.PriceList, error) {
+	return pricing.PriceList(f), nil
+}
+
+func (f *fakePricingRepo) SaveDailyPrices(snapshots []api.PriceSnapshot) error {
+	f.snapshots = append(f.snapshots, snapshots...)
+	return nil
+}
+
+func (f *fakePricingRepo) DeleteDailyPricesBefore(before time.Time) error {
+	f.prunedBefore = before
+	return nil
+}
+
+func (f *fakePricingRepo) GetDailyPrices(skinIDs []int, since time.Time) ([]api.PriceSnapshot, error) {
+	return f.closes, nil
+}
+
+func (f *fakePricingRepo) GetHeldItems(userID string, since time.Time) ([]api.HeldItem, error) {
+	return f.items, nil
+}
+
+func newPricingService(repo api.PricingRepository) api.PricingService {
+	return api.NewPricingService(pricing.NewEngine(pricing.DefaultConfig()), nil, repo, time.Hour,
+		api.NewLogger())
+}
+
+func TestImportPrices(t *testing.T) {
+	repo := &fakePricingRepo{}
+	prices := newPricingService(repo)
+
+	n, err := prices.Import(strings.NewReader("skin_id,wear,price\n1,FT,250\n"), pricing.FormatCSV)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 entry imported, got %d, %v", n, err)
+	}
+
+	if price, ok := prices.Price(1, 0.265, false); !ok || price != api.Cents(250) {
+		t.Errorf("expected 2.50, got %v %v", price, ok)
+	}
+
+	// a normal and a StatTrak snapshot for the entry
+	if len(repo.snapshots) != 2 || repo.snapshots[0].Wear != "Field-Tested" {
+		t.Errorf("expected 2 Field-Tested snapshots, got %+v", repo.snapshots)
+	}
+
+	cutoff := time.Now().Add(-api.PriceHistoryRetention)
+	if d := repo.prunedBefore.Sub(cutoff); d > 0 || d < -time.Minute {
+		t.Errorf("expected closes before %v to be deleted, got %v", cutoff, repo.prunedBefore)
+	}
+
+	bad := []string{"skin_id,wear,price\n1,FT,abc\n", "skin_id,wear,price\n1,Mint,250\n",
+		"skin_id,wear,price\n", "[]"}
+	for _, data := range bad {
+		format := pricing.FormatCSV
+		if strings.HasPrefix(data, "[") {
+			format = pricing.FormatJSON
+		}
+		if _, err := prices.Import(strings.NewReader(data), format); !errors.Is(err, api.ErrInvalidPriceList) {
+			t.Errorf("%q: expected invalid price list, got %v", data, err)
+		}
+	}
+}
+
+func TestImportedPricesWinOverSource(t *testing.T) {
+	repo := &fakePricingRepo{}
+	source := fakePriceSource{{SkinID: 1, Wear: "FT", Price: 100}}
+	newService := func() api.PricingService {
+		return api.NewPricingService(pricing.NewEngine(pricing.DefaultConfig()), source, repo, time.Hour,
+			api.NewLogger())
+	}
+	price := func(prices api.PricingService) api.Money {
+		price, _ := prices.Price(1, 0.265, false)
+		return price
+	}
+
+	prices := newService()
+	if _, err := prices.Import(strings.NewReader("skin_id,wear,price\n1,FT,250\n"), pricing.FormatCSV); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := prices.Refresh(); err != nil || price(prices) != api.Cents(250) {
+		t.Fatalf("expected the refresh to keep the import, got %v %v", price(prices), err)
+	}
+
+	restarted := newService()
+	if err := restarted.Load(); err != nil || price(restarted) != api.Cents(250) {
+		t.Fatalf("expected the import to survive a restart, got %v %v", price(restarted), err)
+	}
+
+	if err := restarted.ClearImport(); err != nil || price(restarted) != api.Cents(100) {
+		t.Errorf("expected the source's prices once cleared, got %v %v", price(restarted), err)
+	}
+}
+
+func TestGetInventoryUsesCurrentPrices(t *testing.T) {
+	repo := &fakeUserRepo{items: []api.Item{
+		{InvID: 1, Data: api.Skin{ID: 1, Price: api.Cents(100)}},
+		{InvID: 2, Data: api.Skin{ID: 1, Price: api.Cents(100), IsStatTrak: true}},
+		{InvID: 3, Data: api.Skin{ID: 2, Price: api.Cents(100)}},
+	}}
+	users := api.NewUserService(repo, fakePricer{1: api.Cents(500)}, api.NewStatsCache(time.Minute),
+		api.NewLogger())
+
+	inv, err := users.GetInventory("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unpriced skins keep what they were worth when added
+	want := []api.Money{api.Cents(500), api.Cents(1000), api.Cents(100)}
+	for i, item := range inv.Items {
+		if got := item.Data.(api.Skin).Price; got != want[i] {
+			t.Errorf("item %d: expected %v, got %v", item.InvID, want[i], got)
+		}
+	}
+}
+
+func TestBuyCratePricesEachRoll(t *testing.T) {
+	pricer := fakePricer{}
+	for _, drop := range testCrate().Contents {
+		pricer[drop.SkinID] = api.Cents(int64(drop.SkinID) * 100)
+	}
+
+	repo := &fakeStoreRepo{}
+	store := newStoreService(repo, pricer)
+
+	if _, _, err := store.BuyCrate("1", "u1", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, roll := range repo.rolls {
+		want, _ := pricer.Price(roll.SkinID, roll.Float, roll.IsStatTrak)
+		if roll.Price != want {
+			t.Errorf("roll %d: expected %v, got %v", i, want, roll.Price)
+		}
+	}
+}
+
+func TestGetSkinPrices(t *testing.T) {
+	day := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo := &fakePricingRepo{closes: []api.PriceSnapshot{
+		{SkinID: 1, Wear: "Battle-Scarred", Price: api.Cents(100), TakenAt: day},
+		{SkinID: 1, Wear: "Factory New", IsStatTrak: true, Price: api.Cents(2000), TakenAt: day},
+		{SkinID: 1, Wear: "Factory New", Price: api.Cents(1000), TakenAt: day},
+		{SkinID: 1, Wear: "Factory New", Price: api.Cents(1100), TakenAt: day.AddDate(0, 0, 1)},
+	}}
+
+	series, err := newPricingService(repo).GetSkinPrices(1, "7d")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		wear     string
+		statTrak bool
+		points   int
+	}{{"Factory New", false, 2}, {"Factory New", true, 1}, {"Battle-Scarred", false, 1}}
+	if len(series) != len(want) {
+		t.Fatalf("expected %d series, got %+v", len(want), series)
+	}
+	for i, w := range want {
+		if series[i].Wear != w.wear || series[i].IsStatTrak != w.statTrak || len(series[i].Points) != w.points {
+			t.Errorf("series %d: expected %+v, got %+v", i, w, series[i])
+		}
+	}
+
+	if _, err := newPricingService(repo).GetSkinPrices(1, "2w"); !errors.Is(err, api.ErrInvalidPriceRange) {
+		t.Errorf("expected invalid range, got %v", err)
+	}
+}
+
+func TestGetInventoryValueHistory(t *testing.T) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	daysAgo := func(n int) time.Time { return today.AddDate(0, 0, -n) }
+	removed := today.Add(time.Minute)
+
+	repo := &fakePricingRepo{
+		items: []api.HeldItem{
+			{SkinID: 1, Float: 0.01, Price: api.Cents(100), AddedAt: daysAgo(10)},
+			{SkinID: 2, Float: 0.5, Price: api.Cents(300), AddedAt: daysAgo(2).Add(time.Hour),
+				RemovedAt: &removed},
+		},
+		closes: []api.PriceSnapshot{
+			{SkinID: 1, Wear: "Factory New", Price: api.Cents(500), TakenAt: daysAgo(5)},
+			{SkinID: 1, Wear: "Factory New", Price: api.Cents(700), TakenAt: daysAgo(1)},
+			// another wear of the same skin doesn't count
+			{SkinID: 1, Wear: "Minimal Wear", Price: api.Cents(9900), TakenAt: daysAgo(3)},
+		},
+	}
+
+	points, err := newPricingService(repo).GetInventoryValueHistory("user-1", "7d")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stored price until the first close, the last close carries over, and the
+	// item removed today isn't in today's value
+	want := []int64{100, 500, 500, 500, 800, 1000, 700}
+	if len(points) != len(want) {
+		t.Fatalf("expected %d points, got %+v", len(want), points)
+	}
+	for i, cents := range want {
+		if !points[i].Date.Equal(daysAgo(6-i)) || points[i].Value != api.Cents(cents) {
+			t.Errorf("point %d: expected %v on %v, got %+v", i, cents, daysAgo(6-i), points[i])
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import "context"
+
+const maxShowcaseItems = 6
+
+// Responsible for what other users can see about a user
+type ProfileService interface {
+	GetProfile(username string) (Profile, error)
+	UpdatePrivacy(userID string, settings PrivacySettings) error
+	SetShowcase(userID string, invIDs []int) error
+	SetAvatar(userID string, data []byte) (User, error)
+}
+
+type ProfileRepository interface {
+	GetUserByID(userID string) (User, error)
+	GetUserByUsername(username string) (User, error)
+	SetAvatarKey(userID, avatarKey string) (string, error)
+	GetInventory(userID string) (Inventory, error)
+	GetShowcase(userID string) ([]Item, error)
+	SetShowcase(userID string, invIDs []int) error
+	UpdatePrivacy(userID string, settings PrivacySettings) error
+}
+
+type profileService struct {
+	storage ProfileRepository
+	users   UserService
+	pricer  Pricer
+	blobs   BlobStore
+	logger  LogService
+}
+
+// Stats come from the user service so profiles share its cache. Avatars are
+// uploaded to blobs.
+func NewProfileService(profileRepo ProfileRepository, users UserService, pricer Pricer, blobs BlobStore,
+	logger LogService) ProfileService {
+	return &profileService{storage: profileRepo, users: users, pricer: pricer, blobs: blobs, logger: logger}
+}
+
+func (p *profileService) GetProfile(username string) (Profile, error) {
+	var profile Profile
+
+	user, err := p.storage.GetUserByUsername(username)
+	if err != nil {
+		return profile, err
+	}
+
+	profile.Username = user.Username
+	profile.AvatarSrc = user.AvatarSrc
+	profile.JoinedAt = user.CreatedAt
+	profile.StatsHidden = user.Privacy.HideStats
+	profile.InventoryHidden = user.Privacy.HideInventory
+
+	// showcased items are picked by the user so they're shown even when the
+	// rest of the inventory is hidden
+	profile.Showcase, err = p.storage.GetShowcase(user.ID)
+	if err != nil {
+		return profile, err
+	}
+
+	if !user.Privacy.HideStats {
+		stats, err := p.users.GetStats(user.ID)
+		if err != nil {
+			return profile, err
+		}
+		profile.Stats = &stats
+	}
+
+	profile.Inventory = make([]Item, 0)
+	if !user.Privacy.HideInventory {
+		inv, err := p.storage.GetInventory(user.ID)
+		if err != nil {
+			return profile, err
+		}
+
+		// items sitting in a tradeup aren't visible
+		for _, item := range inv.Items {
+			if item.Visible {
+				profile.Inventory = append(profile.Inventory, item)
+			}
+		}
+	}
+
+	priceItems(p.pricer, profile.Showcase)
+	priceItems(p.pricer, profile.Inventory)
+
+	return profile, nil
+}
+
+func (p *profileService) UpdatePrivacy(userID string, settings PrivacySettings) error {
+	return p.storage.UpdatePrivacy(userID, settings)
+}
+
+func (p *profileService) SetShowcase(userID string, invIDs []int) error {
+	seen := make(map[int]bool)
+	unique := make([]int, 0, len(invIDs))
+	for _, id := range invIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	if len(unique) > maxShowcaseItems {
+		return ErrShowcaseFull
+	}
+
+	return p.storage.SetShowcase(userID, unique)
+}
+
+// Replaces the user's avatar with the uploaded image. Returns the updated
+// user so the caller has the new avatar url.
+func (p *profileService) SetAvatar(userID string, data []byte) (User, error) {
+	var user User
+
+	images, err := ProcessAvatar(data)
+	if err != nil {
+		return user, err
+	}
+
+	avatarKey, err := newAvatarKey(userID)
+	if err != nil {
+		return user, err
+	}
+
+	ctx := context.Background()
+	for size, key := range avatarBlobKeys(avatarKey) {
+		if err := p.blobs.Put(ctx, key, images[size], "image/png"); err != nil {
+			return user, err
+		}
+	}
+
+	oldKey, err := p.storage.SetAvatarKey(userID, avatarKey)
+	if err != nil {
+		return user, err
+	}
+
+	// the new avatar is live, failing to clean up the old one isn't fatal
+	deleteAvatar(p.blobs, oldKey, p.logger)
+
+	p.logger.Info("updated avatar", "user", userID)
+	return p.storage.GetUserByID(userID)
+}
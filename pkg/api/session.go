@@ -0,0 +1,187 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// How long a refresh token stays valid without being used. Every refresh
+// rotates the token and slides the expiry forward.
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+// Responsible for long-lived refresh sessions. Access tokens are short-lived
+// and are only ever reissued by presenting a valid refresh token.
+type SessionService interface {
+	Create(userID, userAgent, ipAddress string) (string, Session, error)
+	Refresh(refreshToken, ipAddress string) (User, string, Session, error)
+	Logout(refreshToken string) error
+	LogoutAll(refreshToken string) error
+	GetSessions(userID string) ([]Session, error)
+	RevokeSession(userID, sessionID string) error
+	IsActive(sessionID string) (bool, error)
+}
+
+type SessionRepository interface {
+	CreateSession(userID, tokenHash, userAgent, ipAddress string, expiresAt time.Time) (Session, error)
+	GetSessionByTokenHash(tokenHash string) (Session, int, error)
+	GetActiveSessions(userID string) ([]Session, error)
+	RotateSession(sessionID, oldHash, newHash, ipAddress string, expiresAt time.Time) error
+	RevokeSession(userID, sessionID string) (bool, error)
+	BumpRefreshTokenVersion(userID string) error
+	IsSessionActive(sessionID string) (bool, error)
+	GetUserByID(userID string) (User, error)
+}
+
+type sessionService struct {
+	storage SessionRepository
+	logger  LogService
+}
+
+func NewSessionService(sessionRepo SessionRepository, logger LogService) SessionService {
+	return &sessionService{storage: sessionRepo, logger: logger}
+}
+
+// Starts a new refresh session for the user. Returns the raw refresh token,
+// only its hash is stored.
+func (ss *sessionService) Create(userID, userAgent, ipAddress string) (string, Session, error) {
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	session, err := ss.storage.CreateSession(userID, hash, userAgent, ipAddress,
+		time.Now().Add(RefreshTokenTTL))
+	if err != nil {
+		return "", session, err
+	}
+
+	return token, session, nil
+}
+
+// Exchanges a refresh token for a new one. The session must not be revoked or
+// expired and must have been issued under the user's current
+// refresh_token_version, so bumping the version kills every session at once.
+func (ss *sessionService) Refresh(refreshToken, ipAddress string) (User, string, Session, error) {
+	var user User
+
+	oldHash := HashToken(refreshToken)
+	session, err := ss.getValidSession(oldHash)
+	if err != nil {
+		return user, "", session, err
+	}
+
+	user, err = ss.storage.GetUserByID(session.UserID)
+	if err != nil {
+		return user, "", session, err
+	}
+
+	if user.BannedAt != nil {
+		return user, "", session, ErrAccountBanned
+	}
+
+	token, newHash, err := newOpaqueToken()
+	if err != nil {
+		return user, "", session, err
+	}
+
+	expiresAt := time.Now().Add(RefreshTokenTTL)
+	err = ss.storage.RotateSession(session.ID, oldHash, newHash, ipAddress, expiresAt)
+	if err != nil {
+		return user, "", session, err
+	}
+	session.ExpiresAt = expiresAt
+
+	return user, token, session, nil
+}
+
+// Ends the session the refresh token belongs to
+func (ss *sessionService) Logout(refreshToken string) error {
+	session, err := ss.getValidSession(HashToken(refreshToken))
+	if err != nil {
+		return err
+	}
+
+	_, err = ss.storage.RevokeSession(session.UserID, session.ID)
+	return err
+}
+
+// Ends every session of the user the refresh token belongs to by bumping
+// their refresh token version
+func (ss *sessionService) LogoutAll(refreshToken string) error {
+	session, err := ss.getValidSession(HashToken(refreshToken))
+	if err != nil {
+		return err
+	}
+
+	err = ss.storage.BumpRefreshTokenVersion(session.UserID)
+	if err != nil {
+		return err
+	}
+
+	ss.logger.Info("logged out everywhere", "user", session.UserID)
+	return nil
+}
+
+func (ss *sessionService) GetSessions(userID string) ([]Session, error) {
+	return ss.storage.GetActiveSessions(userID)
+}
+
+func (ss *sessionService) RevokeSession(userID, sessionID string) error {
+	revoked, err := ss.storage.RevokeSession(userID, sessionID)
+	if err != nil {
+		return err
+	}
+
+	if !revoked {
+		return ErrSessionNotFound
+	}
+
+	ss.logger.Info("revoked session", "user", userID, "session", sessionID)
+	return nil
+}
+
+// Whether the session can still be refreshed. Access tokens are checked
+// against it so logging out ends them straight away rather than when they
+// expire.
+func (ss *sessionService) IsActive(sessionID string) (bool, error) {
+	return ss.storage.IsSessionActive(sessionID)
+}
+
+func (ss *sessionService) getValidSession(tokenHash string) (Session, error) {
+	session, currentVersion, err := ss.storage.GetSessionByTokenHash(tokenHash)
+	if err != nil {
+		return session, ErrInvalidRefreshToken
+	}
+
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return session, ErrInvalidRefreshToken
+	}
+
+	if session.TokenVersion != currentVersion {
+		ss.logger.Info("rejected refresh for stale token version", "user", session.UserID,
+			"session", session.ID)
+		return session, ErrInvalidRefreshToken
+	}
+
+	return session, nil
+}
+
+// Hex encoded SHA-256 of an opaque token. Tokens are high entropy so a fast
+// hash is enough to keep them useless if the table leaks.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newOpaqueToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
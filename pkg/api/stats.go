@@ -0,0 +1,64 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// Caches computed stats per user. Entries are dropped when something that
+// feeds into them changes, the TTL only bounds staleness across instances.
+type StatsCache interface {
+	Get(userID string) (Stats, bool)
+	Set(userID string, stats Stats)
+	Invalidate(userIDs ...string)
+}
+
+type cachedStats struct {
+	stats     Stats
+	expiresAt time.Time
+}
+
+type statsCache struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	entries map[string]cachedStats
+}
+
+func NewStatsCache(ttl time.Duration) StatsCache {
+	return &statsCache{ttl: ttl, entries: make(map[string]cachedStats)}
+}
+
+func (sc *statsCache) Get(userID string) (Stats, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	entry, ok := sc.entries[userID]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return Stats{}, false
+	}
+
+	return entry.stats, true
+}
+
+func (sc *statsCache) Set(userID string, stats Stats) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	now := time.Now()
+	for id, entry := range sc.entries {
+		if now.After(entry.expiresAt) {
+			delete(sc.entries, id)
+		}
+	}
+
+	sc.entries[userID] = cachedStats{stats: stats, expiresAt: now.Add(sc.ttl)}
+}
+
+func (sc *statsCache) Invalidate(userIDs ...string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for _, id := range userIDs {
+		delete(sc.entries, id)
+	}
+}
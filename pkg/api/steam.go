@@ -0,0 +1,138 @@
+package api
+
+import (
+	"errors"
+	"net/url"
+)
+
+// Responsible for Sign in with Steam and linking Steam accounts to existing
+// users
+type SteamService interface {
+	AuthURL() string
+	Login(params url.Values) (User, error)
+	Link(userID string, params url.Values) (User, error)
+	Unlink(userID string) (User, error)
+}
+
+// Verifies OpenID assertions and looks up public Steam profiles
+type SteamProvider interface {
+	AuthURL() string
+	Verify(params url.Values) (string, error)
+	GetProfile(steamID string) (SteamProfile, error)
+}
+
+type SteamRepository interface {
+	GetUserByID(userID string) (User, error)
+	GetUserBySteamID(steamID string) (User, error)
+	CreateSteamUser(profile SteamProfile) (string, error)
+	LinkSteam(userID string, profile SteamProfile) error
+	UnlinkSteam(userID string) error
+	HasPassword(userID string) (bool, error)
+}
+
+type steamService struct {
+	provider SteamProvider
+	storage  SteamRepository
+	logger   LogService
+}
+
+func NewSteamService(provider SteamProvider, steamRepo SteamRepository, logger LogService) SteamService {
+	return &steamService{provider: provider, storage: steamRepo, logger: logger}
+}
+
+// Where to send the user to start the OpenID flow
+func (ss *steamService) AuthURL() string {
+	return ss.provider.AuthURL()
+}
+
+// Verifies the assertion and returns the user linked to the SteamID,
+// creating one if this Steam account hasn't been seen before. Only a missing
+// user creates one, a failed lookup mustn't duplicate an existing account.
+func (ss *steamService) Login(params url.Values) (User, error) {
+	profile, err := ss.verify(params)
+	if err != nil {
+		return User{}, err
+	}
+
+	user, err := ss.storage.GetUserBySteamID(profile.SteamID)
+	if err == nil {
+		// keep persona and avatar in sync with Steam
+		if err := ss.storage.LinkSteam(user.ID, profile); err != nil {
+			return user, err
+		}
+		return ss.storage.GetUserByID(user.ID)
+	}
+
+	if !errors.Is(err, ErrUserNotFound) {
+		return User{}, err
+	}
+
+	userID, err := ss.storage.CreateSteamUser(profile)
+	if err != nil {
+		return user, err
+	}
+	ss.logger.Info("created steam user", "user", userID, "steamId", profile.SteamID)
+
+	return ss.storage.GetUserByID(userID)
+}
+
+// Links the Steam account from the assertion to an existing user
+func (ss *steamService) Link(userID string, params url.Values) (User, error) {
+	profile, err := ss.verify(params)
+	if err != nil {
+		return User{}, err
+	}
+
+	existing, err := ss.storage.GetUserBySteamID(profile.SteamID)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return User{}, err
+	}
+	if err == nil && existing.ID != userID {
+		return existing, ErrSteamAlreadyLinked
+	}
+
+	if err := ss.storage.LinkSteam(userID, profile); err != nil {
+		return User{}, err
+	}
+	ss.logger.Info("linked steam account", "user", userID, "steamId", profile.SteamID)
+
+	return ss.storage.GetUserByID(userID)
+}
+
+// Removes the Steam link. Users without a password would be locked out, so
+// they can't unlink.
+func (ss *steamService) Unlink(userID string) (User, error) {
+	user, err := ss.storage.GetUserByID(userID)
+	if err != nil {
+		return user, err
+	}
+
+	if user.SteamID == "" {
+		return user, ErrSteamNotLinked
+	}
+
+	hasPassword, err := ss.storage.HasPassword(userID)
+	if err != nil {
+		return user, err
+	}
+
+	if !hasPassword {
+		return user, ErrNoPassword
+	}
+
+	if err := ss.storage.UnlinkSteam(userID); err != nil {
+		return user, err
+	}
+	ss.logger.Info("unlinked steam account", "user", userID)
+
+	return ss.storage.GetUserByID(userID)
+}
+
+func (ss *steamService) verify(params url.Values) (SteamProfile, error) {
+	steamID, err := ss.provider.Verify(params)
+	if err != nil {
+		return SteamProfile{}, err
+	}
+
+	return ss.provider.GetProfile(steamID)
+}
@@ -0,0 +1,59 @@
+package api_test
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+)
+
+// Vouches for every assertion as the same SteamID
+type fakeSteamProvider struct {
+	api.SteamProvider
+}
+
+func (fakeSteamProvider) Verify(params url.Values) (string, error) {
+	return "76561197960287930", nil
+}
+
+func (fakeSteamProvider) GetProfile(steamID string) (api.SteamProfile, error) {
+	return api.SteamProfile{SteamID: steamID, PersonaName: "gaben"}, nil
+}
+
+// Fails SteamID lookups with lookupErr, counts the users created
+type fakeSteamRepo struct {
+	api.SteamRepository
+	lookupErr error
+	created   int
+}
+
+func (f *fakeSteamRepo) GetUserBySteamID(steamID string) (api.User, error) {
+	return api.User{}, f.lookupErr
+}
+
+func (f *fakeSteamRepo) CreateSteamUser(profile api.SteamProfile) (string, error) {
+	f.created++
+	return "user-1", nil
+}
+
+func (f *fakeSteamRepo) GetUserByID(userID string) (api.User, error) {
+	return api.User{ID: userID}, nil
+}
+
+func TestSteamLoginOnlyCreatesMissingUsers(t *testing.T) {
+	repo := &fakeSteamRepo{lookupErr: api.ErrUserNotFound}
+	steam := api.NewSteamService(fakeSteamProvider{}, repo, api.NewLogger())
+
+	if user, err := steam.Login(url.Values{}); err != nil || user.ID != "user-1" || repo.created != 1 {
+		t.Fatalf("expected a new user, got %+v %v", user, err)
+	}
+
+	down := errors.New("connection refused")
+	repo = &fakeSteamRepo{lookupErr: down}
+	steam = api.NewSteamService(fakeSteamProvider{}, repo, api.NewLogger())
+
+	if _, err := steam.Login(url.Values{}); !errors.Is(err, down) || repo.created != 0 {
+		t.Errorf("expected the lookup error and no new user, got %v with %d created", err, repo.created)
+	}
+}
@@ -1,31 +1,263 @@
 package api
 
+import (
+	"errors"
+	"math/rand/v2"
+	"strconv"
+)
+
+// Most items that can be picked by ID in one sale
+const MaxSellAmount = 100
+
+// How many times a purchase is rolled again after losing its nonce to
+// another purchase
+const maxRollAttempts = 3
+
+// What the house pays for an item unless SELL_PERCENT says otherwise, as a
+// percentage of its current price
+const DefaultSellPercent = 70
+
 type StoreService interface {
-	BuyCrate(crateID, userID string, amount int) (float64, []Item, error)
+	GetCrates() ([]Crate, error)
+	GetCrate(crateID string) (Crate, error)
+	BuyCrate(crateID, userID string, amount int) (Money, []CrateOpening, error)
+	SellItems(userID string, request *SellRequest) (Money, []ItemSale, error)
 }
 
 type StoreRepository interface {
-	BuyCrate(crateID, userID string, amount int) (float64, []Item, error)
+	GetCrates() ([]Crate, error)
+	GetCrate(crateID string) (Crate, error)
+	BuyCrate(crateID, userID string, rolls []CrateRoll, roll FairRoll) (Money, []Item, error)
+	CheckSkinOwnership(invID, userID string) (bool, error)
+	GetInventory(userID string) (Inventory, error)
+	SellItems(userID string, sales []ItemSale) (Money, error)
 }
 
 type storeService struct {
 	storage StoreRepository
+	weights RarityWeights
+	fairness FairnessService
+	pricer Pricer
+	sellPercent int
+	stats StatsCache
 	logger LogService
 }
 
-func NewStoreService(storeRepo StoreRepository, logger LogService) StoreService {
-	return &storeService{storage: storeRepo, logger: logger}
+// Items sell back for sellPercent of their current price
+func NewStoreService(storeRepo StoreRepository, weights RarityWeights, fairness FairnessService,
+	pricer Pricer, sellPercent int, stats StatsCache, logger LogService) StoreService {
+	return &storeService{storage: storeRepo, weights: weights, fairness: fairness, pricer: pricer,
+		sellPercent: sellPercent, stats: stats, logger: logger}
 }
 
-// Updates the user's current balance if they can purchase and adds skins to
-// their inventory. Returns the new balance and items.
-func (s *storeService) BuyCrate(crateID, userID string, amount int) (float64, []Item, error) {
-	updatedBalance, addedItems, err := s.storage.BuyCrate(crateID, userID, amount)
+// Every crate with its contents and odds
+func (s *storeService) GetCrates() ([]Crate, error) {
+	crates, err := s.storage.GetCrates()
 	if err != nil {
-		return updatedBalance, addedItems, err
+		return crates, err
+	}
+
+	for i := range crates {
+		crates[i] = WithOdds(crates[i], s.weights)
+	}
+
+	return crates, nil
+}
+
+func (s *storeService) GetCrate(crateID string) (Crate, error) {
+	crate, err := s.storage.GetCrate(crateID)
+	if err != nil {
+		return crate, err
+	}
+
+	return WithOdds(crate, s.weights), nil
+}
+
+// Rolls each of amount crates independently against the crate's published
+// odds, then charges cost * amount and adds the skins to the user's inventory
+// in one go, valued at today's prices. Every roll comes from the user's seeds
+// and is recorded with the purchase so it can be verified later. Returns the
+// new balance and an opening per crate.
+func (s *storeService) BuyCrate(crateID, userID string, amount int) (Money, []CrateOpening, error) {
+	if amount < 1 || amount > MaxCrateAmount {
+		return Money{}, nil, ErrInvalidCrateAmount
+	}
+
+	crate, err := s.storage.GetCrate(crateID)
+	if err != nil {
+		return Money{}, nil, err
+	}
+
+	odds := NewDropOdds(NewDropTable(crate.Contents), s.weights)
+	if odds.TotalWeight() == 0 {
+		return Money{}, nil, ErrCrateEmpty
+	}
+
+	inputs := CrateRollInputs{CrateID: crate.ID, Amount: amount, Odds: odds}
+	updatedBalance, addedItems, rolls, err := s.rollCrates(crateID, userID, inputs)
+	for attempt := 1; errors.Is(err, ErrSeedChanged) && attempt < maxRollAttempts; attempt++ {
+		updatedBalance, addedItems, rolls, err = s.rollCrates(crateID, userID, inputs)
+	}
+	if err != nil {
+		return updatedBalance, nil, err
+	}
+
+	s.stats.Invalidate(userID)
+	s.logger.Info("successfully bought crate", "crate", crateID, "user", userID, "amount", amount)
+
+	openings := make([]CrateOpening, len(addedItems))
+	for i, item := range addedItems {
+		openings[i] = CrateOpening{
+			Item: item,
+			Reel: NewReel(crate.Contents, odds, rolls[i].SkinID, rand.IntN),
+			Stop: ReelStop,
+		}
+	}
+
+	return updatedBalance, openings, nil
+}
+
+// Sells items back to the house for a cut of their current price. Items picked
+// by ID must be owned, out of tradeups and priced. The bulk modes skip items
+// that aren't. Returns the new balance and what each item went for.
+func (s *storeService) SellItems(userID string, request *SellRequest) (Money, []ItemSale, error) {
+	modes := 0
+	for _, picked := range []bool{len(request.InvIDs) > 0, request.Below != nil, request.Rarity != ""} {
+		if picked {
+			modes++
+		}
+	}
+	if modes != 1 || len(request.InvIDs) > MaxSellAmount ||
+		request.Below != nil && (request.Below.IsZero() || request.Below.IsNegative()) {
+		return Money{}, nil, ErrInvalidSellRequest
+	}
+
+	// comparing amounts in different currencies panics
+	if request.Below != nil && !request.Below.IsSupported() {
+		return Money{}, nil, UnsupportedCurrency("below")
+	}
+
+	for _, invID := range request.InvIDs {
+		isOwned, err := s.storage.CheckSkinOwnership(strconv.Itoa(invID), userID)
+		if err != nil {
+			return Money{}, nil, err
+		}
+
+		if !isOwned {
+			return Money{}, nil, ErrItemNotOwned
+		}
 	}
 
-	s.logger.Info("successfully bought crate", "crate", crateID, "user", userID)
+	// used and sold items aren't in the inventory
+	inv, err := s.storage.GetInventory(userID)
+	if err != nil {
+		return Money{}, nil, err
+	}
+
+	sales, err := s.pickSales(inv.Items, request)
+	if err != nil {
+		return Money{}, nil, err
+	}
+
+	if len(sales) == 0 {
+		return Money{}, nil, ErrNothingToSell
+	}
+
+	balance, err := s.storage.SellItems(userID, sales)
+	if err != nil {
+		return balance, nil, err
+	}
+
+	s.stats.Invalidate(userID)
+	s.logger.Info("sold items", "user", userID, "items", len(sales))
+
+	return balance, sales, nil
+}
+
+func (s *storeService) pickSales(items []Item, request *SellRequest) ([]ItemSale, error) {
+	byID := make(map[int]Item, len(items))
+	for _, item := range items {
+		byID[item.InvID] = item
+	}
+
+	sales := make([]ItemSale, 0)
+	sell := func(item Item, price Money) {
+		// the ledger has no zero entries, the house pays at least a cent
+		payout := max(price.Cents*int64(s.sellPercent)/100, 1)
+		sales = append(sales, ItemSale{InvID: item.InvID, Price: Cents(payout)})
+	}
+
+	if len(request.InvIDs) > 0 {
+		seen := make(map[int]bool)
+		for _, invID := range request.InvIDs {
+			if seen[invID] {
+				continue
+			}
+			seen[invID] = true
+
+			item, ok := byID[invID]
+			if !ok || !item.Visible {
+				return nil, ErrItemUnavailable
+			}
+
+			price, ok := s.currentPrice(item)
+			if !ok {
+				return nil, ErrItemUnpriced
+			}
+			sell(item, price)
+		}
+		return sales, nil
+	}
+
+	for _, item := range items {
+		price, ok := s.currentPrice(item)
+		if !item.Visible || !ok {
+			continue
+		}
+
+		skin := item.Data.(Skin)
+		if request.Below != nil && price.Cmp(*request.Below) < 0 ||
+			request.Rarity != "" && skin.Rarity == request.Rarity {
+			sell(item, price)
+		}
+	}
+	return sales, nil
+}
+
+func (s *storeService) currentPrice(item Item) (Money, bool) {
+	skin, ok := item.Data.(Skin)
+	if !ok {
+		return Money{}, false
+	}
+	return s.pricer.Price(skin.ID, skin.Float, skin.IsStatTrak)
+}
+
+// Rolls from the user's next nonce and makes the purchase. The roll is saved
+// with it, so there's never a purchase that can't be verified.
+func (s *storeService) rollCrates(crateID, userID string, inputs CrateRollInputs) (Money, []Item,
+	[]CrateRoll, error) {
+	seed, roller, err := s.fairness.NextRoller(userID)
+	if err != nil {
+		return Money{}, nil, nil, err
+	}
+
+	// an unpriced skin would be stored as worth nothing. The nonce isn't used
+	// up, so trying again rolls the same skins and fails until it's priced.
+	rolls := RollCrates(inputs.Odds, inputs.Amount, roller)
+	for i, roll := range rolls {
+		price, ok := s.pricer.Price(roll.SkinID, roll.Float, roll.IsStatTrak)
+		if !ok {
+			s.logger.Error("rolled an unpriced skin", "crate", inputs.CrateID, "skin", roll.SkinID)
+			return Money{}, nil, nil, ErrItemUnpriced
+		}
+		rolls[i].Price = price
+	}
+
+	roll, err := NewFairRoll(seed, userID, GameCrate, strconv.Itoa(inputs.CrateID), inputs, rolls)
+	if err != nil {
+		return Money{}, nil, nil, err
+	}
 
-	return updatedBalance, addedItems, nil
+	balance, items, err := s.storage.BuyCrate(crateID, userID, rolls, roll)
+	return balance, items, rolls, err
 }
@@ -0,0 +1,255 @@
+package api_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+)
+
+// Charges nothing, hands back an item per roll. Sales pay into a balance of
+// $100.
+type fakeStoreRepo struct {
+	api.StoreRepository
+	// purchases that lose their nonce to another one before going through
+	races int
+	rolls []api.CrateRoll
+	saved []api.FairRoll
+	items []api.Item
+	sold  []api.ItemSale
+	// shown in the inventory but still entered in an open tradeup
+	entered map[int]bool
+}
+
+func (f *fakeStoreRepo) CheckSkinOwnership(invID, userID string) (bool, error) {
+	for _, item := range f.items {
+		if strconv.Itoa(item.InvID) == invID {
+			return true, nil
+		}
+	}
+	return invID == "99", nil
+}
+
+func (f *fakeStoreRepo) GetInventory(userID string) (api.Inventory, error) {
+	return api.Inventory{UserID: userID, Items: f.items}, nil
+}
+
+func (f *fakeStoreRepo) SellItems(userID string, sales []api.ItemSale) (api.Money, error) {
+	for _, sale := range sales {
+		if f.entered[sale.InvID] {
+			return api.Money{}, api.ErrItemUnavailable
+		}
+	}
+
+	f.sold = sales
+	balance := api.Cents(10000)
+	for _, sale := range sales {
+		balance = balance.Add(sale.Price)
+	}
+	return balance, nil
+}
+
+func (f *fakeStoreRepo) GetCrate(crateID string) (api.Crate, error) {
+	return testCrate(), nil
+}
+
+func (f *fakeStoreRepo) BuyCrate(crateID, userID string, rolls []api.CrateRoll, roll api.FairRoll) (api.Money,
+	[]api.Item, error) {
+	if f.races > 0 {
+		f.races--
+		return api.Money{}, nil, api.ErrSeedChanged
+	}
+	f.rolls = rolls
+	f.saved = append(f.saved, roll)
+
+	items := make([]api.Item, len(rolls))
+	for i, roll := range rolls {
+		items[i] = api.Item{InvID: i + 1, Data: api.Skin{ID: roll.SkinID, Float: roll.Float}}
+	}
+	return api.Cents(10000), items, nil
+}
+
+func newStoreService(repo api.StoreRepository, pricer api.Pricer) api.StoreService {
+	fairness := api.NewFairnessService(&fakeFairnessRepo{}, api.NewLogger())
+	return api.NewStoreService(repo, api.DefaultRarityWeights(), fairness, pricer, 70,
+		api.NewStatsCache(time.Minute), api.NewLogger())
+}
+
+func TestBuyCrateOpensEachCrate(t *testing.T) {
+	repo := &fakeStoreRepo{}
+	store := newStoreService(repo, flatPricer(api.Cents(100)))
+
+	_, openings, err := store.BuyCrate("1", "u1", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(repo.rolls) != 5 || len(openings) != 5 {
+		t.Fatalf("expected 5 rolls and openings, got %d and %d", len(repo.rolls), len(openings))
+	}
+
+	floats := make(map[float64]bool)
+	for i, opening := range openings {
+		if len(opening.Reel) != api.ReelLength {
+			t.Errorf("opening %d: expected a reel of %d, got %d", i, api.ReelLength, len(opening.Reel))
+		}
+
+		if stop := opening.Reel[opening.Stop]; stop.SkinID != repo.rolls[i].SkinID || stop.Rarity == "" {
+			t.Errorf("opening %d: reel stops on %+v, rolled skin %d", i, stop, repo.rolls[i].SkinID)
+		}
+
+		floats[repo.rolls[i].Float] = true
+	}
+
+	// each crate gets its own rolls
+	if len(floats) != 5 {
+		t.Errorf("expected 5 different floats, got %v", floats)
+	}
+}
+
+func TestBuyCrateAmount(t *testing.T) {
+	store := newStoreService(&fakeStoreRepo{}, fakePricer{})
+
+	for _, amount := range []int{0, -1, api.MaxCrateAmount + 1} {
+		if _, _, err := store.BuyCrate("1", "u1", amount); !errors.Is(err, api.ErrInvalidCrateAmount) {
+			t.Errorf("amount %d: expected invalid amount, got %v", amount, err)
+		}
+	}
+}
+
+// The roll is saved with the purchase, and rolled again if another purchase
+// used the nonce first
+func TestBuyCrateSavesRoll(t *testing.T) {
+	repo := &fakeStoreRepo{races: 1}
+	store := newStoreService(repo, flatPricer(api.Cents(100)))
+
+	if _, _, err := store.BuyCrate("1", "u1", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(repo.saved) != 1 {
+		t.Fatalf("expected one saved roll, got %+v", repo.saved)
+	}
+	roll := repo.saved[0]
+	if roll.UserID != "u1" || roll.Game != api.GameCrate || roll.Reference != "1" ||
+		len(roll.Inputs) == 0 || len(roll.Outcome) == 0 {
+		t.Errorf("unexpected roll %+v", roll)
+	}
+
+	repo = &fakeStoreRepo{races: 3}
+	store = newStoreService(repo, flatPricer(api.Cents(100)))
+	if _, _, err := store.BuyCrate("1", "u1", 2); !errors.Is(err, api.ErrSeedChanged) {
+		t.Errorf("expected seed changed after losing every race, got %v", err)
+	}
+}
+
+func sellTestRepo() *fakeStoreRepo {
+	return &fakeStoreRepo{items: []api.Item{
+		{InvID: 1, Visible: true, Data: api.Skin{ID: 1, Rarity: "Mil-Spec"}},
+		{InvID: 2, Visible: true, Data: api.Skin{ID: 2, Rarity: "Covert"}},
+		{InvID: 3, Visible: true, Data: api.Skin{ID: 3, Rarity: "Mil-Spec", IsStatTrak: true}},
+		// in a tradeup
+		{InvID: 4, Visible: false, Data: api.Skin{ID: 1, Rarity: "Mil-Spec"}},
+		// not on the price list
+		{InvID: 5, Visible: true, Data: api.Skin{ID: 9, Rarity: "Mil-Spec"}},
+		// shown again by a removal from another tradeup, still entered in its own
+		{InvID: 6, Visible: true, Data: api.Skin{ID: 1, Rarity: "Restricted"}},
+	}, entered: map[int]bool{6: true}}
+}
+
+var sellTestPrices = fakePricer{1: api.Cents(1000), 2: api.Cents(50000), 3: api.Cents(250)}
+
+func TestSellItems(t *testing.T) {
+	below := api.Cents(1000)
+	tests := []struct {
+		name    string
+		request api.SellRequest
+		want    []api.ItemSale
+	}{
+		{"by id", api.SellRequest{InvIDs: []int{1, 2, 1}},
+			[]api.ItemSale{{InvID: 1, Price: api.Cents(700)}, {InvID: 2, Price: api.Cents(35000)}}},
+		{"below a price", api.SellRequest{Below: &below},
+			[]api.ItemSale{{InvID: 3, Price: api.Cents(350)}}},
+		{"of a rarity", api.SellRequest{Rarity: "Mil-Spec"},
+			[]api.ItemSale{{InvID: 1, Price: api.Cents(700)}, {InvID: 3, Price: api.Cents(350)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := sellTestRepo()
+			store := newStoreService(repo, sellTestPrices)
+
+			balance, sold, err := store.SellItems("u1", &tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(sold) != len(tt.want) || len(repo.sold) != len(tt.want) {
+				t.Fatalf("expected %+v, got %+v", tt.want, sold)
+			}
+
+			total := api.Cents(0)
+			for i := range tt.want {
+				if sold[i] != tt.want[i] {
+					t.Errorf("sale %d: expected %+v, got %+v", i, tt.want[i], sold[i])
+				}
+				total = total.Add(sold[i].Price)
+			}
+
+			if balance != api.Cents(10000).Add(total) {
+				t.Errorf("expected balance %v, got %v", api.Cents(10000).Add(total), balance)
+			}
+		})
+	}
+}
+
+func TestSellItemsRefuses(t *testing.T) {
+	zero := api.Cents(0)
+	euros := api.Money{Cents: 100, Currency: "EUR"}
+	tests := []struct {
+		name    string
+		request api.SellRequest
+		want    error
+	}{
+		{"nothing picked", api.SellRequest{}, api.ErrInvalidSellRequest},
+		{"two modes", api.SellRequest{InvIDs: []int{1}, Rarity: "Covert"}, api.ErrInvalidSellRequest},
+		{"zero price", api.SellRequest{Below: &zero}, api.ErrInvalidSellRequest},
+		{"other currency", api.SellRequest{Below: &euros}, api.UnsupportedCurrency("below")},
+		{"too many", api.SellRequest{InvIDs: make([]int, api.MaxSellAmount+1)}, api.ErrInvalidSellRequest},
+		{"not owned", api.SellRequest{InvIDs: []int{1, 42}}, api.ErrItemNotOwned},
+		{"in a tradeup", api.SellRequest{InvIDs: []int{1, 4}}, api.ErrItemUnavailable},
+		{"still entered in a tradeup", api.SellRequest{InvIDs: []int{6}}, api.ErrItemUnavailable},
+		// owned but used or sold, so not in the inventory
+		{"used", api.SellRequest{InvIDs: []int{99}}, api.ErrItemUnavailable},
+		{"unpriced", api.SellRequest{InvIDs: []int{5}}, api.ErrItemUnpriced},
+		{"no matches", api.SellRequest{Rarity: "Contraband"}, api.ErrNothingToSell},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := sellTestRepo()
+			store := newStoreService(repo, sellTestPrices)
+
+			if _, _, err := store.SellItems("u1", &tt.request); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+			if repo.sold != nil {
+				t.Errorf("expected nothing sold, got %+v", repo.sold)
+			}
+		})
+	}
+}
+
+func TestBuyCrateRefusesUnpricedRolls(t *testing.T) {
+	repo := &fakeStoreRepo{}
+	store := newStoreService(repo, fakePricer{})
+
+	if _, _, err := store.BuyCrate("1", "u1", 2); !errors.Is(err, api.ErrItemUnpriced) {
+		t.Fatalf("expected unpriced, got %v", err)
+	}
+	if repo.rolls != nil || len(repo.saved) != 0 {
+		t.Errorf("expected nothing bought, got %+v", repo.rolls)
+	}
+}
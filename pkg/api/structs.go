@@ -1,6 +1,9 @@
 package api
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type NewUserRequest struct {
 	Email 	 string `json:"email"`
@@ -13,16 +16,358 @@ type NewLoginRequest struct {
 	Password string `json:"password"`
 }
 
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+	Code     string `json:"code"` // TOTP or recovery code when 2FA is on
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type AdjustBalanceRequest struct {
+	Delta  Money  `json:"delta"`
+	Reason string `json:"reason"`
+}
+
+type BanRequest struct {
+	Reason string `json:"reason"`
+}
+
+type CrateUpdate struct {
+	Name *string  `json:"name,omitempty"`
+	Cost *Money `json:"cost,omitempty"`
+}
+
+// Weights line up with SkinIDs. Leaving them out gives every skin equal odds.
+type CrateSkinsRequest struct {
+	SkinIDs []int `json:"skinIds"`
+	Weights []int `json:"weights,omitempty"`
+}
+
+// A crate as listed in the store, with the odds BuyCrate rolls against.
+// TotalWeight is the sum of the weights of the rarities in the crate.
+type Crate struct {
+	ID          int          `json:"id"`
+	Name        string       `json:"name"`
+	Cost        Money        `json:"cost"`
+	TotalWeight int          `json:"totalWeight"`
+	Contents    []CrateDrop  `json:"contents"`
+	Rarities    []RarityOdds `json:"rarities"`
+}
+
+// A skin that can drop from a crate. Weight is relative to the other skins of
+// its rarity, Probability is the overall chance of it dropping.
+type CrateDrop struct {
+	SkinID        int     `json:"skinId"`
+	Name          string  `json:"name"`
+	Rarity        string  `json:"rarity"`
+	Collection    string  `json:"collection"`
+	CanBeStatTrak bool    `json:"canBeStatTrak"`
+	ImgSrc        string  `json:"imgSrc"`
+	Weight        int     `json:"weight"`
+	Probability   float64 `json:"probability"`
+}
+
+// Odds of a crate rolling a rarity. Weight is the configured weight of the
+// rarity, Probability is Weight over the crate's TotalWeight.
+type RarityOdds struct {
+	Rarity      string  `json:"rarity"`
+	Skins       int     `json:"skins"`
+	Weight      int     `json:"weight"`
+	Probability float64 `json:"probability"`
+}
+
+// A skin shown on the opening reel
+type ReelSlot struct {
+	SkinID int    `json:"skinId"`
+	Name   string `json:"name"`
+	Rarity string `json:"rarity"`
+	ImgSrc string `json:"imgSrc"`
+}
+
+// One crate out of a purchase. The reel spins past filler and stops on the
+// slot at Stop, which always holds Item.
+type CrateOpening struct {
+	Item Item       `json:"item"`
+	Reel []ReelSlot `json:"reel"`
+	Stop int        `json:"stop"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
 type User struct {
 	ID 		 			string 		`json:"id"`
 	Username 			string 		`json:"username"`
 	Email 	 			string 		`json:"email"`
-	Balance 			float64 	`json:"balance"`
+	EmailVerified 		bool 		`json:"emailVerified"`
+	Balance 			Money 		`json:"balance"`
 	AvatarSrc 			string 		`json:"avatarSrc"`
+	SteamID 			string 		`json:"steamId,omitempty"`
+	SteamPersona 		string 		`json:"steamPersona,omitempty"`
+	TwoFactorEnabled 	bool 		`json:"twoFactorEnabled"`
+	Privacy 			PrivacySettings `json:"privacy"`
+	Role 				string 		`json:"role"`
+	BannedAt 			*time.Time 	`json:"bannedAt,omitempty"`
+	BanReason 			string 		`json:"banReason,omitempty"`
 	RefreshTokenVersion int 		`json:"refreshTokenVersion"`
+	UsernameChangedAt 	*time.Time 	`json:"usernameChangedAt,omitempty"`
+	DeleteAfter 		*time.Time 	`json:"deleteAfter,omitempty"`
 	CreatedAt 			time.Time 	`json:"createdAt"`
 }
 
+type PrivacySettings struct {
+	HideInventory bool `json:"hideInventory"`
+	HideStats     bool `json:"hideStats"`
+}
+
+// What other users see, never includes email or balance
+type Profile struct {
+	Username        string    `json:"username"`
+	AvatarSrc       string    `json:"avatarSrc"`
+	JoinedAt        time.Time `json:"joinedAt"`
+	Stats           *Stats    `json:"stats"`
+	StatsHidden     bool      `json:"statsHidden"`
+	Showcase        []Item    `json:"showcase"`
+	Inventory       []Item    `json:"inventory"`
+	InventoryHidden bool      `json:"inventoryHidden"`
+}
+
+type ChangeUsernameRequest struct {
+	Username string `json:"username"`
+}
+
+type ChangeEmailRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Code     string `json:"code,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+	Code            string `json:"code,omitempty"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code,omitempty"`
+}
+
+// Everything stored about a user, served by the data export
+type AccountExport struct {
+	ExportedAt     time.Time       `json:"exportedAt"`
+	User           User            `json:"user"`
+	Inventory      Inventory       `json:"inventory"`
+	Tradeups       []TradeupEntry  `json:"tradeups"`
+	CratePurchases []CratePurchase `json:"cratePurchases"`
+	BalanceHistory []BalanceChange `json:"balanceHistory"`
+	Sessions       []Session       `json:"sessions"`
+}
+
+// A tradeup the user put items into
+type TradeupEntry struct {
+	TradeupID    int       `json:"tradeupId"`
+	Rarity       string    `json:"rarity"`
+	Status       string    `json:"status"`
+	ItemsEntered int       `json:"itemsEntered"`
+	ValueEntered Money     `json:"valueEntered"`
+	Won          bool      `json:"won"`
+	EnteredAt    time.Time `json:"enteredAt"`
+}
+
+// Exactly one of InvIDs, Below or Rarity picks what to sell. Below is compared
+// with the current price, not what the house pays.
+type SellRequest struct {
+	InvIDs []int  `json:"invIds"`
+	Below  *Money `json:"below"`
+	Rarity string `json:"rarity"`
+}
+
+// An item sold back and what the user was paid for it
+type ItemSale struct {
+	InvID int   `json:"invId"`
+	Price Money `json:"price"`
+}
+
+// Cost is what the purchase was charged. ChargedOnce marks bulk purchases from
+// before every crate was charged for, where that was one crate's cost.
+type CratePurchase struct {
+	CrateID     int       `json:"crateId"`
+	CrateName   string    `json:"crateName"`
+	Amount      int       `json:"amount"`
+	Cost        Money     `json:"cost"`
+	ChargedOnce bool      `json:"chargedOnce,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// The user's side of a ledger transaction
+type LedgerEntry struct {
+	ID            int64     `json:"id"`
+	TransactionID int64     `json:"transactionId"`
+	Amount        Money     `json:"amount"`
+	BalanceAfter  Money     `json:"balanceAfter"`
+	Reason        string    `json:"reason"`
+	Reference     string    `json:"reference"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// A user whose cached balance disagrees with their ledger
+type BalanceDrift struct {
+	UserID string `json:"userId"`
+	Cached Money  `json:"cached"`
+	Ledger Money  `json:"ledger"`
+}
+
+type BalanceChange struct {
+	Delta     Money     `json:"delta"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// What one wear of a skin, StatTrak or not, was priced at. Price is for the
+// middle of the wear.
+type PriceSnapshot struct {
+	SkinID     int
+	Wear       string
+	IsStatTrak bool
+	Price      Money
+	TakenAt    time.Time
+}
+
+type PricePoint struct {
+	Date  time.Time `json:"date"`
+	Price Money     `json:"price"`
+}
+
+// Daily prices for one wear of a skin, StatTrak or not
+type PriceSeries struct {
+	Wear       string       `json:"wear"`
+	IsStatTrak bool         `json:"isStatTrak"`
+	Points     []PricePoint `json:"points"`
+}
+
+type ValuePoint struct {
+	Date  time.Time `json:"date"`
+	Value Money     `json:"value"`
+}
+
+// An item a user held at some point. RemovedAt is nil while they still do.
+type HeldItem struct {
+	SkinID     int
+	Float      float64
+	IsStatTrak bool
+	Price      Money
+	AddedAt    time.Time
+	RemovedAt  *time.Time
+}
+
+type ShowcaseRequest struct {
+	InvIDs []int `json:"invIds"`
+}
+
+type UserToken struct {
+	UserID string
+	Email  string
+}
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TOTPState struct {
+	Secret  string
+	Enabled bool
+}
+
+type AuditEntry struct {
+	ID         int64          `json:"id"`
+	ActorID    string         `json:"actorId"`
+	Action     string         `json:"action"`
+	TargetType string         `json:"targetType"`
+	TargetID   string         `json:"targetId"`
+	Details    map[string]any `json:"details"`
+	CreatedAt  time.Time      `json:"createdAt"`
+}
+
+// A server and client seed pair. ServerSeed is only filled in once the pair
+// has been rotated out, until then only its hash is shown. Nonce is the next
+// one to be used.
+type FairSeed struct {
+	ID             int64      `json:"id"`
+	ServerSeed     string     `json:"serverSeed,omitempty"`
+	ServerSeedHash string     `json:"serverSeedHash"`
+	ClientSeed     string     `json:"clientSeed"`
+	Nonce          uint64     `json:"nonce"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	RevealedAt     *time.Time `json:"revealedAt,omitempty"`
+}
+
+// A random outcome and everything besides the seeds it was rolled from
+type FairRoll struct {
+	ID        int64           `json:"id"`
+	SeedID    int64           `json:"seedId"`
+	UserID    string          `json:"-"`
+	Game      string          `json:"game"`
+	Reference string          `json:"reference"` // crate or tradeup id
+	Nonce     uint64          `json:"nonce"`
+	Inputs    json.RawMessage `json:"inputs"`
+	Outcome   json.RawMessage `json:"outcome"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+type ClientSeedRequest struct {
+	ClientSeed string `json:"clientSeed"`
+}
+
+type VerifyRollRequest struct {
+	RollID int64 `json:"rollId"`
+}
+
+// A roll recomputed from its revealed seeds. Matches is false if the outcome
+// recorded at the time differs from the recomputed one.
+type Verification struct {
+	Roll        FairRoll        `json:"roll"`
+	Seed        FairSeed        `json:"seed"`
+	SeedMatches bool            `json:"seedMatches"`
+	Rolls       []float64       `json:"rolls"`
+	Outcome     json.RawMessage `json:"outcome"`
+	Matches     bool            `json:"matches"`
+}
+
+// The old seed pair, now revealed, and the one that replaced it
+type SeedRotation struct {
+	Revealed FairSeed `json:"revealed"`
+	Current  FairSeed `json:"current"`
+}
+
+type SteamProfile struct {
+	SteamID     string `json:"steamId"`
+	PersonaName string `json:"personaName"`
+	AvatarURL   string `json:"avatarUrl"`
+}
+
 type Player struct {
     Username    string `json:"username"`
     AvatarSrc   string `json:"avatarSrc"`
@@ -46,10 +391,22 @@ type Tradeup struct {
 	Winner		string 		`json:"winner"`
 	StopTime 	time.Time 	`json:"stopTime"`
 	Mode		string		`json:"mode"` // Battle, Team, FFA
+	ServerSeedHash string	`json:"serverSeedHash,omitempty"` // commitment for the winner roll
+	Prizes		DropTable	`json:"prizes,omitempty"` // what the winner roll picks from
     Items   	[]Item  	`json:"items"`
 	Players 	[]Player    `json:"players"`
 }
 
+// The skin a tradeup's winner gets. AvgFloat is the average float of the
+// items put in.
+type TradeupPrize struct {
+	SkinID     int
+	IsStatTrak bool
+	Float      float64
+	AvgFloat   float64
+	Price      Money
+}
+
 type Skin struct {
     ID          int         `json:"id"`
     Name        string      `json:"name"` // AWP | Dragon Lore
@@ -57,13 +414,49 @@ type Skin struct {
     Collection  string      `json:"collection"`// The ... Collection
     Wear        string      `json:"wear"`// Factory New
     Float       float64     `json:"float"` // 0.05231
-    Price       float64     `json:"price"`// $100.34
+    Price       Money       `json:"price"`
     IsStatTrak  bool        `json:"isStatTrak"`
     WasWon      bool        `json:"wasWon"`
     ImgSrc      string      `json:"imgSrc"`
     CreatedAt   time.Time   `json:"createdAt"`
 }
 
+type Stats struct {
+	TradeupsEntered   int           `json:"tradeupsEntered"`
+	TradeupsCompleted int           `json:"tradeupsCompleted"`
+	TradeupsWon       int           `json:"tradeupsWon"`
+	WinRate           float64       `json:"winRate"` // won / completed
+	ValueContributed  Money         `json:"valueContributed"`
+	ValueWon          Money         `json:"valueWon"`
+	NetProfit         Money         `json:"netProfit"` // won - contributed
+	BestPull          *Item         `json:"bestPull"`
+	CratesOpened      int           `json:"cratesOpened"`
+	CrateSpend        Money         `json:"crateSpend"`
+	ByRarity          []RarityStats `json:"byRarity"`
+}
+
+// Tradeup and crate stats for a single rarity. CrateSpend is what the crates
+// the rarity's pulls came out of cost, split evenly between the skins each
+// opening gave.
+type RarityStats struct {
+	Rarity      string  `json:"rarity"`
+	Entered     int     `json:"entered"`
+	Won         int     `json:"won"`
+	Contributed Money   `json:"contributed"`
+	CratePulls  int     `json:"cratePulls"`
+	CrateSpend  Money   `json:"crateSpend"`
+}
+
+// An item won through a tradeup and what went into it
+type WonItem struct {
+	Item        Item      `json:"item"`
+	TradeupID   int       `json:"tradeupId"`
+	Rarity      string    `json:"rarity"` // rarity of the tradeup, the item is one higher
+	InputFloats []float64 `json:"inputFloats"`
+	AvgFloat    float64   `json:"avgFloat"`
+	WonAt       time.Time `json:"wonAt"`
+}
+
 type Winnings struct {
 	Winner 	string	`json:"winner"`
 	Item 	Item	`json:"winningItem"`
@@ -77,3 +470,16 @@ type RecentTradeup struct {
     LastEntered time.Time 	`json:"lastEntered"`
     Items      	[]Item    	`json:"items"`
 }
+
+type Session struct {
+	ID           string     `json:"id"`
+	UserID       string     `json:"userId"`
+	TokenVersion int        `json:"-"`
+	UserAgent    string     `json:"userAgent"`
+	IPAddress    string     `json:"ipAddress"`
+	Current      bool       `json:"current"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastUsedAt   time.Time  `json:"lastUsedAt"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+}
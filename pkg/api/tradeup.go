@@ -2,7 +2,9 @@ package api
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"strconv"
 	"time"
 )
 
@@ -12,6 +14,9 @@ type TradeupService interface {
 	AddSkinToTradeup(tradeupID, invID, userID string) error
 	RemoveSkinFromTradeup(tradeupID, invID, userID string) error
 	ProcessWinners()
+	// Staff actions, written to the audit log along with the change
+	ForceComplete(tradeupID string, audit AuditEntry) error
+	Cancel(tradeupID string, audit AuditEntry) error
 	MaintainTradeupCount()
 }
 
@@ -21,6 +26,7 @@ type TradeupRepository interface {
 	AddSkinToTradeup(tradeupID, invID string) error
 	RemoveSkinFromTradeup(tradeupID, invID string) error
 	MaintainTradeupCount() error
+	CancelTradeup(tradeupID string, audit AuditEntry) error
 
 	CheckSkinOwnership(invID, userID string) (bool, error)
 	IsTradeupFull(tradeupID string) (bool, error)
@@ -29,20 +35,32 @@ type TradeupRepository interface {
 	StopTimer(tradeupID string) error
 	GetStatus(tradeupID string) (string, error)
 	GetExpired() ([]Tradeup, error)
-	DetermineWinner(tradeupID int) (string, error)
-	GiveNewItem(userID, rarity string, avgFloat float64) (Item, error)
+	GetTickets(tradeupID int) ([]TradeupTicket, error)
+	GetPrizes(rarity string) (DropTable, error)
+	GetCommittedPrizes(tradeupID int) (DropTable, error)
+	CompleteTradeup(tradeupID int, winner string, prize TradeupPrize, roll FairRoll,
+		audit *AuditEntry) (Item, error)
+	GetParticipants(tradeupID int) ([]string, error)
+	GetSkinWearRange(skinID int) (float64, float64, error)
 }
 
 type tradeupService struct {
 	storage  TradeupRepository
+	fairness FairnessService
+	pricer   Pricer
 	winnings chan Winnings
+	stats    StatsCache
 	logger   LogService
 }
 
-func NewTradeupService(tr TradeupRepository, w chan Winnings, logger LogService) TradeupService {
+func NewTradeupService(tr TradeupRepository, fairness FairnessService, pricer Pricer, w chan Winnings,
+	stats StatsCache, logger LogService) TradeupService {
 	return &tradeupService{
 		storage:  tr,
+		fairness: fairness,
+		pricer:   pricer,
 		winnings: w,
+		stats:    stats,
 		logger:   logger,
 	}
 }
@@ -62,7 +80,11 @@ func (ts *tradeupService) AddSkinToTradeup(tradeupID, invID, userID string) erro
 	}
 
 	if !isOwned {
-		return errors.New("user does not own requested item")
+		return ErrItemNotOwned
+	}
+
+	if err := ts.checkOpen(tradeupID); err != nil {
+		return err
 	}
 
 	isFull, err := ts.storage.IsTradeupFull(tradeupID)
@@ -71,7 +93,7 @@ func (ts *tradeupService) AddSkinToTradeup(tradeupID, invID, userID string) erro
 	}
 
 	if isFull {
-		return errors.New("cannot add skin - tradeup is full")
+		return ErrTradeupFull
 	}
 
 	contribution, err := ts.storage.GetUserContribution(tradeupID, userID)
@@ -113,7 +135,7 @@ func (ts *tradeupService) RemoveSkinFromTradeup(tradeupID, invID, userID string)
 	}
 
 	if !isOwned {
-		return errors.New("user does not own requested item")
+		return ErrItemNotOwned
 	}
 
 	status, err := ts.storage.GetStatus(tradeupID)
@@ -121,6 +143,16 @@ func (ts *tradeupService) RemoveSkinFromTradeup(tradeupID, invID, userID string)
 		return err
 	}
 
+	if !isOpen(status) {
+		return ErrTradeupLocked
+	}
+
+	err = ts.storage.RemoveSkinFromTradeup(tradeupID, invID)
+	if err != nil {
+		return err
+	}
+
+	// no longer full
 	if status == "Waiting" {
 		err := ts.storage.StopTimer(tradeupID)
 		if err != nil {
@@ -129,7 +161,25 @@ func (ts *tradeupService) RemoveSkinFromTradeup(tradeupID, invID, userID string)
 		log.Printf("Stopped timer for %s\n", tradeupID)
 	}
 
-	return ts.storage.RemoveSkinFromTradeup(tradeupID, invID)
+	return nil
+}
+
+// Completed and cancelled tradeups can't be changed
+func (ts *tradeupService) checkOpen(tradeupID string) error {
+	status, err := ts.storage.GetStatus(tradeupID)
+	if err != nil {
+		return err
+	}
+
+	if !isOpen(status) {
+		return ErrTradeupLocked
+	}
+
+	return nil
+}
+
+func isOpen(status string) bool {
+	return status == "Active" || status == "Waiting"
 }
 
 // Get tradeups with status waiting that have an expired stop time.
@@ -140,47 +190,157 @@ func (ts *tradeupService) ProcessWinners() {
 		expired, err := ts.storage.GetExpired()
 		if err != nil {
 			log.Printf("couldn't get expired - %v\n", err)
-			return
+			continue
 		}
 
 		for _, exp := range expired {
-			winner, err := ts.storage.DetermineWinner(exp.ID)
-			log.Printf("user %s won tradeup %d\n", winner, exp.ID)
-			if err != nil {
-				log.Printf("couldn't determine winner for tradeup %d - %v\n", exp.ID, err)
-				return
+			if err := ts.completeTradeup(exp, nil); err != nil {
+				log.Printf("couldn't complete tradeup %d - %v\n", exp.ID, err)
 			}
+		}
+	}
+}
 
-			floatTotal := 0.0
-			for _, item := range exp.Items {
-				skin, ok := item.Data.(Skin)
-				if ok {
-					floatTotal += skin.Float
-				}
-			}
+// Completes a tradeup right away with whatever items are in it
+func (ts *tradeupService) ForceComplete(tradeupID string, audit AuditEntry) error {
+	tradeup, err := ts.storage.GetTradeupByID(tradeupID)
+	if err != nil {
+		return err
+	}
 
-			rarity := GetNextRarity(exp.Rarity)
-			if rarity == "" {
-				log.Println("error getting rarity")
-				return
-			}
+	if tradeup.Status == "Completed" || tradeup.Status == "Cancelled" {
+		return ErrTradeupClosed
+	}
 
-			// give user new skin
-			newItem, err := ts.storage.GiveNewItem(winner, rarity, floatTotal/10)
-			if err != nil {
-				log.Printf("couldn't give user %s new item\n", winner)
-				return
-			}
+	if len(tradeup.Items) == 0 {
+		return ErrTradeupEmpty
+	}
 
-			winning := Winnings{
-				Winner: winner,
-				Item:   newItem,
-			}
+	return ts.completeTradeup(tradeup, &audit)
+}
+
+// Returns every item to its owner and closes the tradeup without a winner.
+// One that's already closed is ErrTradeupClosed.
+func (ts *tradeupService) Cancel(tradeupID string, audit AuditEntry) error {
+	err := ts.storage.CancelTradeup(tradeupID, audit)
+	if err != nil {
+		return err
+	}
 
-			ts.winnings <- winning
-            ts.logger.Info("processed winner", "winner", winner)
+	ts.logger.Info("cancelled tradeup", "tradeup", tradeupID)
+	return nil
+}
+
+// Rolls the winner and prize from the tradeup's committed seed. Every item is
+// a ticket, so players win in proportion to what they put in. audit is set
+// when staff completed it.
+func (ts *tradeupService) completeTradeup(exp Tradeup, audit *AuditEntry) error {
+	tickets, err := ts.storage.GetTickets(exp.ID)
+	if err != nil {
+		return err
+	}
+
+	if len(tickets) == 0 {
+		return ErrTradeupEmpty
+	}
+
+	prizes, err := ts.storage.GetCommittedPrizes(exp.ID)
+	if err != nil {
+		return err
+	}
+
+	// tradeups from before prizes were committed roll from today's skins
+	if len(prizes) == 0 {
+		rarity := GetNextRarity(exp.Rarity)
+		if rarity == "" {
+			return errors.New("error getting rarity")
+		}
+
+		prizes, err = ts.storage.GetPrizes(rarity)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(prizes) == 0 {
+		return fmt.Errorf("no %s skins to give out", GetNextRarity(exp.Rarity))
+	}
+
+	seed, roller, err := ts.fairness.TradeupRoller(exp.ID, TradeupClientSeed(tickets))
+	if err != nil {
+		return err
+	}
+
+	inputs := TradeupRollInputs{TradeupID: exp.ID, Tickets: tickets, Prizes: prizes}
+	outcome := RollTradeup(inputs, roller)
+	winner := outcome.Winner
+
+	floatTotal := 0.0
+	skins := 0
+	for _, item := range exp.Items {
+		skin, ok := item.Data.(Skin)
+		if ok {
+			floatTotal += skin.Float
+			skins++
 		}
 	}
+
+	// the new skin's float sits as far through its wear range as the average
+	// float of the items put in, a forced tradeup can have fewer than ten
+	avgFloat := 0.0
+	if skins > 0 {
+		avgFloat = floatTotal / float64(skins)
+	}
+	wearMin, wearMax, err := ts.storage.GetSkinWearRange(outcome.SkinID)
+	if err != nil {
+		return fmt.Errorf("couldn't give user %s new item - %w", winner, err)
+	}
+	wearNum := ((wearMax - wearMin) * avgFloat) + wearMin
+	// an unpriced skin would be stored as worth nothing, the tradeup is
+	// retried once it's priced
+	price, ok := ts.pricer.Price(outcome.SkinID, wearNum, outcome.IsStatTrak)
+	if !ok {
+		ts.logger.Error("tradeup rolled an unpriced skin", "tradeup", exp.ID, "skin", outcome.SkinID)
+		return ErrItemUnpriced
+	}
+
+	roll, err := NewFairRoll(seed, "", GameTradeup, strconv.Itoa(exp.ID), inputs, outcome)
+	if err != nil {
+		return err
+	}
+
+	// closes the tradeup, gives the winner the new skin and records the roll
+	// together, a tradeup completed twice is ErrTradeupClosed
+	newItem, err := ts.storage.CompleteTradeup(exp.ID, winner, TradeupPrize{SkinID: outcome.SkinID,
+		IsStatTrak: outcome.IsStatTrak, Float: wearNum, AvgFloat: avgFloat, Price: price}, roll, audit)
+	if err != nil {
+		return fmt.Errorf("couldn't give user %s new item - %w", winner, err)
+	}
+	log.Printf("user %s won tradeup %d\n", winner, exp.ID)
+
+	// everyone who put items in has new stats
+	participants, err := ts.storage.GetParticipants(exp.ID)
+	if err != nil {
+		ts.logger.Error("couldn't get participants", "tradeup", exp.ID, "error", err)
+	}
+	ts.stats.Invalidate(append(participants, winner)...)
+
+	winning := Winnings{
+		Winner: winner,
+		Item:   newItem,
+	}
+
+	// the item is already theirs, a backed up channel only loses the
+	// notification
+	select {
+	case ts.winnings <- winning:
+	default:
+		ts.logger.Error("winnings channel full, dropped notification", "tradeup", exp.ID,
+			"winner", winner)
+	}
+	ts.logger.Info("processed winner", "winner", winner)
+
+	return nil
 }
 
 func (ts *tradeupService) MaintainTradeupCount() {
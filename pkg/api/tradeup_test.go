@@ -0,0 +1,148 @@
+package api_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+)
+
+// One Restricted tradeup with whatever items it's given, completing it keeps
+// what the winner was given
+type fakeTradeupRepo struct {
+	api.TradeupRepository
+	tradeup api.Tradeup
+	prize   api.TradeupPrize
+	audit   *api.AuditEntry
+	// tradeup each entered item is in, every tradeup is Waiting
+	entries map[string]string
+	stopped []string
+}
+
+func (f *fakeTradeupRepo) CheckSkinOwnership(invID, userID string) (bool, error) {
+	return true, nil
+}
+
+func (f *fakeTradeupRepo) GetStatus(tradeupID string) (string, error) {
+	return "Waiting", nil
+}
+
+func (f *fakeTradeupRepo) RemoveSkinFromTradeup(tradeupID, invID string) error {
+	if f.entries[invID] != tradeupID {
+		return api.ErrItemUnavailable
+	}
+	delete(f.entries, invID)
+	return nil
+}
+
+func (f *fakeTradeupRepo) StopTimer(tradeupID string) error {
+	f.stopped = append(f.stopped, tradeupID)
+	return nil
+}
+
+func (f *fakeTradeupRepo) GetTradeupByID(tradeupID string) (api.Tradeup, error) {
+	return f.tradeup, nil
+}
+
+func (f *fakeTradeupRepo) GetTickets(tradeupID int) ([]api.TradeupTicket, error) {
+	tickets := make([]api.TradeupTicket, len(f.tradeup.Items))
+	for i, item := range f.tradeup.Items {
+		tickets[i] = api.TradeupTicket{InvID: item.InvID, UserID: "u1"}
+	}
+	return tickets, nil
+}
+
+func (f *fakeTradeupRepo) GetCommittedPrizes(tradeupID int) (api.DropTable, error) {
+	return api.DropTable{{SkinID: 7, Rarity: "Classified", Weight: 1}}, nil
+}
+
+func (f *fakeTradeupRepo) GetSkinWearRange(skinID int) (float64, float64, error) {
+	return 0, 1, nil
+}
+
+func (f *fakeTradeupRepo) CompleteTradeup(tradeupID int, winner string, prize api.TradeupPrize,
+	roll api.FairRoll, audit *api.AuditEntry) (api.Item, error) {
+	f.prize = prize
+	f.audit = audit
+	return api.Item{InvID: 100, Data: api.Skin{ID: prize.SkinID, Float: prize.Float}}, nil
+}
+
+func (f *fakeTradeupRepo) GetParticipants(tradeupID int) ([]string, error) {
+	return []string{"u1"}, nil
+}
+
+func (f *fakeFairnessRepo) RevealTradeupSeed(tradeupID int, clientSeed string) (api.FairSeed, error) {
+	return api.FairSeed{ID: 1, ServerSeed: "server", ClientSeed: clientSeed}, nil
+}
+
+func TestForceComplete(t *testing.T) {
+	repo := &fakeTradeupRepo{tradeup: api.Tradeup{ID: 3, Rarity: "Restricted", Status: "Active",
+		Items: []api.Item{
+			{InvID: 1, Data: api.Skin{Float: 0.1}},
+			{InvID: 2, Data: api.Skin{Float: 0.3}},
+		}}}
+
+	// nobody is reading winnings, completing mustn't wait for them to
+	winnings := make(chan api.Winnings)
+	tradeups := api.NewTradeupService(repo, api.NewFairnessService(&fakeFairnessRepo{}, api.NewLogger()),
+		flatPricer(api.Cents(100)), winnings, api.NewStatsCache(time.Minute), api.NewLogger())
+
+	done := make(chan error)
+	go func() {
+		done <- tradeups.ForceComplete("3", api.AuditEntry{ActorID: "admin-1", Action: "tradeup.complete"})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("completing blocked on the winnings channel")
+	}
+
+	// the average of the two items put in, not of ten
+	if repo.prize.AvgFloat < 0.1999 || repo.prize.AvgFloat > 0.2001 {
+		t.Errorf("expected an average float of 0.2, got %v", repo.prize.AvgFloat)
+	}
+	if repo.audit == nil || repo.audit.ActorID != "admin-1" {
+		t.Errorf("expected the completion audited with it, got %+v", repo.audit)
+	}
+}
+
+func TestRemoveSkinFromTradeup(t *testing.T) {
+	repo := &fakeTradeupRepo{entries: map[string]string{"1": "A"}}
+	tradeups := api.NewTradeupService(repo, api.NewFairnessService(&fakeFairnessRepo{}, api.NewLogger()),
+		fakePricer{}, make(chan api.Winnings, 1), api.NewStatsCache(time.Minute), api.NewLogger())
+
+	// another tradeup doesn't have the item, nothing changes in either
+	if err := tradeups.RemoveSkinFromTradeup("B", "1", "u1"); !errors.Is(err, api.ErrItemUnavailable) {
+		t.Fatalf("expected item unavailable, got %v", err)
+	}
+	if repo.entries["1"] != "A" || len(repo.stopped) != 0 {
+		t.Fatalf("expected the item left in A and no timer stopped, got %v %v", repo.entries, repo.stopped)
+	}
+
+	if err := tradeups.RemoveSkinFromTradeup("A", "1", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.entries) != 0 || len(repo.stopped) != 1 || repo.stopped[0] != "A" {
+		t.Errorf("expected the item out of A and its timer stopped, got %v %v", repo.entries, repo.stopped)
+	}
+}
+
+func TestForceCompleteRefusesUnpricedPrize(t *testing.T) {
+	repo := &fakeTradeupRepo{tradeup: api.Tradeup{ID: 3, Rarity: "Restricted", Status: "Active",
+		Items: []api.Item{{InvID: 1, Data: api.Skin{Float: 0.1}}}}}
+	tradeups := api.NewTradeupService(repo, api.NewFairnessService(&fakeFairnessRepo{}, api.NewLogger()),
+		fakePricer{}, make(chan api.Winnings, 1), api.NewStatsCache(time.Minute), api.NewLogger())
+
+	err := tradeups.ForceComplete("3", api.AuditEntry{ActorID: "admin-1", Action: "tradeup.complete"})
+	if !errors.Is(err, api.ErrItemUnpriced) {
+		t.Fatalf("expected unpriced, got %v", err)
+	}
+	if repo.audit != nil {
+		t.Errorf("expected the tradeup left open, got %+v", repo.prize)
+	}
+}
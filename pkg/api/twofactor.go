@@ -0,0 +1,250 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/totp"
+)
+
+const (
+	totpIssuer        = "csupgrade"
+	recoveryCodeCount = 10
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Responsible for TOTP enrollment and checking second factors
+type TwoFactorService interface {
+	Enroll(userID string) (TwoFactorEnrollment, error)
+	Confirm(userID, code string) ([]string, error)
+	Disable(userID, code string) error
+	RegenerateRecoveryCodes(userID, code string) ([]string, error)
+	Verify(userID, code string) error
+	RequireFreshCode(userID, code string) error
+}
+
+type TwoFactorRepository interface {
+	GetUserByID(userID string) (User, error)
+	GetTOTP(userID string) (TOTPState, error)
+	SetPendingTOTP(userID, secret string) error
+	EnableTOTP(userID string, codeHashes []string) error
+	DisableTOTP(userID string) error
+	UseTOTPStep(userID string, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID string, codeHashes []string) error
+	UseRecoveryCode(userID, codeHash string) (bool, error)
+}
+
+type twoFactorService struct {
+	storage TwoFactorRepository
+	logger  LogService
+}
+
+func NewTwoFactorService(twoFactorRepo TwoFactorRepository, logger LogService) TwoFactorService {
+	return &twoFactorService{storage: twoFactorRepo, logger: logger}
+}
+
+// Generates a new secret. 2FA stays off until a code from it is confirmed.
+func (tf *twoFactorService) Enroll(userID string) (TwoFactorEnrollment, error) {
+	var enrollment TwoFactorEnrollment
+
+	user, err := tf.storage.GetUserByID(userID)
+	if err != nil {
+		return enrollment, err
+	}
+
+	if user.TwoFactorEnabled {
+		return enrollment, ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return enrollment, err
+	}
+
+	err = tf.storage.SetPendingTOTP(userID, secret)
+	if err != nil {
+		return enrollment, err
+	}
+
+	account := user.Email
+	if account == "" {
+		account = user.Username
+	}
+
+	enrollment.Secret = secret
+	enrollment.URI = totp.URI(totpIssuer, account, secret)
+	return enrollment, nil
+}
+
+// Turns on 2FA once the user proves their app produces valid codes. Returns
+// the recovery codes, which are only ever shown here.
+func (tf *twoFactorService) Confirm(userID, code string) ([]string, error) {
+	state, err := tf.storage.GetTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	if state.Secret == "" {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	if err := tf.checkTOTP(userID, state, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = tf.storage.EnableTOTP(userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	tf.logger.Info("enabled two factor", "user", userID)
+	return codes, nil
+}
+
+func (tf *twoFactorService) Disable(userID, code string) error {
+	if err := tf.RequireFreshCode(userID, code); err != nil {
+		return err
+	}
+
+	state, err := tf.storage.GetTOTP(userID)
+	if err != nil {
+		return err
+	}
+
+	if !state.Enabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	err = tf.storage.DisableTOTP(userID)
+	if err != nil {
+		return err
+	}
+
+	tf.logger.Info("disabled two factor", "user", userID)
+	return nil
+}
+
+func (tf *twoFactorService) RegenerateRecoveryCodes(userID, code string) ([]string, error) {
+	state, err := tf.storage.GetTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !state.Enabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	if err := tf.checkTOTP(userID, state, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tf.storage.ReplaceRecoveryCodes(userID, hashes)
+}
+
+// Second factor at login, accepts a TOTP code or an unused recovery code
+func (tf *twoFactorService) Verify(userID, code string) error {
+	state, err := tf.storage.GetTOTP(userID)
+	if err != nil {
+		return err
+	}
+
+	if !state.Enabled {
+		return nil
+	}
+
+	if tf.checkTOTP(userID, state, code) == nil {
+		return nil
+	}
+
+	used, err := tf.storage.UseRecoveryCode(userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+
+	if !used {
+		return ErrInvalidTwoFactorCode
+	}
+
+	tf.logger.Info("used recovery code", "user", userID)
+	return nil
+}
+
+// Sensitive actions need a current TOTP code when 2FA is on. Recovery codes
+// aren't accepted here.
+func (tf *twoFactorService) RequireFreshCode(userID, code string) error {
+	state, err := tf.storage.GetTOTP(userID)
+	if err != nil {
+		return err
+	}
+
+	if !state.Enabled {
+		return nil
+	}
+
+	if code == "" {
+		return ErrTwoFactorRequired
+	}
+
+	return tf.checkTOTP(userID, state, code)
+}
+
+// Validates the code and records its step so it can't be replayed
+func (tf *twoFactorService) checkTOTP(userID string, state TOTPState, code string) error {
+	step, ok := totp.Validate(state.Secret, code, time.Now())
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	fresh, err := tf.storage.UseTOTPStep(userID, step)
+	if err != nil {
+		return err
+	}
+
+	if !fresh {
+		return ErrInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+// Codes look like abcde-fghij
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for range recoveryCodeCount {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return HashToken(strings.ReplaceAll(code, "-", ""))
+}
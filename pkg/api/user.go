@@ -2,65 +2,99 @@ package api
 
 import (
 	"errors"
-	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	// Failed logins allowed before the account starts locking
+	lockoutThreshold = 5
+	lockoutBase      = 30 * time.Second
+	lockoutMax       = time.Hour
+
+	// Compared against when there's no real hash so unknown accounts take as
+	// long to reject as a wrong password
+	dummyHash = "$2a$10$Hf69pTF9ZxFaNDT1QB0NxO4WmYmMYDAP7.qEBHh5YJCDwMb7pVxai"
+)
+
 // insert new user, login a user, get user details (inventory, recents, stats, etc.),
 // update user (update balance, insert new items, delete items, etc.), buy items
 
 // Responsible for every user interaction, new, remove, updates
 type UserService interface {
 	New(user *NewUserRequest) (string, error)
-	Login(request *NewLoginRequest) (User, Inventory, error)
+	Login(request *NewLoginRequest, ipAddress string) (User, Inventory, error)
 	GetUser(userID string) (User, error)
 	GetInventory(userID string) (Inventory, error)
 	GetRecentTradeups(userID string) ([]RecentTradeup, error)
-	GetRecentWinnings(userID string) ([]Item, error)
-	GetStats(userID string) error
+	GetRecentWinnings(userID string, limit, offset int) ([]WonItem, error)
+	GetStats(userID string) (Stats, error)
 }
 
 type UserRepository interface {
 	CreateUser(*NewUserRequest) (string, error)
 	GetUserByID(userID string) (User, error)
 	GetUserAndHashByEmail(email string) (User, string, error)
+	GetLoginLock(email, ipAddress string) (time.Time, error)
+	RecordFailedLogin(email, ipAddress string) (int, error)
+	LockLogin(email, ipAddress string, until time.Time) error
+	ResetFailedLogins(email, ipAddress string) error
 	GetInventory(userID string) (Inventory, error)
 	GetRecentTradeups(userID string) ([]RecentTradeup, error)
-	GetRecentWinnings(userID string) ([]Item, error)
+	GetRecentWinnings(userID string, limit, offset int) ([]WonItem, error)
+	GetStats(userID string) (Stats, error)
 }
 
 type userService struct {
 	storage UserRepository
+	pricer Pricer
+	stats StatsCache
 	logger LogService
 }
 
 // Handles all user requests
-func NewUserService(userRepo UserRepository, logger LogService) UserService {
-	return &userService{storage: userRepo, logger: logger}
+func NewUserService(userRepo UserRepository, pricer Pricer, stats StatsCache, logger LogService) UserService {
+	return &userService{storage: userRepo, pricer: pricer, stats: stats, logger: logger}
 }
 
 // Creates a new user and returns their ID
 func (u *userService) New(user *NewUserRequest) (string, error) {
+	// Normalization
+	user.Email = NormalizeEmail(user.Email)
+	user.Username = NormalizeUsername(user.Username)
+
 	err := ValidateNewUserRequest(user)
 	if err != nil {
 		return "", err
 	}
 
+	if err := ValidateEmail(user.Email); err != nil {
+		return "", err
+	}
+
+	if err := ValidateUsername(user.Username); err != nil {
+		return "", err
+	}
+
+	if err := ValidatePassword(user.Password); err != nil {
+		return "", err
+	}
+
 	// Check if user already exists
 	_, _, err = u.storage.GetUserAndHashByEmail(user.Email)
 	if err == nil {
-		return "", errors.New("email already used")
+		return "", ErrEmailTaken
 	}
 
-	// Normalization
-	user.Email = strings.TrimSpace(user.Email)
-
 	return u.storage.CreateUser(user)
 }
 
-// Logs in an existing user, gets their data and inventory
-func (u *userService) Login(request *NewLoginRequest) (User, Inventory, error) {
+// Logs in an existing user, gets their data and inventory. Failures are
+// counted per email and IP, so someone guessing at an account can't lock its
+// owner out from everywhere else, and unknown emails lock the same way real
+// ones do.
+func (u *userService) Login(request *NewLoginRequest, ipAddress string) (User, Inventory, error) {
 	var user User
 	var inv Inventory
 	err := ValidateLoginRequest(request)
@@ -68,19 +102,38 @@ func (u *userService) Login(request *NewLoginRequest) (User, Inventory, error) {
 		return user, inv, err
 	}
 
-	request.Email = strings.TrimSpace(request.Email)
+	request.Email = NormalizeEmail(request.Email)
 
-	user, hash, err := u.storage.GetUserAndHashByEmail(request.Email)
+	lockedUntil, err := u.storage.GetLoginLock(request.Email, ipAddress)
 	if err != nil {
 		return user, inv, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(request.Password))
-	if err != nil {
+	if time.Now().Before(lockedUntil) {
+		return user, inv, &LockoutError{RetryAfter: time.Until(lockedUntil)}
+	}
+
+	user, hash, err := u.storage.GetUserAndHashByEmail(request.Email)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
 		return user, inv, err
 	}
 
-	inv, err = u.storage.GetInventory(user.ID)
+	found := err == nil && hash != ""
+	if !found {
+		hash = dummyHash
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(request.Password))
+	if err != nil || !found {
+		u.recordFailedLogin(request.Email, ipAddress)
+		return User{}, inv, ErrInvalidCredentials
+	}
+
+	if err := u.storage.ResetFailedLogins(request.Email, ipAddress); err != nil {
+		u.logger.Error("couldn't reset failed logins", "user", user.ID, "error", err)
+	}
+
+	inv, err = u.GetInventory(user.ID)
 	if err != nil {
 		return user, inv, err
 	}
@@ -88,6 +141,47 @@ func (u *userService) Login(request *NewLoginRequest) (User, Inventory, error) {
 	return user, inv, nil
 }
 
+// Locks the account once failures pass the threshold. Every failure past it
+// doubles the lock, up to lockoutMax.
+func (u *userService) recordFailedLogin(email, ipAddress string) {
+	failures, err := u.storage.RecordFailedLogin(email, ipAddress)
+	if err != nil {
+		u.logger.Error("couldn't record failed login", "ip", ipAddress, "error", err)
+		return
+	}
+
+	lock := LockoutDuration(failures)
+	if lock == 0 {
+		return
+	}
+
+	err = u.storage.LockLogin(email, ipAddress, time.Now().Add(lock))
+	if err != nil {
+		u.logger.Error("couldn't lock login", "ip", ipAddress, "error", err)
+		return
+	}
+
+	u.logger.Info("locked login after failed attempts", "ip", ipAddress, "failures", failures, "for", lock)
+}
+
+// How long an account is locked for after the given number of consecutive
+// failed logins
+func LockoutDuration(failures int) time.Duration {
+	if failures < lockoutThreshold {
+		return 0
+	}
+
+	lock := lockoutBase
+	for range failures - lockoutThreshold {
+		lock *= 2
+		if lock >= lockoutMax {
+			return lockoutMax
+		}
+	}
+
+	return lock
+}
+
 func (u *userService) GetUser(userID string) (User, error) {
 	user, err := u.storage.GetUserByID(userID)
 	if err != nil {
@@ -97,20 +191,51 @@ func (u *userService) GetUser(userID string) (User, error) {
 	return user, nil
 }
 
+// Items are valued at today's prices
 func (u *userService) GetInventory(userID string) (Inventory, error) {
-	return u.storage.GetInventory(userID)
+	inv, err := u.storage.GetInventory(userID)
+	if err != nil {
+		return inv, err
+	}
+
+	priceItems(u.pricer, inv.Items)
+	return inv, nil
 }
 
 func (u *userService) GetRecentTradeups(userID string) ([]RecentTradeup, error) {
 	return u.storage.GetRecentTradeups(userID)
 }
 
-func (u *userService) GetRecentWinnings(userID string) ([]Item, error) {
-	return u.storage.GetRecentWinnings(userID)
+// Items won through tradeups, newest first
+func (u *userService) GetRecentWinnings(userID string, limit, offset int) ([]WonItem, error) {
+	return u.storage.GetRecentWinnings(userID, limit, offset)
 }
 
-func (u *userService) GetStats(userID string) error {
-	return nil
+// Tradeup and crate stats for the user's profile, served from the cache when
+// nothing has changed since they were last computed
+func (u *userService) GetStats(userID string) (Stats, error) {
+	if stats, ok := u.stats.Get(userID); ok {
+		return stats, nil
+	}
+
+	stats, err := u.storage.GetStats(userID)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, r := range stats.ByRarity {
+		stats.TradeupsEntered += r.Entered
+		stats.TradeupsWon += r.Won
+		stats.ValueContributed = stats.ValueContributed.Add(r.Contributed)
+	}
+
+	if stats.TradeupsCompleted > 0 {
+		stats.WinRate = float64(stats.TradeupsWon) / float64(stats.TradeupsCompleted)
+	}
+	stats.NetProfit = stats.ValueWon.Sub(stats.ValueContributed)
+
+	u.stats.Set(userID, stats)
+	return stats, nil
 }
 
 /*
@@ -119,15 +244,15 @@ Validators
 
 func ValidateNewUserRequest(user *NewUserRequest) error {
 	if user.Email == "" {
-		return errors.New("email cannot be empty")
+		return errRequired("email")
 	}
 
 	if user.Username == "" {
-		return errors.New("username cannot be empty")
+		return errRequired("username")
 	}
 
 	if user.Password == "" {
-		return errors.New("password cannot be empty")
+		return errRequired("password")
 	}
 
 	return nil
@@ -135,11 +260,11 @@ func ValidateNewUserRequest(user *NewUserRequest) error {
 
 func ValidateLoginRequest(user *NewLoginRequest) error {
 	if user.Email == "" {
-		return errors.New("email cannot be empty")
+		return errRequired("email")
 	}
 
 	if user.Password == "" {
-		return errors.New("password cannot be empty")
+		return errRequired("password")
 	}
 	
 	return nil
@@ -0,0 +1,214 @@
+package api_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type fakeUserRepo struct {
+	api.UserRepository
+	user       api.User
+	hash       string
+	stats      api.Stats
+	statsCalls int
+	items      []api.Item
+	failures   map[string]int
+	locks      map[string]time.Time
+}
+
+func (f *fakeUserRepo) GetStats(userID string) (api.Stats, error) {
+	f.statsCalls++
+	return f.stats, nil
+}
+
+func (f *fakeUserRepo) GetUserAndHashByEmail(email string) (api.User, string, error) {
+	if email != f.user.Email {
+		return api.User{}, "", api.ErrUserNotFound
+	}
+	return f.user, f.hash, nil
+}
+
+func (f *fakeUserRepo) GetLoginLock(email, ipAddress string) (time.Time, error) {
+	return f.locks[email+"|"+ipAddress], nil
+}
+
+func (f *fakeUserRepo) RecordFailedLogin(email, ipAddress string) (int, error) {
+	if f.failures == nil {
+		f.failures = make(map[string]int)
+	}
+	f.failures[email+"|"+ipAddress]++
+	return f.failures[email+"|"+ipAddress], nil
+}
+
+func (f *fakeUserRepo) LockLogin(email, ipAddress string, until time.Time) error {
+	if f.locks == nil {
+		f.locks = make(map[string]time.Time)
+	}
+	f.locks[email+"|"+ipAddress] = until
+	return nil
+}
+
+func (f *fakeUserRepo) ResetFailedLogins(email, ipAddress string) error {
+	delete(f.failures, email+"|"+ipAddress)
+	delete(f.locks, email+"|"+ipAddress)
+	return nil
+}
+
+func (f *fakeUserRepo) GetInventory(userID string) (api.Inventory, error) {
+	return api.Inventory{UserID: userID, Items: f.items}, nil
+}
+
+func TestLoginLockout(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct"), bcrypt.MinCost)
+	repo := &fakeUserRepo{
+		user: api.User{ID: "user-1", Email: "test@test.com"},
+		hash: string(hash),
+	}
+	users := api.NewUserService(repo, fakePricer{}, api.NewStatsCache(time.Minute), api.NewLogger())
+
+	login := func(email, password, ip string) error {
+		_, _, err := users.Login(&api.NewLoginRequest{Email: email, Password: password}, ip)
+		return err
+	}
+
+	for range 4 {
+		login("test@test.com", "wrong", "1.1.1.1")
+	}
+
+	if err := login("test@test.com", "correct", "1.1.1.1"); err != nil {
+		t.Fatalf("expected login below threshold to succeed, got %v", err)
+	}
+
+	if n := repo.failures["test@test.com|1.1.1.1"]; n != 0 {
+		t.Fatalf("expected failures reset after login, got %d", n)
+	}
+
+	for range 5 {
+		login("test@test.com", "wrong", "1.1.1.1")
+	}
+
+	var lockout *api.LockoutError
+	if err := login("test@test.com", "correct", "1.1.1.1"); !errors.As(err, &lockout) {
+		t.Fatalf("expected lockout, got %v", err)
+	}
+
+	if lockout.RetryAfter <= 0 || lockout.RetryAfter > 30*time.Second {
+		t.Errorf("unexpected retry after %v", lockout.RetryAfter)
+	}
+
+	t.Run("other IPs can still log in", func(t *testing.T) {
+		if err := login("test@test.com", "correct", "2.2.2.2"); err != nil {
+			t.Fatalf("expected login from another IP to succeed, got %v", err)
+		}
+	})
+
+	t.Run("unknown emails lock the same way", func(t *testing.T) {
+		for range 5 {
+			if err := login("nobody@test.com", "wrong", "1.1.1.1"); !errors.Is(err, api.ErrInvalidCredentials) {
+				t.Fatalf("expected invalid credentials, got %v", err)
+			}
+		}
+
+		if err := login("nobody@test.com", "wrong", "1.1.1.1"); !errors.As(err, &lockout) {
+			t.Fatalf("expected lockout, got %v", err)
+		}
+	})
+}
+
+func TestLockoutDuration(t *testing.T) {
+	cases := map[int]time.Duration{
+		0:   0,
+		4:   0,
+		5:   30 * time.Second,
+		6:   time.Minute,
+		8:   4 * time.Minute,
+		100: time.Hour,
+	}
+
+	for failures, expected := range cases {
+		if got := api.LockoutDuration(failures); got != expected {
+			t.Errorf("%d failures: expected %v, got %v", failures, expected, got)
+		}
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	repo := &fakeUserRepo{stats: api.Stats{
+		TradeupsCompleted: 4,
+		ValueWon:          api.Cents(5000),
+		ByRarity: []api.RarityStats{
+			{Rarity: "Consumer", Entered: 3, Won: 1, Contributed: api.Cents(2000)},
+			{Rarity: "Mil-Spec", Entered: 2, Won: 1, Contributed: api.Cents(4000)},
+		},
+	}}
+	cache := api.NewStatsCache(time.Minute)
+	users := api.NewUserService(repo, fakePricer{}, cache, api.NewLogger())
+
+	stats, err := users.GetStats("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.TradeupsEntered != 5 || stats.TradeupsWon != 2 || stats.ValueContributed != api.Cents(6000) {
+		t.Errorf("unexpected totals %+v", stats)
+	}
+
+	if stats.WinRate != 0.5 {
+		t.Errorf("expected win rate 0.5, got %v", stats.WinRate)
+	}
+
+	if stats.NetProfit != api.Cents(-1000) {
+		t.Errorf("expected net profit -10.00, got %v", stats.NetProfit)
+	}
+
+	t.Run("serves from cache until invalidated", func(t *testing.T) {
+		users.GetStats("user-1")
+		if repo.statsCalls != 1 {
+			t.Fatalf("expected cached stats, repo called %d times", repo.statsCalls)
+		}
+
+		cache.Invalidate("user-1")
+		users.GetStats("user-1")
+		if repo.statsCalls != 2 {
+			t.Fatalf("expected stats recomputed, repo called %d times", repo.statsCalls)
+		}
+	})
+}
+
+func TestValidateUsername(t *testing.T) {
+	cases := map[string]error{
+		"player_one":            nil,
+		"a.b-c":                 nil,
+		"ab":                    api.ErrInvalidUsername,
+		"has space":             api.ErrInvalidUsername,
+		"émile":                 api.ErrInvalidUsername,
+		"way_too_long_username": api.ErrInvalidUsername,
+		"ADMIN":                 api.ErrUsernameNotAllowed,
+		"f.u.c.k_you":           api.ErrUsernameNotAllowed,
+		"BigShitLord":           api.ErrUsernameNotAllowed,
+		"shit99":                api.ErrUsernameNotAllowed,
+		"Scunthorpe":            nil,
+		"grapefruit":            nil,
+		"the_therapist":         nil,
+	}
+
+	for username, want := range cases {
+		if got := api.ValidateUsername(username); got != want {
+			t.Errorf("ValidateUsername(%q) = %v, want %v", username, got, want)
+		}
+	}
+}
+
+func TestNewNormalizesEmail(t *testing.T) {
+	repo := &fakeUserRepo{user: api.User{ID: "user-1", Email: "test@test.com"}}
+	users := api.NewUserService(repo, fakePricer{}, api.NewStatsCache(time.Minute), api.NewLogger())
+
+	request := &api.NewUserRequest{Username: "other", Email: " Test@Test.com ", Password: "password1"}
+	if _, err := users.New(request); err != api.ErrEmailTaken {
+		t.Errorf("expected email to be taken ignoring case, got %v", err)
+	}
+}
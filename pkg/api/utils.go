@@ -1,7 +1,5 @@
 package api
 
-import "math/rand/v2"
-
 // Map numerical float values to their corresponding wear name
 // Ex: [0, 0.07) => Factory New
 func GetWearFromFloatValue(fv float64) string {
@@ -18,9 +16,9 @@ func GetWearFromFloatValue(fv float64) string {
 	}
 }
 
-// 20% chance to be StatTrak
-func IsStatTrak() bool {
-	return rand.IntN(10) <= 1
+// 20% chance to be StatTrak, roll is uniform in [0, 1)
+func IsStatTrak(roll float64) bool {
+	return roll < 0.2
 }
 
 func GetNextRarity(prevRarity string) string {
@@ -42,6 +40,20 @@ func GetNextRarity(prevRarity string) string {
     }
 }
 
+// Lowest to highest
+var rarities = []string{"Consumer", "Industrial", "Mil-Spec", "Restricted", "Classified", "Covert",
+	RarityRareSpecial, "Contraband"}
+
+// Position of the rarity in the ladder, unknown rarities sort last
+func RarityRank(rarity string) int {
+	for i, r := range rarities {
+		if r == rarity {
+			return i
+		}
+	}
+	return len(rarities)
+}
+
 func GetWearNameFromFloat(wear float64) string {
     if wear < 0.07 {
         return "Factory New"
@@ -0,0 +1,59 @@
+// Package blob implements api.BlobStore on the local filesystem and on
+// S3-compatible object storage.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Writes blobs under dir, for local development
+type FSStore struct {
+	dir string
+}
+
+func NewFSStore(dir string) (*FSStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FSStore{dir: dir}, nil
+}
+
+func (f *FSStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (f *FSStore) Delete(ctx context.Context, key string) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Keys are slash separated and must stay inside dir
+func (f *FSStore) path(key string) (string, error) {
+	path := filepath.Join(f.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(f.dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("blob: invalid key %q", key)
+	}
+	return path, nil
+}
@@ -0,0 +1,92 @@
+package fairness
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+// Each HMAC gives 32 bytes, four bytes per roll
+const rollsPerRound = sha256.Size / 4
+
+// Random 256-bit server seed, hex encoded
+func NewServerSeed() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// The commitment published before a server seed is used
+func HashSeed(serverSeed string) string {
+	sum := sha256.Sum256([]byte(serverSeed))
+	return hex.EncodeToString(sum[:])
+}
+
+// Whether a revealed server seed matches the hash that was committed to
+func CheckSeed(serverSeed, hash string) bool {
+	return hmac.Equal([]byte(HashSeed(serverSeed)), []byte(hash))
+}
+
+// Hands out the rolls for one nonce in order. The nth roll is built from
+// HMAC-SHA256(serverSeed, "clientSeed:nonce:round") where round is n / 8,
+// taking bytes 4*(n%8) to 4*(n%8)+4 as a base 256 fraction.
+type Roller struct {
+	serverSeed string
+	clientSeed string
+	nonce      uint64
+	cursor     int
+	block      []byte
+}
+
+func NewRoller(serverSeed, clientSeed string, nonce uint64) *Roller {
+	return &Roller{serverSeed: serverSeed, clientSeed: clientSeed, nonce: nonce}
+}
+
+// Next roll, uniform in [0, 1)
+func (r *Roller) Float64() float64 {
+	offset := r.cursor % rollsPerRound
+	if offset == 0 {
+		r.block = block(r.serverSeed, r.clientSeed, r.nonce, r.cursor/rollsPerRound)
+	}
+	r.cursor++
+
+	f := 0.0
+	for i, b := range r.block[offset*4 : offset*4+4] {
+		f += float64(b) / float64(uint64(1)<<(8*(i+1)))
+	}
+	return f
+}
+
+// Next roll as an int in [0, n). Same shape as rand.IntN so it can stand in
+// for it.
+func (r *Roller) IntN(n int) int {
+	if n <= 0 {
+		panic("fairness: invalid argument to IntN")
+	}
+	return int(r.Float64() * float64(n))
+}
+
+// How many rolls have been taken
+func (r *Roller) Cursor() int {
+	return r.cursor
+}
+
+// The first count rolls for a nonce, for showing the working when verifying
+func Rolls(serverSeed, clientSeed string, nonce uint64, count int) []float64 {
+	roller := NewRoller(serverSeed, clientSeed, nonce)
+	rolls := make([]float64, count)
+	for i := range rolls {
+		rolls[i] = roller.Float64()
+	}
+	return rolls
+}
+
+func block(serverSeed, clientSeed string, nonce uint64, round int) []byte {
+	mac := hmac.New(sha256.New, []byte(serverSeed))
+	mac.Write([]byte(clientSeed + ":" + strconv.FormatUint(nonce, 10) + ":" + strconv.Itoa(round)))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,91 @@
+package fairness_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"strconv"
+	"testing"
+
+	"github.com/erobx/csupgrade-go-api/pkg/fairness"
+)
+
+func TestSeedCommitment(t *testing.T) {
+	seed, err := fairness.NewServerSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(seed) != 64 {
+		t.Errorf("expected 64 hex characters, got %d", len(seed))
+	}
+
+	hash := fairness.HashSeed(seed)
+	if !fairness.CheckSeed(seed, hash) {
+		t.Error("expected the seed to match its own hash")
+	}
+
+	other, _ := fairness.NewServerSeed()
+	if fairness.CheckSeed(other, hash) {
+		t.Error("expected a different seed not to match")
+	}
+}
+
+// Works the rolls out by hand from the documented scheme, so a change to how
+// rolls are made can't slip past as long as it's self consistent
+func TestRollerMatchesScheme(t *testing.T) {
+	roller := fairness.NewRoller("server", "client", 7)
+
+	for n := range 20 {
+		mac := hmac.New(sha256.New, []byte("server"))
+		mac.Write([]byte("client:7:" + strconv.Itoa(n/8)))
+		sum := mac.Sum(nil)
+
+		offset := (n % 8) * 4
+		expected := float64(binary.BigEndian.Uint32(sum[offset:offset+4])) / (1 << 32)
+
+		if got := roller.Float64(); got != expected {
+			t.Fatalf("roll %d: expected %v, got %v", n, expected, got)
+		}
+	}
+
+	if roller.Cursor() != 20 {
+		t.Errorf("expected cursor 20, got %d", roller.Cursor())
+	}
+}
+
+func TestRollerIsDeterministic(t *testing.T) {
+	rolls := fairness.Rolls("server", "client", 1, 50)
+	again := fairness.Rolls("server", "client", 1, 50)
+	next := fairness.Rolls("server", "client", 2, 50)
+
+	for i := range rolls {
+		if rolls[i] != again[i] {
+			t.Fatalf("roll %d differs between runs", i)
+		}
+		if rolls[i] < 0 || rolls[i] >= 1 {
+			t.Errorf("roll %d out of range: %v", i, rolls[i])
+		}
+	}
+
+	if rolls[0] == next[0] && rolls[1] == next[1] {
+		t.Error("expected a different nonce to give different rolls")
+	}
+}
+
+func TestRollerIntN(t *testing.T) {
+	roller := fairness.NewRoller("server", "client", 0)
+
+	seen := make(map[int]bool)
+	for range 1000 {
+		n := roller.IntN(6)
+		if n < 0 || n >= 6 {
+			t.Fatalf("out of range: %d", n)
+		}
+		seen[n] = true
+	}
+
+	if len(seen) != 6 {
+		t.Errorf("expected every face in 1000 rolls, got %v", seen)
+	}
+}
@@ -0,0 +1,67 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+)
+
+// Keeps sent messages in memory, for tests and local development
+type MemoryMailer struct {
+	sync.Mutex
+	messages []api.Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(msg api.Message) error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Every message sent so far, oldest first
+func (m *MemoryMailer) Messages() []api.Message {
+	m.Lock()
+	defer m.Unlock()
+
+	return append([]api.Message(nil), m.messages...)
+}
+
+// Writes each message to an .eml file in dir so links can be opened by hand
+// during development
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(msg api.Message) error {
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitize(msg.To))
+	return os.WriteFile(filepath.Join(m.dir, name), format(m.from, msg), 0o644)
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,226 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownWear       = errors.New("unknown wear")
+	ErrInvalidPrice      = errors.New("price must be a positive number of cents")
+	ErrInvalidMultiplier = errors.New("stattrak multiplier can't be negative")
+	ErrEmptyList         = errors.New("price list is empty")
+)
+
+// A wear tier covers floats in [Min, Max)
+type Wear struct {
+	Name  string
+	Short string
+	Min   float64
+	Max   float64
+}
+
+// Best to worst
+var Wears = []Wear{
+	{Name: "Factory New", Short: "FN", Min: 0, Max: 0.07},
+	{Name: "Minimal Wear", Short: "MW", Min: 0.07, Max: 0.15},
+	{Name: "Field-Tested", Short: "FT", Min: 0.15, Max: 0.38},
+	{Name: "Well-Worn", Short: "WW", Min: 0.38, Max: 0.45},
+	{Name: "Battle-Scarred", Short: "BS", Min: 0.45, Max: 1},
+}
+
+// Index into Wears of the tier a float falls in
+func WearOf(float float64) int {
+	for i, w := range Wears {
+		if float < w.Max {
+			return i
+		}
+	}
+	return len(Wears) - 1
+}
+
+// Index into Wears for a wear name. Takes the full name or the short form in
+// any case, with or without the hyphen, since price files are hand written.
+func ParseWear(name string) (int, error) {
+	norm := func(s string) string {
+		return strings.ToLower(strings.NewReplacer("-", "", " ", "", "_", "").Replace(s))
+	}
+
+	n := norm(name)
+	for i, w := range Wears {
+		if n == norm(w.Name) || n == norm(w.Short) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownWear, name)
+}
+
+// The base price of a skin in one wear tier, in cents. StatTrakMultiplier
+// overrides the engine's for this skin and wear when set.
+type Entry struct {
+	SkinID             int     `json:"skinId"`
+	Wear               string  `json:"wear"`
+	Price              int64   `json:"price"`
+	StatTrakMultiplier float64 `json:"statTrakMultiplier,omitempty"`
+}
+
+type PriceList []Entry
+
+type Config struct {
+	// What a StatTrak copy is worth relative to the normal one
+	StatTrakMultiplier float64
+	// How much the best float in a tier is worth over the middle of it, the
+	// worst float is worth as much less
+	FloatPremium float64
+}
+
+func DefaultConfig() Config {
+	return Config{StatTrakMultiplier: 2, FloatPremium: 0.05}
+}
+
+type key struct {
+	skinID int
+	wear   int
+}
+
+// Prices skins from the last price list loaded. Safe to use while a new list
+// is being loaded.
+type Engine struct {
+	config Config
+
+	mu        sync.RWMutex
+	prices    map[key]Entry
+	updatedAt time.Time
+}
+
+func NewEngine(config Config) *Engine {
+	return &Engine{config: config, prices: make(map[key]Entry)}
+}
+
+// Replaces every price with the ones in list. A bad entry rejects the whole
+// list so a broken file can't leave half the skins unpriced, and so does an
+// empty one so it can't leave all of them.
+func (e *Engine) Load(list PriceList) error {
+	if len(list) == 0 {
+		return ErrEmptyList
+	}
+
+	prices := make(map[key]Entry, len(list))
+	for i, entry := range list {
+		wear, err := ParseWear(entry.Wear)
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		if entry.Price <= 0 {
+			return fmt.Errorf("entry %d: %w", i+1, ErrInvalidPrice)
+		}
+		if entry.StatTrakMultiplier < 0 {
+			return fmt.Errorf("entry %d: %w", i+1, ErrInvalidMultiplier)
+		}
+		entry.Wear = Wears[wear].Name
+		prices[key{entry.SkinID, wear}] = entry
+	}
+
+	e.mu.Lock()
+	e.prices = prices
+	e.updatedAt = time.Now()
+	e.mu.Unlock()
+
+	return nil
+}
+
+// The price of a skin in the middle of a wear tier, in cents
+type Quote struct {
+	SkinID   int
+	Wear     string
+	StatTrak bool
+	Price    int64
+}
+
+// A normal and a StatTrak quote for every loaded entry, ordered by skin then
+// wear
+func (e *Engine) Quotes() []Quote {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	keys := make([]key, 0, len(e.prices))
+	for k := range e.prices {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].skinID != keys[j].skinID {
+			return keys[i].skinID < keys[j].skinID
+		}
+		return keys[i].wear < keys[j].wear
+	})
+
+	quotes := make([]Quote, 0, 2*len(keys))
+	for _, k := range keys {
+		entry := e.prices[k]
+		stPrice := max(int64(math.Round(float64(entry.Price)*e.statTrakMultiplier(entry))), 1)
+
+		quotes = append(quotes,
+			Quote{SkinID: k.skinID, Wear: Wears[k.wear].Name, Price: entry.Price},
+			Quote{SkinID: k.skinID, Wear: Wears[k.wear].Name, StatTrak: true, Price: stPrice})
+	}
+	return quotes
+}
+
+func (e *Engine) statTrakMultiplier(entry Entry) float64 {
+	if entry.StatTrakMultiplier != 0 {
+		return entry.StatTrakMultiplier
+	}
+	return e.config.StatTrakMultiplier
+}
+
+// When the current list was loaded, zero if nothing has been
+func (e *Engine) UpdatedAt() time.Time {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.updatedAt
+}
+
+// What a skin with the given float is worth, in cents. A wear the list doesn't
+// price falls back to the closest one that is, worse wear first, without the
+// float adjustment. False if the skin isn't priced at all.
+func (e *Engine) Price(skinID int, float float64, statTrak bool) (int64, bool) {
+	wear := WearOf(float)
+
+	e.mu.RLock()
+	entry, ok := e.prices[key{skinID, wear}]
+	fallback := false
+	for d := 1; !ok && d < len(Wears); d++ {
+		for _, w := range []int{wear + d, wear - d} {
+			if w >= 0 && w < len(Wears) {
+				if entry, ok = e.prices[key{skinID, w}]; ok {
+					break
+				}
+			}
+		}
+		fallback = true
+	}
+	e.mu.RUnlock()
+
+	if !ok {
+		return 0, false
+	}
+
+	multiplier := 1.0
+	if !fallback {
+		// 0 at the best float in the tier, 1 at the worst
+		tier := Wears[wear]
+		pos := math.Min(math.Max((float-tier.Min)/(tier.Max-tier.Min), 0), 1)
+		multiplier += e.config.FloatPremium * (1 - 2*pos)
+	}
+
+	if statTrak {
+		multiplier *= e.statTrakMultiplier(entry)
+	}
+
+	return max(int64(math.Round(float64(entry.Price)*multiplier)), 1), true
+}
@@ -0,0 +1,176 @@
+package pricing_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/erobx/csupgrade-go-api/pkg/pricing"
+)
+
+func testEngine(t *testing.T) *pricing.Engine {
+	t.Helper()
+
+	engine := pricing.NewEngine(pricing.Config{StatTrakMultiplier: 2, FloatPremium: 0.1})
+	err := engine.Load(pricing.PriceList{
+		{SkinID: 1, Wear: "Factory New", Price: 1000},
+		{SkinID: 1, Wear: "FT", Price: 400, StatTrakMultiplier: 3},
+		{SkinID: 1, Wear: "battle scarred", Price: 100},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+func TestPrice(t *testing.T) {
+	engine := testEngine(t)
+
+	tests := []struct {
+		name     string
+		skinID   int
+		float    float64
+		statTrak bool
+		want     int64
+		ok       bool
+	}{
+		{"best float in tier", 1, 0, false, 1100, true},
+		{"middle of tier", 1, 0.035, false, 1000, true},
+		{"worst float in tier", 1, 0.0699999, false, 900, true},
+		{"stattrak", 1, 0.035, true, 2000, true},
+		{"stattrak override", 1, 0.265, true, 1200, true},
+		{"falls back to worse wear", 1, 0.40, false, 100, true},
+		{"falls back to better wear", 1, 0.10, false, 400, true},
+		{"unpriced skin", 2, 0.1, false, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := engine.Price(tt.skinID, tt.float, tt.statTrak)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("expected %d %v, got %d %v", tt.want, tt.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsBadLists(t *testing.T) {
+	engine := testEngine(t)
+
+	lists := map[string]pricing.PriceList{
+		"unknown wear":   {{SkinID: 2, Wear: "Pristine", Price: 100}},
+		"zero price":     {{SkinID: 2, Wear: "FN", Price: 0}},
+		"negative price": {{SkinID: 2, Wear: "FN", Price: -5}},
+		"negative stattrak multiplier": {{SkinID: 2, Wear: "FN", Price: 100,
+			StatTrakMultiplier: -1}},
+		"empty list": {},
+	}
+
+	for name, list := range lists {
+		if err := engine.Load(list); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// the old list is kept
+	if _, ok := engine.Price(1, 0.01, false); !ok {
+		t.Error("expected the previous prices to survive a bad load")
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	data := "skin_id,wear,price,stattrak_multiplier\n" +
+		"1,Factory New,1000,\n" +
+		"1, MW , 750, 2.5\n"
+
+	list, err := pricing.Parse(strings.NewReader(data), pricing.FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := pricing.PriceList{
+		{SkinID: 1, Wear: "Factory New", Price: 1000},
+		{SkinID: 1, Wear: "MW", Price: 750, StatTrakMultiplier: 2.5},
+	}
+	if len(list) != len(want) {
+		t.Fatalf("expected %d entries, got %+v", len(want), list)
+	}
+	for i := range want {
+		if list[i] != want[i] {
+			t.Errorf("entry %d: expected %+v, got %+v", i, want[i], list[i])
+		}
+	}
+
+	if _, err := pricing.Parse(strings.NewReader("skin_id,price\n1,100\n"), pricing.FormatCSV); err == nil {
+		t.Error("expected an error without a wear column")
+	}
+	if _, err := pricing.Parse(strings.NewReader("skin_id,wear,price\n1,FN,1.50\n"), pricing.FormatCSV); err == nil {
+		t.Error("expected an error for a price that isn't cents")
+	}
+}
+
+func TestParseUnknownFormat(t *testing.T) {
+	_, err := pricing.Parse(strings.NewReader(""), "xml")
+	if !errors.Is(err, pricing.ErrUnknownFormat) {
+		t.Errorf("expected unknown format, got %v", err)
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/prices":
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Write([]byte("skin_id,wear,price\n7,FN,2500\n"))
+		case "/prices.json":
+			w.Write([]byte(`[{"skinId": 7, "wear": "FN", "price": 2500}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	for _, p := range []string{"/prices", "/prices.json"} {
+		list, err := pricing.NewHTTPSource(srv.URL + p).Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", p, err)
+		}
+		if len(list) != 1 || list[0].SkinID != 7 || list[0].Price != 2500 {
+			t.Errorf("%s: unexpected list %+v", p, list)
+		}
+	}
+
+	if _, err := pricing.NewHTTPSource(srv.URL + "/missing").Fetch(context.Background()); err == nil {
+		t.Error("expected an error for a 404")
+	}
+
+	small := pricing.NewHTTPSource(srv.URL + "/prices")
+	small.MaxSize = 10
+	if _, err := small.Fetch(context.Background()); !errors.Is(err, pricing.ErrListTooLarge) {
+		t.Errorf("expected a list over the limit to be rejected, got %v", err)
+	}
+}
+
+func TestQuotes(t *testing.T) {
+	quotes := testEngine(t).Quotes()
+
+	want := []pricing.Quote{
+		{SkinID: 1, Wear: "Factory New", Price: 1000},
+		{SkinID: 1, Wear: "Factory New", StatTrak: true, Price: 2000},
+		{SkinID: 1, Wear: "Field-Tested", Price: 400},
+		{SkinID: 1, Wear: "Field-Tested", StatTrak: true, Price: 1200},
+		{SkinID: 1, Wear: "Battle-Scarred", Price: 100},
+		{SkinID: 1, Wear: "Battle-Scarred", StatTrak: true, Price: 200},
+	}
+	if len(quotes) != len(want) {
+		t.Fatalf("expected %d quotes, got %+v", len(want), quotes)
+	}
+	for i := range want {
+		if quotes[i] != want[i] {
+			t.Errorf("quote %d: expected %+v, got %+v", i, want[i], quotes[i])
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package pricing
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// The most of a response HTTPSource reads by default. Well past any real
+// price list.
+const DefaultMaxListSize = 32 << 20
+
+var (
+	ErrUnknownFormat = errors.New("unknown price list format")
+	ErrListTooLarge  = errors.New("price list is too large")
+)
+
+// Where price lists come from. Anything that can hand back a full list works,
+// a file, a market API or a stub in tests.
+type PriceSource interface {
+	Fetch(ctx context.Context) (PriceList, error)
+}
+
+// Reads a price list in the given format.
+//
+// CSV has a header row of skin_id, wear and price, with an optional
+// stattrak_multiplier column. JSON is an array of entries. Prices are in cents
+// either way.
+func Parse(r io.Reader, format string) (PriceList, error) {
+	switch format {
+	case FormatCSV:
+		return parseCSV(r)
+	case FormatJSON:
+		var list PriceList
+		if err := json.NewDecoder(r).Decode(&list); err != nil {
+			return nil, err
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+}
+
+// The format for a file name or content type, empty if it's neither
+func FormatOf(nameOrType string) string {
+	if mediaType, _, err := mime.ParseMediaType(nameOrType); err == nil {
+		switch mediaType {
+		case "text/csv":
+			return FormatCSV
+		case "application/json":
+			return FormatJSON
+		}
+	}
+
+	switch strings.ToLower(path.Ext(nameOrType)) {
+	case ".csv":
+		return FormatCSV
+	case ".json":
+		return FormatJSON
+	}
+	return ""
+}
+
+func parseCSV(r io.Reader) (PriceList, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	cols := make(map[string]int)
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"skin_id", "wear", "price"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("missing %s column", name)
+		}
+	}
+	stCol, hasST := cols["stattrak_multiplier"]
+
+	var list PriceList
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return list, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		field := func(i int) string {
+			if i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		var entry Entry
+		if entry.SkinID, err = strconv.Atoi(field(cols["skin_id"])); err != nil {
+			return nil, fmt.Errorf("line %d: bad skin_id: %w", line, err)
+		}
+		entry.Wear = field(cols["wear"])
+		if entry.Price, err = strconv.ParseInt(field(cols["price"]), 10, 64); err != nil {
+			return nil, fmt.Errorf("line %d: bad price: %w", line, err)
+		}
+		if hasST && field(stCol) != "" {
+			entry.StatTrakMultiplier, err = strconv.ParseFloat(field(stCol), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad stattrak_multiplier: %w", line, err)
+			}
+		}
+
+		list = append(list, entry)
+	}
+}
+
+// A price list on disk, the format comes from the extension
+type FileSource struct {
+	Path string
+}
+
+func (f FileSource) Fetch(ctx context.Context) (PriceList, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file, FormatOf(f.Path))
+}
+
+// A price list served over HTTP. The format comes from the content type, then
+// the url, and is assumed to be JSON otherwise. Responses over MaxSize bytes
+// are rejected.
+type HTTPSource struct {
+	URL     string
+	Client  *http.Client
+	MaxSize int64
+}
+
+func NewHTTPSource(url string) *HTTPSource {
+	return &HTTPSource{URL: url, Client: &http.Client{Timeout: 30 * time.Second},
+		MaxSize: DefaultMaxListSize}
+}
+
+func (h *HTTPSource) Fetch(ctx context.Context) (PriceList, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("price source returned %s", resp.Status)
+	}
+
+	format := FormatOf(resp.Header.Get("Content-Type"))
+	if format == "" {
+		format = FormatOf(req.URL.Path)
+	}
+	if format == "" {
+		format = FormatJSON
+	}
+
+	// one byte over the limit tells a list that's too big from one that's
+	// exactly the limit, cutting it short could still parse
+	body, err := io.ReadAll(io.LimitReader(resp.Body, h.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > h.MaxSize {
+		return nil, fmt.Errorf("%w: over %d bytes", ErrListTooLarge, h.MaxSize)
+	}
+
+	return Parse(bytes.NewReader(body), format)
+}
@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How often idle buckets are dropped
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// how long the bucket takes to refill from empty
+	per time.Duration
+}
+
+// Keeps buckets in process memory. Only suitable for a single instance and
+// tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+	done    chan struct{}
+}
+
+// Starts sweeping idle buckets in the background until Close is called
+func NewMemoryStore() *MemoryStore {
+	m := &MemoryStore{buckets: make(map[string]*bucket), now: time.Now, done: make(chan struct{})}
+	go m.sweepEvery(sweepInterval)
+	return m
+}
+
+// Stops the background sweep
+func (m *MemoryStore) Close() {
+	close(m.done)
+}
+
+func (m *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	if !limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Requests), last: now}
+		m.buckets[key] = b
+	}
+
+	tokens, result := take(b.tokens, b.last, now, limit)
+	b.tokens = tokens
+	b.last = now
+	b.per = limit.Per
+
+	return result, nil
+}
+
+func (m *MemoryStore) sweepEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.sweep()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// Drops buckets that have been idle long enough to be full again, a fresh one
+// behaves the same, so the map doesn't grow forever
+func (m *MemoryStore) sweep() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for key, b := range m.buckets {
+		if now.Sub(b.last) > b.per {
+			delete(m.buckets, key)
+		}
+	}
+}
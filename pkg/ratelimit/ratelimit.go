@@ -0,0 +1,84 @@
+// Package ratelimit implements token bucket rate limiting with pluggable
+// storage for the bucket state.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Allows Requests per Per, refilling continuously. Requests is also the burst
+// size. The zero Limit is unlimited.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+// Tokens added back per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+func (l Limit) String() string {
+	return strconv.Itoa(l.Requests) + "/" + l.Per.String()
+}
+
+// Parses limits written as requests/duration, e.g. 10/1m or 100/1h
+func ParseLimit(s string) (Limit, error) {
+	requests, per, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("ratelimit: invalid limit %q", s)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 0 {
+		return Limit{}, fmt.Errorf("ratelimit: invalid request count in %q", s)
+	}
+
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("ratelimit: invalid duration in %q", s)
+	}
+
+	return Limit{Requests: n, Per: d}, nil
+}
+
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// How long until a token is available, zero when allowed
+	RetryAfter time.Duration
+}
+
+// Holds bucket state. Take removes a token from the bucket at key if one is
+// available.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// Refills a bucket holding tokens that was last updated at last, then tries
+// to take one. Shared by every store so they agree on the maths.
+func take(tokens float64, last, now time.Time, limit Limit) (float64, Result) {
+	capacity := float64(limit.Requests)
+
+	elapsed := now.Sub(last).Seconds()
+	if elapsed > 0 {
+		tokens = math.Min(capacity, tokens+elapsed*limit.rate())
+	}
+
+	if tokens >= 1 {
+		tokens--
+		return tokens, Result{Allowed: true, Remaining: int(tokens)}
+	}
+
+	wait := (1 - tokens) / limit.rate()
+	return tokens, Result{RetryAfter: time.Duration(math.Ceil(wait * float64(time.Second)))}
+}
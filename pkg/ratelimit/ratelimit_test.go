@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("10/1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limit.Requests != 10 || limit.Per != time.Minute {
+		t.Errorf("unexpected limit %v", limit)
+	}
+
+	for _, invalid := range []string{"", "10", "x/1m", "10/x", "10/0s", "-1/1m"} {
+		if _, err := ParseLimit(invalid); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Unix(0, 0)
+	store := NewMemoryStore()
+	defer store.Close()
+	store.now = func() time.Time { return now }
+
+	limit := Limit{Requests: 3, Per: time.Minute}
+	ctx := context.Background()
+
+	for i := range 3 {
+		result, _ := store.Take(ctx, "ip:1", limit)
+		if !result.Allowed {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+
+	result, _ := store.Take(ctx, "ip:1", limit)
+	if result.Allowed {
+		t.Fatal("expected bucket to be empty")
+	}
+
+	if result.RetryAfter != 20*time.Second {
+		t.Errorf("expected retry after 20s, got %v", result.RetryAfter)
+	}
+
+	t.Run("buckets are independent", func(t *testing.T) {
+		result, _ := store.Take(ctx, "ip:2", limit)
+		if !result.Allowed {
+			t.Fatal("expected other key to be allowed")
+		}
+	})
+
+	t.Run("refills over time", func(t *testing.T) {
+		now = now.Add(20 * time.Second)
+
+		result, _ := store.Take(ctx, "ip:1", limit)
+		if !result.Allowed {
+			t.Fatal("expected a token after refill")
+		}
+
+		result, _ = store.Take(ctx, "ip:1", limit)
+		if result.Allowed {
+			t.Fatal("expected only one token to refill")
+		}
+	})
+
+	t.Run("sweep drops full buckets", func(t *testing.T) {
+		store.Take(ctx, "ip:4", Limit{Requests: 3, Per: time.Hour})
+		now = now.Add(2 * time.Minute)
+		store.sweep()
+
+		if _, ok := store.buckets["ip:1"]; ok {
+			t.Error("expected the idle bucket to be dropped")
+		}
+		if _, ok := store.buckets["ip:4"]; !ok {
+			t.Error("expected the refilling bucket to be kept")
+		}
+	})
+
+	t.Run("zero limit is unlimited", func(t *testing.T) {
+		for range 10 {
+			result, _ := store.Take(ctx, "ip:3", Limit{})
+			if !result.Allowed {
+				t.Fatal("expected unlimited")
+			}
+		}
+	})
+}
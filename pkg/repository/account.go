@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Stores a new single-use token. Earlier unused tokens for the same purpose
+// stop working so only the latest email link is valid.
+func (s *storage) CreateUserToken(userID, tokenHash, purpose, email string, expiresAt time.Time) error {
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	q := "update user_tokens set used_at=now() where user_id=$1 and purpose=$2 and used_at is null"
+	_, err = tx.Exec(context.Background(), q, userID, purpose)
+	if err != nil {
+		return err
+	}
+
+	q = `
+	insert into user_tokens(token_hash,user_id,purpose,email,expires_at)
+	values($1,$2,$3,$4,$5)
+	`
+	_, err = tx.Exec(context.Background(), q, tokenHash, userID, purpose, email, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// Returns who a token was issued to without consuming it
+func (s *storage) GetUserToken(tokenHash, purpose string) (api.UserToken, error) {
+	var token api.UserToken
+
+	q := `
+	select user_id, email from user_tokens
+	where token_hash=$1 and purpose=$2 and used_at is null and expires_at > now()
+	`
+	err := s.db.QueryRow(context.Background(), q, tokenHash, purpose).Scan(&token.UserID,
+		&token.Email)
+
+	return token, err
+}
+
+// Marks the token used and returns who it was issued to. Fails if the token
+// doesn't exist, was already used or has expired.
+func (s *storage) UseUserToken(tokenHash, purpose string) (api.UserToken, error) {
+	var token api.UserToken
+
+	q := `
+	update user_tokens set used_at=now()
+	where token_hash=$1 and purpose=$2 and used_at is null and expires_at > now()
+	returning user_id, email
+	`
+	err := s.db.QueryRow(context.Background(), q, tokenHash, purpose).Scan(&token.UserID,
+		&token.Email)
+
+	return token, err
+}
+
+// Consumes an email verification token and marks its email verified in one
+// transaction. The token is only used up if the email is still the user's
+// current one.
+func (s *storage) UseVerifyEmailToken(tokenHash string) (api.UserToken, error) {
+	var token api.UserToken
+
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return token, err
+	}
+	defer tx.Rollback(context.Background())
+
+	q := `
+	update user_tokens set used_at=now()
+	where token_hash=$1 and purpose=$2 and used_at is null and expires_at > now()
+	returning user_id, email
+	`
+	err = tx.QueryRow(context.Background(), q, tokenHash, api.TokenVerifyEmail).Scan(&token.UserID,
+		&token.Email)
+	if err != nil {
+		return token, notFound(err, api.ErrInvalidToken)
+	}
+
+	q = "update users set email_verified=true where id=$1 and email=$2"
+	tag, err := tx.Exec(context.Background(), q, token.UserID, token.Email)
+	if err != nil {
+		return token, err
+	}
+
+	if tag.RowsAffected() != 1 {
+		return token, api.ErrInvalidToken
+	}
+
+	return token, tx.Commit(context.Background())
+}
+
+// Replaces the password hash and invalidates every refresh session
+func (s *storage) UpdatePassword(userID, password string) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	q := `
+	update users set hash=$1, refresh_token_version = refresh_token_version + 1
+	where id=$2
+	`
+	_, err = tx.Exec(context.Background(), q, string(hashed), userID)
+	if err != nil {
+		return err
+	}
+
+	q = "update refresh_sessions set revoked_at=now() where user_id=$1 and revoked_at is null"
+	_, err = tx.Exec(context.Background(), q, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// Renames the user and starts their cooldown. Names are unique ignoring case.
+// A recase keeps username_changed_at so it doesn't restart the cooldown
+func (s *storage) UpdateUsername(userID, username string, recase bool) error {
+	q := "update users set username=$2, username_changed_at=now() where id=$1"
+	if recase {
+		q = "update users set username=$2 where id=$1"
+	}
+
+	tag, err := s.db.Exec(context.Background(), q, userID, username)
+	if err != nil {
+		return userConflict(err)
+	}
+
+	if tag.RowsAffected() != 1 {
+		return api.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// Consumes an email change token and switches the user to its email, already
+// verified, in one transaction. A token whose email was taken since it was
+// issued is left unused.
+func (s *storage) UseEmailChangeToken(tokenHash string) (api.UserToken, error) {
+	var token api.UserToken
+
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return token, err
+	}
+	defer tx.Rollback(context.Background())
+
+	q := `
+	update user_tokens set used_at=now()
+	where token_hash=$1 and purpose=$2 and used_at is null and expires_at > now()
+	returning user_id, email
+	`
+	err = tx.QueryRow(context.Background(), q, tokenHash, api.TokenChangeEmail).Scan(&token.UserID,
+		&token.Email)
+	if err != nil {
+		return token, notFound(err, api.ErrInvalidToken)
+	}
+
+	q = "update users set email=$2, email_verified=true where id=$1"
+	tag, err := tx.Exec(context.Background(), q, token.UserID, token.Email)
+	if err != nil {
+		return token, userConflict(err)
+	}
+
+	if tag.RowsAffected() != 1 {
+		return token, api.ErrUserNotFound
+	}
+
+	return token, tx.Commit(context.Background())
+}
+
+// Maps a unique violation on the users table to the field that clashed
+func userConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return err
+	}
+
+	switch pgErr.ConstraintName {
+	case "users_username_unique_idx":
+		return api.ErrUsernameTaken
+	case "users_email_unique_idx":
+		return api.ErrEmailTaken
+	}
+
+	return err
+}
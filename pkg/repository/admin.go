@@ -0,0 +1,254 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// Matches on username or email, or the exact user id. An empty query lists
+// everyone, newest first.
+func (s *storage) SearchUsers(query string, limit, offset int) ([]api.User, error) {
+	users := make([]api.User, 0)
+
+	q := "select " + userColumns + ` from users
+	where $1 = '' or username ilike '%' || $1 || '%' or email ilike '%' || $1 || '%'
+		or id::text = $1
+	order by created_at desc
+	limit $2 offset $3
+	`
+	rows, err := s.db.Query(context.Background(), q, query, limit, offset)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := s.scanUser(rows)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// Changes the role and logs the user out so new tokens carry it
+func (s *storage) SetRole(userID, role string, audit api.AuditEntry) error {
+	return s.updateAndRevoke(userID, audit, "update users set role=$2 where id=$1", role)
+}
+
+// Posts a signed balance change. Fails if the balance would go negative.
+func (s *storage) AdjustBalance(userID string, delta api.Money, audit api.AuditEntry) (api.Money, error) {
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return api.Money{}, err
+	}
+	defer tx.Rollback(context.Background())
+
+	balance, err := post(context.Background(), tx, api.Posting{UserID: userID, Amount: delta,
+		Reason: api.ReasonAdminAdjustment, Reference: audit.ActorID})
+	if err != nil {
+		return balance, err
+	}
+
+	audit.Details["balance"] = balance
+	err = recordAudit(context.Background(), tx, audit)
+	if err != nil {
+		return balance, err
+	}
+
+	return balance, tx.Commit(context.Background())
+}
+
+// Bans the user and ends every session they have
+func (s *storage) BanUser(userID, reason string, audit api.AuditEntry) error {
+	q := "update users set banned_at=now(), ban_reason=$2 where id=$1"
+	return s.updateAndRevoke(userID, audit, q, reason)
+}
+
+func (s *storage) UnbanUser(userID string, audit api.AuditEntry) error {
+	q := "update users set banned_at=null, ban_reason=null where id=$1"
+	return s.updateAudited(audit, api.ErrUserNotFound, q, userID)
+}
+
+func (s *storage) UpdateCrate(crateID string, update *api.CrateUpdate, audit api.AuditEntry) error {
+	q := `
+	update crates set name=coalesce($2, name), cost_cents=coalesce($3, cost_cents)
+	where id=$1
+	`
+	return s.updateAudited(audit, api.ErrCrateNotFound, q, crateID, update.Name, update.Cost)
+}
+
+// Replaces the crate's drop table
+func (s *storage) SetCrateSkins(crateID string, drops api.DropTable, audit api.AuditEntry) error {
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	q := "delete from crate_skins where crate_id=$1"
+	_, err = tx.Exec(context.Background(), q, crateID)
+	if err != nil {
+		return err
+	}
+
+	skinIDs := make([]int, len(drops))
+	weights := make([]int, len(drops))
+	for i, drop := range drops {
+		skinIDs[i] = drop.SkinID
+		weights[i] = drop.Weight
+	}
+
+	q = `
+	insert into crate_skins(crate_id,skin_id,weight)
+	select $1, unnest($2::int[]), unnest($3::int[])
+	`
+	_, err = tx.Exec(context.Background(), q, crateID, skinIDs, weights)
+	if err != nil {
+		return err
+	}
+
+	err = recordAudit(context.Background(), tx, audit)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// For actions that don't touch the database
+func (s *storage) RecordAudit(entry api.AuditEntry) error {
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	err = recordAudit(context.Background(), tx, entry)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// Writes an audit entry inside the transaction making the change
+func recordAudit(ctx context.Context, tx pgx.Tx, entry api.AuditEntry) error {
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+
+	q := `
+	insert into admin_audit_log(actor_id,action,target_type,target_id,details)
+	values($1,$2,$3,$4,$5)
+	`
+	_, err = tx.Exec(ctx, q, entry.ActorID, entry.Action, entry.TargetType, entry.TargetID, details)
+	return err
+}
+
+// Newest entries first
+func (s *storage) GetAuditLog(limit, offset int) ([]api.AuditEntry, error) {
+	entries := make([]api.AuditEntry, 0)
+
+	q := `
+	select id, actor_id, action, target_type, target_id, details, created_at
+	from admin_audit_log
+	order by id desc
+	limit $1 offset $2
+	`
+	rows, err := s.db.Query(context.Background(), q, limit, offset)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry api.AuditEntry
+		var details []byte
+
+		err := rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &entry.TargetType,
+			&entry.TargetID, &details, &entry.CreatedAt)
+		if err != nil {
+			return entries, err
+		}
+
+		if err := json.Unmarshal(details, &entry.Details); err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Runs a single row update along with its audit entry. No row updated is
+// missing.
+func (s *storage) updateAudited(audit api.AuditEntry, missing *api.Error, q string, args ...any) error {
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	tag, err := tx.Exec(context.Background(), q, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() != 1 {
+		return missing
+	}
+
+	err = recordAudit(context.Background(), tx, audit)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// Runs a single row update on the user then invalidates their refresh
+// sessions and writes the audit entry in the same transaction
+func (s *storage) updateAndRevoke(userID string, audit api.AuditEntry, q string, args ...any) error {
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	tag, err := tx.Exec(context.Background(), q, append([]any{userID}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() != 1 {
+		return api.ErrUserNotFound
+	}
+
+	q = `
+	update users set refresh_token_version = refresh_token_version + 1 where id=$1
+	`
+	_, err = tx.Exec(context.Background(), q, userID)
+	if err != nil {
+		return err
+	}
+
+	q = "update refresh_sessions set revoked_at=now() where user_id=$1 and revoked_at is null"
+	_, err = tx.Exec(context.Background(), q, userID)
+	if err != nil {
+		return err
+	}
+
+	err = recordAudit(context.Background(), tx, audit)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
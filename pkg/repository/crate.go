@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+)
+
+const crateContentsQuery = `
+select cs.crate_id, s.id, s.name, s.rarity, s.collection, s.can_be_stattrak, s.image_key,
+	cs.weight
+from crate_skins cs
+join skins s on s.id = cs.skin_id
+where cs.crate_id = any($1)
+order by cs.crate_id, s.id
+`
+
+func (s *storage) GetCrates() ([]api.Crate, error) {
+	crates := make([]api.Crate, 0)
+
+	rows, err := s.db.Query(context.Background(), "select id, name, cost_cents from crates order by id")
+	if err != nil {
+		return crates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var crate api.Crate
+		if err := rows.Scan(&crate.ID, &crate.Name, &crate.Cost); err != nil {
+			return crates, err
+		}
+		crates = append(crates, crate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return crates, err
+	}
+
+	return crates, s.fillCrateContents(crates)
+}
+
+func (s *storage) GetCrate(crateID string) (api.Crate, error) {
+	var crate api.Crate
+
+	q := "select id, name, cost_cents from crates where id=$1"
+	err := s.db.QueryRow(context.Background(), q, crateID).Scan(&crate.ID, &crate.Name, &crate.Cost)
+	if err != nil {
+		return crate, notFound(err, api.ErrCrateNotFound)
+	}
+
+	crates := []api.Crate{crate}
+	err = s.fillCrateContents(crates)
+	return crates[0], err
+}
+
+func (s *storage) fillCrateContents(crates []api.Crate) error {
+	ids := make([]int, len(crates))
+	byID := make(map[int]*api.Crate, len(crates))
+	for i := range crates {
+		ids[i] = crates[i].ID
+		byID[crates[i].ID] = &crates[i]
+		crates[i].Contents = make([]api.CrateDrop, 0)
+	}
+
+	rows, err := s.db.Query(context.Background(), crateContentsQuery, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var crateID int
+		var drop api.CrateDrop
+		var imageKey string
+
+		err := rows.Scan(&crateID, &drop.SkinID, &drop.Name, &drop.Rarity, &drop.Collection,
+			&drop.CanBeStatTrak, &imageKey, &drop.Weight)
+		if err != nil {
+			return err
+		}
+
+		drop.ImgSrc = s.createImgSrc(imageKey)
+		crate := byID[crateID]
+		crate.Contents = append(crate.Contents, drop)
+	}
+
+	return rows.Err()
+}
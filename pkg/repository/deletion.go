@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// Every tradeup the user has put items into, newest first
+func (s *storage) GetTradeupEntries(userID string) ([]api.TradeupEntry, error) {
+	entries := make([]api.TradeupEntry, 0)
+
+	q := `
+	select t.id, t.rarity, t.current_status, count(*), coalesce(sum(i.price_cents), 0)::bigint,
+		coalesce(t.winner::text = $2, false), min(ts.entered)
+	from tradeups_skins ts
+	join inventory i on i.id = ts.inv_id
+	join tradeups t on t.id = ts.tradeup_id
+	where i.user_id = $1
+	group by t.id
+	order by min(ts.entered) desc
+	`
+	rows, err := s.db.Query(context.Background(), q, userID, userID)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry api.TradeupEntry
+		err := rows.Scan(&entry.TradeupID, &entry.Rarity, &entry.Status, &entry.ItemsEntered,
+			&entry.ValueEntered, &entry.Won, &entry.EnteredAt)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (s *storage) GetCratePurchases(userID string) ([]api.CratePurchase, error) {
+	purchases := make([]api.CratePurchase, 0)
+
+	q := `
+	select co.crate_id, c.name, co.amount, co.cost_cents, co.charged_once, co.created_at
+	from crate_openings co
+	join crates c on c.id = co.crate_id
+	where co.user_id = $1
+	order by co.created_at desc
+	`
+	rows, err := s.db.Query(context.Background(), q, userID)
+	if err != nil {
+		return purchases, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var purchase api.CratePurchase
+		err := rows.Scan(&purchase.CrateID, &purchase.CrateName, &purchase.Amount, &purchase.Cost,
+			&purchase.ChargedOnce, &purchase.CreatedAt)
+		if err != nil {
+			return purchases, err
+		}
+		purchases = append(purchases, purchase)
+	}
+
+	return purchases, rows.Err()
+}
+
+// Every ledger entry on the user, newest first
+func (s *storage) GetBalanceHistory(userID string) ([]api.BalanceChange, error) {
+	changes := make([]api.BalanceChange, 0)
+
+	q := `
+	select e.amount, t.reason, e.created_at
+	from ledger_entries e
+	join ledger_transactions t on t.id = e.transaction_id
+	where e.user_id = $1
+	order by e.id desc
+	`
+	rows, err := s.db.Query(context.Background(), q, userID)
+	if err != nil {
+		return changes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var change api.BalanceChange
+		if err := rows.Scan(&change.Delta, &change.Reason, &change.CreatedAt); err != nil {
+			return changes, err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
+
+func (s *storage) ScheduleDeletion(userID string, at time.Time) error {
+	q := "update users set delete_after=$2 where id=$1 and deleted_at is null"
+	tag, err := s.db.Exec(context.Background(), q, userID, at)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() != 1 {
+		return api.ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s *storage) CancelDeletion(userID string) error {
+	q := "update users set delete_after=null where id=$1 and deleted_at is null"
+	_, err := s.db.Exec(context.Background(), q, userID)
+	return err
+}
+
+// Users whose grace period has run out
+func (s *storage) GetDueDeletions() ([]string, error) {
+	userIDs := make([]string, 0)
+
+	q := "select id from users where delete_after <= now() and deleted_at is null"
+	rows, err := s.db.Query(context.Background(), q)
+	if err != nil {
+		return userIDs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return userIDs, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+// Anonymizes the account and pulls its items out of open tradeups. The user
+// row, inventory, crate openings, tradeup history and audit entries stay so
+// balances and past tradeups still add up. Returns the avatar key that was
+// removed.
+func (s *storage) PurgeUser(userID string) (string, error) {
+	var avatarKey string
+
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return avatarKey, err
+	}
+	defer tx.Rollback(context.Background())
+
+	q := "select avatar_key from users where id=$1 and deleted_at is null for update"
+	err = tx.QueryRow(context.Background(), q, userID).Scan(&avatarKey)
+	if err != nil {
+		return avatarKey, err
+	}
+
+	// same as removing each skin by hand, a full tradeup losing items stops
+	// its timer
+	q = `
+	with pulled as (
+		delete from tradeups_skins ts
+		using inventory i, tradeups t
+		where ts.inv_id = i.id and t.id = ts.tradeup_id and i.user_id = $1
+			and t.current_status in ('Active', 'Waiting')
+		returning ts.tradeup_id, ts.inv_id
+	), stopped as (
+		update tradeups set stop_time=now()+interval '5 year', current_status='Active'
+		where id in (select tradeup_id from pulled) and current_status='Waiting'
+	)
+	update inventory set visible=true where id in (select inv_id from pulled)
+	`
+	_, err = tx.Exec(context.Background(), q, userID)
+	if err != nil {
+		return avatarKey, err
+	}
+
+	for _, q := range []string{
+		"delete from user_showcase where user_id=$1",
+		"delete from user_recovery_codes where user_id=$1",
+		"delete from user_tokens where user_id=$1",
+		"delete from refresh_sessions where user_id=$1",
+	} {
+		if _, err := tx.Exec(context.Background(), q, userID); err != nil {
+			return avatarKey, err
+		}
+	}
+
+	q = `
+	update users set username='deleted_' || left(replace(id::text, '-', ''), 12),
+		email=null, email_verified=false, hash=null, steam_id=null, steam_persona=null,
+		avatar_key=$2, totp_secret=null, totp_enabled=false, hide_inventory=true,
+		hide_stats=true, refresh_token_version = refresh_token_version + 1,
+		delete_after=null, deleted_at=now()
+	where id=$1
+	`
+	_, err = tx.Exec(context.Background(), q, userID, defaultAvatarKey)
+	if err != nil {
+		return avatarKey, err
+	}
+
+	return avatarKey, tx.Commit(context.Background())
+}
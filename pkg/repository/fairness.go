@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/jackc/pgx/v5"
+)
+
+const seedColumns = `id, server_seed, server_seed_hash, client_seed, nonce, created_at, revealed_at`
+
+func scanSeed(row pgx.Row, seed *api.FairSeed) error {
+	return row.Scan(&seed.ID, &seed.ServerSeed, &seed.ServerSeedHash, &seed.ClientSeed, &seed.Nonce,
+		&seed.CreatedAt, &seed.RevealedAt)
+}
+
+func (s *storage) GetActiveSeed(userID string) (api.FairSeed, error) {
+	var seed api.FairSeed
+
+	q := "select " + seedColumns + " from fair_seeds where user_id=$1 and revealed_at is null"
+	err := scanSeed(s.db.QueryRow(context.Background(), q, userID), &seed)
+	return seed, notFound(err, api.ErrNotFound)
+}
+
+// Does nothing if the user already has an active seed, so two requests racing
+// to make the first one both end up with the same seed
+func (s *storage) CreateSeed(userID, serverSeed, serverSeedHash, clientSeed string) error {
+	q := `
+	insert into fair_seeds(user_id, server_seed, server_seed_hash, client_seed)
+	values($1,$2,$3,$4)
+	on conflict (user_id) where revealed_at is null and user_id is not null do nothing
+	`
+	_, err := s.db.Exec(context.Background(), q, userID, serverSeed, serverSeedHash, clientSeed)
+	return err
+}
+
+// Reveals the active seed and replaces it in one go
+func (s *storage) RotateSeed(userID, serverSeed, serverSeedHash, clientSeed string) (api.SeedRotation, error) {
+	var rotation api.SeedRotation
+
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return rotation, err
+	}
+	defer tx.Rollback(context.Background())
+
+	q := `
+	update fair_seeds set revealed_at = now()
+	where user_id=$1 and revealed_at is null
+	returning ` + seedColumns
+	err = scanSeed(tx.QueryRow(context.Background(), q, userID), &rotation.Revealed)
+	if err != nil {
+		return rotation, notFound(err, api.ErrNotFound)
+	}
+
+	q = `
+	insert into fair_seeds(user_id, server_seed, server_seed_hash, client_seed)
+	values($1,$2,$3,$4)
+	returning ` + seedColumns
+	err = scanSeed(tx.QueryRow(context.Background(), q, userID, serverSeed, serverSeedHash, clientSeed),
+		&rotation.Current)
+	if err != nil {
+		return rotation, err
+	}
+
+	return rotation, tx.Commit(context.Background())
+}
+
+func (s *storage) CreateTradeupSeed(tradeupID int, serverSeed, serverSeedHash string) error {
+	q := `
+	insert into fair_seeds(tradeup_id, server_seed, server_seed_hash)
+	values($1,$2,$3)
+	on conflict (tradeup_id) where tradeup_id is not null do nothing
+	`
+	_, err := s.db.Exec(context.Background(), q, tradeupID, serverSeed, serverSeedHash)
+	return err
+}
+
+// Fixes the tradeup's client seed and reveals its server seed. Revealing twice
+// keeps the first reveal time.
+func (s *storage) RevealTradeupSeed(tradeupID int, clientSeed string) (api.FairSeed, error) {
+	var seed api.FairSeed
+
+	q := `
+	update fair_seeds set client_seed=$2, revealed_at=coalesce(revealed_at, now())
+	where tradeup_id=$1
+	returning ` + seedColumns
+	err := scanSeed(s.db.QueryRow(context.Background(), q, tradeupID, clientSeed), &seed)
+	return seed, notFound(err, api.ErrNotFound)
+}
+
+// Saves a roll inside the transaction that acts on it. A user roll takes its
+// seed's nonce, and fails with ErrSeedChanged if another roll took it first or
+// the seed was rotated out since.
+func recordRoll(ctx context.Context, tx pgx.Tx, roll api.FairRoll) error {
+	if roll.UserID != "" {
+		q := `
+		update fair_seeds set nonce = nonce + 1
+		where id=$1 and nonce=$2 and revealed_at is null
+		`
+		tag, err := tx.Exec(ctx, q, roll.SeedID, roll.Nonce)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return api.ErrSeedChanged
+		}
+	}
+
+	q := `
+	insert into fair_rolls(seed_id, user_id, game, reference, nonce, inputs, outcome)
+	values($1,nullif($2, '')::uuid,$3,$4,$5,$6,$7)
+	`
+	_, err := tx.Exec(ctx, q, roll.SeedID, roll.UserID, roll.Game, roll.Reference, roll.Nonce,
+		roll.Inputs, roll.Outcome)
+	return err
+}
+
+// A roll with the seed it came from. The server seed is only filled in once
+// it's been revealed.
+func (s *storage) GetRoll(rollID int64) (api.FairRoll, api.FairSeed, error) {
+	var roll api.FairRoll
+	var seed api.FairSeed
+
+	q := `
+	select r.id, r.seed_id, coalesce(r.user_id::text, ''), r.game, r.reference, r.nonce, r.inputs,
+		r.outcome, r.created_at,
+		f.id, case when f.revealed_at is null then '' else f.server_seed end, f.server_seed_hash,
+		f.client_seed, f.nonce, f.created_at, f.revealed_at
+	from fair_rolls r
+	join fair_seeds f on f.id = r.seed_id
+	where r.id=$1
+	`
+	err := s.db.QueryRow(context.Background(), q, rollID).Scan(&roll.ID, &roll.SeedID, &roll.UserID,
+		&roll.Game, &roll.Reference, &roll.Nonce, &roll.Inputs, &roll.Outcome, &roll.CreatedAt,
+		&seed.ID, &seed.ServerSeed, &seed.ServerSeedHash, &seed.ClientSeed, &seed.Nonce,
+		&seed.CreatedAt, &seed.RevealedAt)
+	if err != nil {
+		return roll, seed, notFound(err, api.ErrRollNotFound)
+	}
+
+	return roll, seed, nil
+}
+
+// The user's crate rolls, newest first
+func (s *storage) GetRolls(userID string, limit, offset int) ([]api.FairRoll, error) {
+	rolls := make([]api.FairRoll, 0)
+
+	q := `
+	select id, seed_id, game, reference, nonce, inputs, outcome, created_at
+	from fair_rolls
+	where user_id=$1
+	order by id desc
+	limit $2 offset $3
+	`
+	rows, err := s.db.Query(context.Background(), q, userID, limit, offset)
+	if err != nil {
+		return rolls, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		roll := api.FairRoll{UserID: userID}
+		err := rows.Scan(&roll.ID, &roll.SeedID, &roll.Game, &roll.Reference, &roll.Nonce,
+			&roll.Inputs, &roll.Outcome, &roll.CreatedAt)
+		if err != nil {
+			return rolls, err
+		}
+		rolls = append(rolls, roll)
+	}
+
+	return rolls, rows.Err()
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// Writes the user's entry and its counter entry and moves the cached balance,
+// all inside tx. A debit that would take the balance below zero fails with
+// ErrInsufficientFunds. Returns the new balance.
+func post(ctx context.Context, tx pgx.Tx, posting api.Posting) (api.Money, error) {
+	var balance api.Money
+
+	q := `
+	update users set balance_cents = balance_cents + $2
+	where id=$1 and balance_cents + $2 >= 0
+	returning balance_cents
+	`
+	err := tx.QueryRow(ctx, q, posting.UserID, posting.Amount).Scan(&balance)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return balance, api.ErrInsufficientFunds
+	}
+	if err != nil {
+		return balance, err
+	}
+
+	var transactionID int64
+	q = "insert into ledger_transactions(reason, reference) values($1,$2) returning id"
+	err = tx.QueryRow(ctx, q, posting.Reason, posting.Reference).Scan(&transactionID)
+	if err != nil {
+		return balance, err
+	}
+
+	q = `
+	insert into ledger_entries(transaction_id, user_id, account, amount, balance_after)
+	values($1,$2,null,$3,$4), ($1,null,$5,-$3,null)
+	`
+	_, err = tx.Exec(ctx, q, transactionID, posting.UserID, posting.Amount, balance,
+		api.CounterAccount(posting.Reason))
+	return balance, err
+}
+
+func (s *storage) GetLedgerEntries(userID string, limit, offset int) ([]api.LedgerEntry, error) {
+	entries := make([]api.LedgerEntry, 0)
+
+	q := `
+	select e.id, e.transaction_id, e.amount, e.balance_after, t.reason, t.reference, e.created_at
+	from ledger_entries e
+	join ledger_transactions t on t.id = e.transaction_id
+	where e.user_id = $1
+	order by e.id desc
+	limit $2 offset $3
+	`
+	rows, err := s.db.Query(context.Background(), q, userID, limit, offset)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry api.LedgerEntry
+		err := rows.Scan(&entry.ID, &entry.TransactionID, &entry.Amount, &entry.BalanceAfter,
+			&entry.Reason, &entry.Reference, &entry.CreatedAt)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (s *storage) FindBalanceDrift() ([]api.BalanceDrift, error) {
+	drift := make([]api.BalanceDrift, 0)
+
+	q := `
+	select u.id, u.balance_cents, coalesce(sum(e.amount), 0)::bigint
+	from users u
+	left join ledger_entries e on e.user_id = u.id
+	group by u.id
+	having u.balance_cents <> coalesce(sum(e.amount), 0)
+	`
+	rows, err := s.db.Query(context.Background(), q)
+	if err != nil {
+		return drift, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d api.BalanceDrift
+		if err := rows.Scan(&d.UserID, &d.Cached, &d.Ledger); err != nil {
+			return drift, err
+		}
+		drift = append(drift, d)
+	}
+
+	return drift, rows.Err()
+}
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/erobx/csupgrade-go-api/pkg/pricing"
+	"github.com/jackc/pgx/v5"
+)
+
+// Replaces the import in use
+func (s *storage) SavePriceImport(list pricing.PriceList) error {
+	entries, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	q := "update price_imports set cleared_at=now() where cleared_at is null"
+	_, err = tx.Exec(context.Background(), q)
+	if err != nil {
+		return err
+	}
+
+	q = "insert into price_imports(entries) values($1)"
+	_, err = tx.Exec(context.Background(), q, entries)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+func (s *storage) GetPriceImport() (pricing.PriceList, error) {
+	var entries []byte
+
+	q := "select entries from price_imports where cleared_at is null order by id desc limit 1"
+	err := s.db.QueryRow(context.Background(), q).Scan(&entries)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list pricing.PriceList
+	err = json.Unmarshal(entries, &list)
+	return list, err
+}
+
+func (s *storage) ClearPriceImport() error {
+	q := "update price_imports set cleared_at=now() where cleared_at is null"
+	_, err := s.db.Exec(context.Background(), q)
+	return err
+}
+
+// Skins the price list has but the skins table doesn't are left out. A close
+// is only replaced by a later snapshot.
+func (s *storage) SaveDailyPrices(snapshots []api.PriceSnapshot) error {
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	skinIDs := make([]int, len(snapshots))
+	wears := make([]string, len(snapshots))
+	statTraks := make([]bool, len(snapshots))
+	prices := make([]int64, len(snapshots))
+	for i, snapshot := range snapshots {
+		skinIDs[i] = snapshot.SkinID
+		wears[i] = snapshot.Wear
+		statTraks[i] = snapshot.IsStatTrak
+		prices[i] = snapshot.Price.Cents
+	}
+
+	q := `
+	insert into price_closes(skin_id, wear, is_stattrak, day, price_cents, taken_at)
+	select p.skin_id, p.wear, p.is_stattrak, ($5::timestamptz at time zone 'UTC')::date,
+		p.price_cents, $5
+	from unnest($1::int[], $2::text[], $3::boolean[], $4::bigint[])
+		as p(skin_id, wear, is_stattrak, price_cents)
+	join skins s on s.id = p.skin_id
+	on conflict (skin_id, wear, is_stattrak, day) do update
+	set price_cents = excluded.price_cents, taken_at = excluded.taken_at
+	where excluded.taken_at >= price_closes.taken_at
+	`
+	_, err := s.db.Exec(context.Background(), q, skinIDs, wears, statTraks, prices,
+		snapshots[0].TakenAt)
+	return err
+}
+
+// The close of each UTC day for each wear of the skins, TakenAt is the start
+// of the day. Ordered by skin, wear and StatTrak, then day.
+func (s *storage) GetDailyPrices(skinIDs []int, since time.Time) ([]api.PriceSnapshot, error) {
+	snapshots := make([]api.PriceSnapshot, 0)
+
+	q := `
+	select skin_id, wear, is_stattrak, price_cents, day
+	from price_closes
+	where skin_id = any($1) and day >= ($2::timestamptz at time zone 'UTC')::date
+	order by skin_id, wear, is_stattrak, day
+	`
+	rows, err := s.db.Query(context.Background(), q, skinIDs, since)
+	if err != nil {
+		return snapshots, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var snapshot api.PriceSnapshot
+		var day time.Time
+		err := rows.Scan(&snapshot.SkinID, &snapshot.Wear, &snapshot.IsStatTrak, &snapshot.Price, &day)
+		if err != nil {
+			return snapshots, err
+		}
+		snapshot.TakenAt = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, rows.Err()
+}
+
+func (s *storage) DeleteDailyPricesBefore(before time.Time) error {
+	q := "delete from price_closes where day < ($1::timestamptz at time zone 'UTC')::date"
+	_, err := s.db.Exec(context.Background(), q, before)
+	return err
+}
+
+// Items the user still has or got rid of after since
+func (s *storage) GetHeldItems(userID string, since time.Time) ([]api.HeldItem, error) {
+	items := make([]api.HeldItem, 0)
+
+	q := `
+	select skin_id, wear_num, is_stattrak, price_cents, created_at, removed_at
+	from inventory
+	where user_id = $1 and (removed_at >= $2 or removed_at is null and not was_used)
+	order by created_at
+	`
+	rows, err := s.db.Query(context.Background(), q, userID, since)
+	if err != nil {
+		return items, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item api.HeldItem
+		err := rows.Scan(&item.SkinID, &item.Float, &item.IsStatTrak, &item.Price, &item.AddedAt,
+			&item.RemovedAt)
+		if err != nil {
+			return items, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// Usernames are unique ignoring case. Deleted accounts have no profile.
+func (s *storage) GetUserByUsername(username string) (api.User, error) {
+	q := "select " + userColumns + `
+	from users where lower(username)=lower($1) and deleted_at is null
+	`
+	return s.scanUser(s.db.QueryRow(context.Background(), q, username))
+}
+
+// Returns the key being replaced
+func (s *storage) SetAvatarKey(userID, avatarKey string) (string, error) {
+	var oldKey string
+
+	q := `
+	update users u set avatar_key=$1
+	from (select avatar_key from users where id=$2 for update) old
+	where u.id=$2
+	returning old.avatar_key
+	`
+	err := s.db.QueryRow(context.Background(), q, avatarKey, userID).Scan(&oldKey)
+	return oldKey, err
+}
+
+func (s *storage) UpdatePrivacy(userID string, settings api.PrivacySettings) error {
+	q := "update users set hide_inventory=$1, hide_stats=$2 where id=$3"
+	_, err := s.db.Exec(context.Background(), q, settings.HideInventory, settings.HideStats,
+		userID)
+	return err
+}
+
+// Showcased items still in the user's inventory, in display order
+func (s *storage) GetShowcase(userID string) ([]api.Item, error) {
+	items := make([]api.Item, 0)
+
+	q := `
+	select i.id, i.skin_id, i.wear_str, i.wear_num, i.price_cents, i.is_stattrak,
+		i.was_won, i.created_at, i.visible, s.name, s.rarity, s.collection, s.image_key
+	from user_showcase us
+	join inventory i on i.id = us.inv_id
+	join skins s on s.id = i.skin_id
+	where us.user_id = $1 and i.user_id = $1 and i.was_used = false
+	order by us.position
+	`
+	rows, err := s.db.Query(context.Background(), q, userID)
+	if err != nil {
+		return items, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item api.Item
+		var skin api.Skin
+		var imageKey string
+
+		err := rows.Scan(&item.InvID, &skin.ID, &skin.Wear, &skin.Float, &skin.Price,
+			&skin.IsStatTrak, &skin.WasWon, &skin.CreatedAt, &item.Visible,
+			&skin.Name, &skin.Rarity, &skin.Collection, &imageKey)
+		if err != nil {
+			return items, err
+		}
+
+		skin.ImgSrc = s.createImgSrc(imageKey)
+		item.Data = skin
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// Replaces the showcase. Every item has to be owned and unused.
+func (s *storage) SetShowcase(userID string, invIDs []int) error {
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	q := "delete from user_showcase where user_id=$1"
+	_, err = tx.Exec(context.Background(), q, userID)
+	if err != nil {
+		return err
+	}
+
+	q = `
+	insert into user_showcase(user_id,inv_id,position)
+	select $1, i.id, ids.position
+	from unnest($2::int[]) with ordinality as ids(inv_id, position)
+	join inventory i on i.id = ids.inv_id
+	where i.user_id = $1 and i.was_used = false
+	`
+	tag, err := tx.Exec(context.Background(), q, userID, invIDs)
+	if err != nil {
+		return err
+	}
+
+	if int(tag.RowsAffected()) != len(invIDs) {
+		return api.ErrItemNotOwned
+	}
+
+	return tx.Commit(context.Background())
+}
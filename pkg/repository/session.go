@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *storage) CreateSession(userID, tokenHash, userAgent, ipAddress string,
+	expiresAt time.Time) (api.Session, error) {
+	var session api.Session
+
+	q := `
+	insert into refresh_sessions(id,user_id,token_hash,token_version,user_agent,ip_address,expires_at)
+	select $1, u.id, $3, u.refresh_token_version, $4, $5, $6 from users u where u.id=$2
+	returning id, user_id, token_version, user_agent, ip_address, created_at, last_used_at, expires_at
+	`
+	err := s.db.QueryRow(context.Background(), q, uuid.New().String(), userID, tokenHash,
+		userAgent, ipAddress, expiresAt).Scan(&session.ID, &session.UserID,
+		&session.TokenVersion, &session.UserAgent, &session.IPAddress, &session.CreatedAt,
+		&session.LastUsedAt, &session.ExpiresAt)
+
+	return session, err
+}
+
+// Returns the session matching the token hash along with the user's current
+// refresh token version
+func (s *storage) GetSessionByTokenHash(tokenHash string) (api.Session, int, error) {
+	var session api.Session
+	var currentVersion int
+
+	q := `
+	select rs.id, rs.user_id, rs.token_version, rs.user_agent, rs.ip_address,
+		rs.created_at, rs.last_used_at, rs.expires_at, rs.revoked_at, u.refresh_token_version
+	from refresh_sessions rs
+	join users u on u.id = rs.user_id
+	where rs.token_hash=$1
+	`
+	err := s.db.QueryRow(context.Background(), q, tokenHash).Scan(&session.ID,
+		&session.UserID, &session.TokenVersion, &session.UserAgent, &session.IPAddress,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.RevokedAt,
+		&currentVersion)
+
+	return session, currentVersion, err
+}
+
+// Swaps the stored token hash. Fails if the old hash was already rotated away
+// so two concurrent refreshes with the same token can't both succeed.
+func (s *storage) RotateSession(sessionID, oldHash, newHash, ipAddress string,
+	expiresAt time.Time) error {
+	q := `
+	update refresh_sessions
+	set token_hash=$1, expires_at=$2, ip_address=$3, last_used_at=now()
+	where id=$4 and token_hash=$5 and revoked_at is null
+	`
+	tag, err := s.db.Exec(context.Background(), q, newHash, expiresAt, ipAddress, sessionID,
+		oldHash)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() != 1 {
+		return api.Wrap(api.ErrInvalidRefreshToken, errors.New("refresh session already rotated"))
+	}
+
+	return nil
+}
+
+// Sessions that can still be refreshed, most recently used first
+func (s *storage) GetActiveSessions(userID string) ([]api.Session, error) {
+	sessions := make([]api.Session, 0)
+
+	q := `
+	select rs.id, rs.user_id, rs.token_version, rs.user_agent, rs.ip_address,
+		rs.created_at, rs.last_used_at, rs.expires_at
+	from refresh_sessions rs
+	join users u on u.id = rs.user_id
+	where rs.user_id=$1 and rs.revoked_at is null and rs.expires_at > now()
+		and rs.token_version = u.refresh_token_version
+	order by rs.last_used_at desc
+	`
+	rows, err := s.db.Query(context.Background(), q, userID)
+	if err != nil {
+		return sessions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var session api.Session
+		err := rows.Scan(&session.ID, &session.UserID, &session.TokenVersion,
+			&session.UserAgent, &session.IPAddress, &session.CreatedAt, &session.LastUsedAt,
+			&session.ExpiresAt)
+		if err != nil {
+			return sessions, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Revokes a single session. Returns false if the user has no such active
+// session.
+func (s *storage) RevokeSession(userID, sessionID string) (bool, error) {
+	q := `
+	update refresh_sessions set revoked_at=now()
+	where id=$1 and user_id=$2 and revoked_at is null
+	`
+	tag, err := s.db.Exec(context.Background(), q, sessionID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// Invalidates every outstanding refresh session for the user
+func (s *storage) BumpRefreshTokenVersion(userID string) error {
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	q := "update users set refresh_token_version = refresh_token_version + 1 where id=$1"
+	_, err = tx.Exec(context.Background(), q, userID)
+	if err != nil {
+		return err
+	}
+
+	q = "update refresh_sessions set revoked_at=now() where user_id=$1 and revoked_at is null"
+	_, err = tx.Exec(context.Background(), q, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// Not revoked, not expired and issued under the user's current refresh token
+// version
+func (s *storage) IsSessionActive(sessionID string) (bool, error) {
+	var active bool
+
+	q := `
+	select exists(
+		select 1 from refresh_sessions rs
+		join users u on u.id = rs.user_id
+		where rs.id=$1 and rs.revoked_at is null and rs.expires_at > now()
+			and rs.token_version = u.refresh_token_version
+	)
+	`
+	err := s.db.QueryRow(context.Background(), q, sessionID).Scan(&active)
+	return active, err
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sort"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/jackc/pgx/v5"
+)
+
+// Raw stats for the user, totals and rates are derived by the user service
+func (s *storage) GetStats(userID string) (api.Stats, error) {
+	stats := api.Stats{ByRarity: make([]api.RarityStats, 0)}
+
+	// value the user put into each tradeup they entered. Contributions only
+	// count once the tradeup is completed since items can still be pulled out
+	// before then.
+	q := `
+	select t.rarity,
+		count(*),
+		count(*) filter (where t.current_status = 'Completed'),
+		count(*) filter (where t.winner::text = $1),
+		coalesce(sum(c.value) filter (where t.current_status = 'Completed'), 0)::bigint
+	from (
+		select ts.tradeup_id, sum(i.price_cents) as value
+		from tradeups_skins ts
+		join inventory i on i.id = ts.inv_id
+		where i.user_id = $1
+		group by ts.tradeup_id
+	) c
+	join tradeups t on t.id = c.tradeup_id
+	group by t.rarity
+	order by t.rarity collate "C"
+	`
+	rows, err := s.db.Query(context.Background(), q, userID)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r api.RarityStats
+		var completed int
+
+		err := rows.Scan(&r.Rarity, &r.Entered, &completed, &r.Won, &r.Contributed)
+		if err != nil {
+			return stats, err
+		}
+
+		stats.TradeupsCompleted += completed
+		stats.ByRarity = append(stats.ByRarity, r)
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	// skins pulled from crates, each carrying its share of what the opening
+	// cost
+	q = `
+	select s.rarity, count(*), round(sum(co.cost_cents::numeric / co.amount))::bigint
+	from inventory i
+	join crate_openings co on co.id = i.crate_opening_id
+	join skins s on s.id = i.skin_id
+	where i.user_id = $1
+	group by s.rarity
+	`
+	rows, err = s.db.Query(context.Background(), q, userID)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rarity string
+		var pulls int
+		var spend api.Money
+
+		if err := rows.Scan(&rarity, &pulls, &spend); err != nil {
+			return stats, err
+		}
+
+		stats.ByRarity = addCrateStats(stats.ByRarity, rarity, pulls, spend)
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	q = "select coalesce(sum(price_cents), 0)::bigint from inventory where user_id=$1 and was_won=true"
+	err = s.db.QueryRow(context.Background(), q, userID).Scan(&stats.ValueWon)
+	if err != nil {
+		return stats, err
+	}
+
+	q = "select coalesce(sum(amount), 0), coalesce(sum(cost_cents), 0)::bigint from crate_openings where user_id=$1"
+	err = s.db.QueryRow(context.Background(), q, userID).Scan(&stats.CratesOpened,
+		&stats.CrateSpend)
+	if err != nil {
+		return stats, err
+	}
+
+	bestPull, err := s.getBestPull(userID)
+	if err != nil && err != pgx.ErrNoRows {
+		return stats, err
+	}
+	if err == nil {
+		stats.BestPull = &bestPull
+	}
+
+	return stats, nil
+}
+
+// Adds crate pulls to the rarity's stats, keeping byRarity ordered by rarity
+func addCrateStats(byRarity []api.RarityStats, rarity string, pulls int, spend api.Money) []api.RarityStats {
+	i := sort.Search(len(byRarity), func(i int) bool { return byRarity[i].Rarity >= rarity })
+	if i == len(byRarity) || byRarity[i].Rarity != rarity {
+		byRarity = slices.Insert(byRarity, i, api.RarityStats{Rarity: rarity})
+	}
+
+	byRarity[i].CratePulls += pulls
+	byRarity[i].CrateSpend = byRarity[i].CrateSpend.Add(spend)
+	return byRarity
+}
+
+// Most valuable item the user has ever owned, from a crate or a tradeup
+func (s *storage) getBestPull(userID string) (api.Item, error) {
+	var item api.Item
+	var skin api.Skin
+	var imageKey string
+
+	q := `
+	select i.id, i.skin_id, i.wear_str, i.wear_num, i.price_cents, i.is_stattrak,
+		i.was_won, i.created_at, i.visible, s.name, s.rarity, s.collection, s.image_key
+	from inventory i
+	join skins s on s.id = i.skin_id
+	where i.user_id = $1
+	order by i.price_cents desc, i.created_at desc
+	limit 1
+	`
+	err := s.db.QueryRow(context.Background(), q, userID).Scan(&item.InvID, &skin.ID,
+		&skin.Wear, &skin.Float, &skin.Price, &skin.IsStatTrak, &skin.WasWon,
+		&skin.CreatedAt, &item.Visible, &skin.Name, &skin.Rarity, &skin.Collection, &imageKey)
+	if err != nil {
+		return item, err
+	}
+
+	skin.ImgSrc = s.createImgSrc(imageKey)
+	item.Data = skin
+	return item, nil
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"log"
-	"math/rand/v2"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/erobx/csupgrade-go-api/pkg/pricing"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -18,12 +20,71 @@ type Storage interface {
 	CreateUser(request *api.NewUserRequest) (string, error)
 	GetUserByID(userID string) (api.User, error)
 	GetUserAndHashByEmail(email string) (api.User, string, error)
+	GetUserAndHashByID(userID string) (api.User, string, error)
+	GetUserBySteamID(steamID string) (api.User, error)
+	CreateSteamUser(profile api.SteamProfile) (string, error)
+	LinkSteam(userID string, profile api.SteamProfile) error
+	UnlinkSteam(userID string) error
+	HasPassword(userID string) (bool, error)
+	GetLoginLock(email, ipAddress string) (time.Time, error)
+	RecordFailedLogin(email, ipAddress string) (int, error)
+	LockLogin(email, ipAddress string, until time.Time) error
+	ResetFailedLogins(email, ipAddress string) error
+
+	// Profiles
+	GetUserByUsername(username string) (api.User, error)
+	SetAvatarKey(userID, avatarKey string) (string, error)
+	UpdatePrivacy(userID string, settings api.PrivacySettings) error
+	GetShowcase(userID string) ([]api.Item, error)
+	SetShowcase(userID string, invIDs []int) error
+
+	// Account recovery
+	CreateUserToken(userID, tokenHash, purpose, email string, expiresAt time.Time) error
+	GetUserToken(tokenHash, purpose string) (api.UserToken, error)
+	UseUserToken(tokenHash, purpose string) (api.UserToken, error)
+	UseVerifyEmailToken(tokenHash string) (api.UserToken, error)
+	UpdatePassword(userID, password string) error
+
+	// Profile editing
+	UpdateUsername(userID, username string, recase bool) error
+	UseEmailChangeToken(tokenHash string) (api.UserToken, error)
+
+	// Data export and deletion
+	GetTradeupEntries(userID string) ([]api.TradeupEntry, error)
+	GetCratePurchases(userID string) ([]api.CratePurchase, error)
+	GetBalanceHistory(userID string) ([]api.BalanceChange, error)
+	ScheduleDeletion(userID string, at time.Time) error
+	CancelDeletion(userID string) error
+	GetDueDeletions() ([]string, error)
+	PurgeUser(userID string) (string, error)
+
+	// Two factor
+	GetTOTP(userID string) (api.TOTPState, error)
+	SetPendingTOTP(userID, secret string) error
+	EnableTOTP(userID string, codeHashes []string) error
+	DisableTOTP(userID string) error
+	UseTOTPStep(userID string, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID string, codeHashes []string) error
+	UseRecoveryCode(userID, codeHash string) (bool, error)
 	GetInventory(userID string) (api.Inventory, error)
 	GetRecentTradeups(userID string) ([]api.RecentTradeup, error)
-	GetRecentWinnings(userID string) ([]api.Item, error)
+	GetRecentWinnings(userID string, limit, offset int) ([]api.WonItem, error)
+	GetStats(userID string) (api.Stats, error)
+
+	// Sessions
+	CreateSession(userID, tokenHash, userAgent, ipAddress string, expiresAt time.Time) (api.Session, error)
+	GetSessionByTokenHash(tokenHash string) (api.Session, int, error)
+	GetActiveSessions(userID string) ([]api.Session, error)
+	RotateSession(sessionID, oldHash, newHash, ipAddress string, expiresAt time.Time) error
+	RevokeSession(userID, sessionID string) (bool, error)
+	BumpRefreshTokenVersion(userID string) error
+	IsSessionActive(sessionID string) (bool, error)
 
 	// Store
-	BuyCrate(crateID, userID string, amount int) (float64, []api.Item, error)
+	GetCrates() ([]api.Crate, error)
+	GetCrate(crateID string) (api.Crate, error)
+	BuyCrate(crateID, userID string, rolls []api.CrateRoll, roll api.FairRoll) (api.Money, []api.Item, error)
+	SellItems(userID string, sales []api.ItemSale) (api.Money, error)
 
 	// Tradeups
 	GetAllTradeups() ([]api.Tradeup, error)
@@ -32,6 +93,18 @@ type Storage interface {
 	RemoveSkinFromTradeup(tradeupID, invID string) error
 	MaintainTradeupCount() error
 	GetUserContribution(tradeupID, userID string) (int, error)
+	CancelTradeup(tradeupID string, audit api.AuditEntry) error
+
+	// Admin
+	SearchUsers(query string, limit, offset int) ([]api.User, error)
+	SetRole(userID, role string, audit api.AuditEntry) error
+	AdjustBalance(userID string, delta api.Money, audit api.AuditEntry) (api.Money, error)
+	BanUser(userID, reason string, audit api.AuditEntry) error
+	UnbanUser(userID string, audit api.AuditEntry) error
+	UpdateCrate(crateID string, update *api.CrateUpdate, audit api.AuditEntry) error
+	SetCrateSkins(crateID string, drops api.DropTable, audit api.AuditEntry) error
+	RecordAudit(entry api.AuditEntry) error
+	GetAuditLog(limit, offset int) ([]api.AuditEntry, error)
 
 	// Helpers
 	CheckSkinOwnership(invID, userID string) (bool, error)
@@ -41,8 +114,35 @@ type Storage interface {
 	GetStatus(tradeupID string) (string, error)
 	SetStatus(tradeupID, status string) error
 	GetExpired() ([]api.Tradeup, error)
-	DetermineWinner(tradeupID int) (string, error)
-	GiveNewItem(userID, rarity string, avgFloat float64) (api.Item, error)
+	GetTickets(tradeupID int) ([]api.TradeupTicket, error)
+	GetPrizes(rarity string) (api.DropTable, error)
+	GetCommittedPrizes(tradeupID int) (api.DropTable, error)
+	CompleteTradeup(tradeupID int, winner string, prize api.TradeupPrize, roll api.FairRoll,
+		audit *api.AuditEntry) (api.Item, error)
+	GetParticipants(tradeupID int) ([]string, error)
+	GetSkinWearRange(skinID int) (float64, float64, error)
+
+	// Fairness
+	GetActiveSeed(userID string) (api.FairSeed, error)
+	CreateSeed(userID, serverSeed, serverSeedHash, clientSeed string) error
+	RotateSeed(userID, serverSeed, serverSeedHash, clientSeed string) (api.SeedRotation, error)
+	CreateTradeupSeed(tradeupID int, serverSeed, serverSeedHash string) error
+	RevealTradeupSeed(tradeupID int, clientSeed string) (api.FairSeed, error)
+	GetRoll(rollID int64) (api.FairRoll, api.FairSeed, error)
+	GetRolls(userID string, limit, offset int) ([]api.FairRoll, error)
+
+	// Ledger
+	GetLedgerEntries(userID string, limit, offset int) ([]api.LedgerEntry, error)
+	FindBalanceDrift() ([]api.BalanceDrift, error)
+
+	// Pricing
+	SavePriceImport(list pricing.PriceList) error
+	GetPriceImport() (pricing.PriceList, error)
+	ClearPriceImport() error
+	SaveDailyPrices(snapshots []api.PriceSnapshot) error
+	GetDailyPrices(skinIDs []int, since time.Time) ([]api.PriceSnapshot, error)
+	DeleteDailyPricesBefore(before time.Time) error
+	GetHeldItems(userID string, since time.Time) ([]api.HeldItem, error)
 }
 
 type storage struct {
@@ -54,97 +154,75 @@ func NewStorage(db *pgxpool.Pool, url string) Storage {
 	return &storage{db: db, cdnUrl: url}
 }
 
-func (s *storage) BuyCrate(crateID, userID string, amount int) (float64, []api.Item, error) {
-	var updatedBalance float64
+// Reports a missing row as the catalog's not found error for whatever was
+// being looked up
+func notFound(err error, catalogErr *api.Error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return api.Wrap(catalogErr, err)
+	}
+	return err
+}
+
+// Charges the user cost * amount through the ledger, adds the skins rolled for
+// each crate and records the roll, using up its nonce. Any failure rolls the
+// whole purchase back.
+func (s *storage) BuyCrate(crateID, userID string, rolls []api.CrateRoll, roll api.FairRoll) (api.Money,
+	[]api.Item, error) {
+	var updatedBalance api.Money
 	var addedItems []api.Item
 
 	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
 	if err != nil {
 		return updatedBalance, addedItems, err
 	}
-	defer func() {
-		tx.Commit(context.Background())
-	}()
+	defer tx.Rollback(context.Background())
 
+	var openingID int64
+	var cost api.Money
 	q := `
-	with updated as (
-		update users
-		set balance = balance - ((select cost from crates where id=$1))
-		where id = (select id from users where id=$2)
-		and balance >= ((select cost from crates where id=$1))
-		returning balance
-	) select * from updated
+	insert into crate_openings(user_id,crate_id,amount,cost_cents)
+	values($1,$2,$3,(select cost_cents * $3 from crates where id=$2))
+	returning id, cost_cents
 	`
-	err = tx.QueryRow(context.Background(), q, crateID, userID).Scan(&updatedBalance)
+	err = tx.QueryRow(context.Background(), q, userID, crateID, len(rolls)).Scan(&openingID, &cost)
 	if err != nil {
-		tx.Rollback(context.Background())
-		return updatedBalance, addedItems, errors.New("insufficent funds")
+		return updatedBalance, addedItems, err
 	}
 
-	q = `select skin_id from crate_skins where crate_id=$1 order by random() limit $2`
-	rows, err := tx.Query(context.Background(), q, crateID, amount)
+	updatedBalance, err = post(context.Background(), tx, api.Posting{UserID: userID, Amount: cost.Neg(),
+		Reason: api.ReasonCratePurchase, Reference: strconv.FormatInt(openingID, 10)})
 	if err != nil {
-		tx.Rollback(context.Background())
 		return updatedBalance, addedItems, err
 	}
-	defer rows.Close()
-
-	var skinIDs []int
-	for rows.Next() {
-		var skinID int
-		err := rows.Scan(&skinID)
-		if err != nil {
-			log.Println("Failed scanning skin id")
-			tx.Rollback(context.Background())
-			return updatedBalance, addedItems, err
-		}
-
-		skinIDs = append(skinIDs, skinID)
-	}
-
-	for _, skinID := range skinIDs {
-		var canBeStatTrak, isStatTrak bool
-		q = "select can_be_stattrak from skins where id=$1"
-		err = tx.QueryRow(context.Background(), q, skinID).Scan(&canBeStatTrak)
-
-		if err != nil {
-			log.Println("Failed scanning StatTrak")
-			tx.Rollback(context.Background())
-			return updatedBalance, addedItems, err
-		}
-
-		if canBeStatTrak {
-			isStatTrak = api.IsStatTrak()
-		}
 
-		// generate float value and corresponding wear name
-		floatValue := rand.Float64()
-		wear := api.GetWearFromFloatValue(floatValue)
+	for _, roll := range rolls {
+		wear := api.GetWearFromFloatValue(roll.Float)
 
 		var skin api.Skin
 		var item api.Item
 		var imageKey string
 
-		// add skins for each randomly selected id
+		// add the skin rolled for each crate
 		q = `
 		with item as (
-			insert into inventory(user_id,skin_id,wear_str,wear_num,price,is_stattrak,created_at) 
-			values($1,$2,$3,$4,12.34,$5,now())
+			insert into inventory(user_id,skin_id,wear_str,wear_num,price_cents,is_stattrak,created_at,
+				crate_opening_id)
+			values($1,$2,$3,$4,$6,$5,now(),$7)
 			returning *
-		) select item.id, item.skin_id, item.wear_str, item.wear_num, item.price, 
+		) select item.id, item.skin_id, item.wear_str, item.wear_num, item.price_cents, 
 			item.is_stattrak, item.was_won, item.created_at, item.visible, s.name, 
 			s.rarity, s.collection, s.image_key
 		from item
 		join skins s on s.id = item.skin_id
 		`
-		row := tx.QueryRow(context.Background(), q, userID, skinID, wear, floatValue, isStatTrak)
+		row := tx.QueryRow(context.Background(), q, userID, roll.SkinID, wear, roll.Float,
+			roll.IsStatTrak, roll.Price, openingID)
 		err = row.Scan(&item.InvID, &skin.ID, &skin.Wear, &skin.Float, &skin.Price,
 			&skin.IsStatTrak, &skin.WasWon, &skin.CreatedAt, &item.Visible, &skin.Name,
 			&skin.Rarity, &skin.Collection, &imageKey)
 
 		if err != nil {
 			log.Println("Failed scanning item")
-			tx.Rollback(context.Background())
 			return updatedBalance, addedItems, err
 		}
 
@@ -153,7 +231,69 @@ func (s *storage) BuyCrate(crateID, userID string, amount int) (float64, []api.I
 		addedItems = append(addedItems, item)
 	}
 
-	return updatedBalance, addedItems, nil
+	err = recordRoll(context.Background(), tx, roll)
+	if err != nil {
+		return updatedBalance, addedItems, err
+	}
+
+	return updatedBalance, addedItems, tx.Commit(context.Background())
+}
+
+// Takes the items out of the inventory and pays for each with its own ledger
+// posting, all or nothing. An item that's gone into a tradeup or been used
+// since it was priced fails the whole sale with ErrItemUnavailable.
+func (s *storage) SellItems(userID string, sales []api.ItemSale) (api.Money, error) {
+	var balance api.Money
+
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return balance, err
+	}
+	defer tx.Rollback(context.Background())
+
+	for _, sale := range sales {
+		q := `
+		update inventory set was_used=true, was_sold=true, removed_at=now()
+		where id=$1 and user_id=$2 and visible and not was_used
+			and not exists (
+				select 1 from tradeups_skins ts
+				join tradeups t on t.id = ts.tradeup_id
+				where ts.inv_id = inventory.id and t.current_status in ('Active', 'Waiting')
+			)
+		`
+		tag, err := tx.Exec(context.Background(), q, sale.InvID, userID)
+		if err != nil {
+			return balance, err
+		}
+		if tag.RowsAffected() == 0 {
+			return balance, api.ErrItemUnavailable
+		}
+
+		balance, err = post(context.Background(), tx, api.Posting{UserID: userID, Amount: sale.Price,
+			Reason: api.ReasonSale, Reference: strconv.Itoa(sale.InvID)})
+		if err != nil {
+			return balance, err
+		}
+	}
+
+	return balance, tx.Commit(context.Background())
+}
+
+// Stored for users who never set an avatar
+const defaultAvatarKey = "none"
+
+// Avatars live on the same CDN as skin images under avatars/. Steam avatars
+// are stored as full urls and passed through as is.
+func (s *storage) createAvatarSrc(avatarKey string) string {
+	if avatarKey == "" || avatarKey == defaultAvatarKey {
+		return s.cdnUrl + "avatars/default.png"
+	}
+
+	if strings.HasPrefix(avatarKey, "https://") || strings.HasPrefix(avatarKey, "http://") {
+		return avatarKey
+	}
+
+	return s.cdnUrl + "avatars/" + avatarKey
 }
 
 // url + guns/ak/imageKey
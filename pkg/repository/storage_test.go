@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+)
+
+func TestCreateAvatarSrc(t *testing.T) {
+	s := &storage{cdnUrl: "https://cdn.test/"}
+
+	cases := map[string]string{
+		"":                                     "https://cdn.test/avatars/default.png",
+		"none":                                 "https://cdn.test/avatars/default.png",
+		"user-1.png":                           "https://cdn.test/avatars/user-1.png",
+		"https://avatars.steam.test/gaben.jpg": "https://avatars.steam.test/gaben.jpg",
+	}
+
+	for key, expected := range cases {
+		if got := s.createAvatarSrc(key); got != expected {
+			t.Errorf("%q: expected %s, got %s", key, expected, got)
+		}
+	}
+}
+
+func TestAddCrateStats(t *testing.T) {
+	byRarity := []api.RarityStats{{Rarity: "Classified", Entered: 2}, {Rarity: "Restricted", Entered: 1}}
+
+	byRarity = addCrateStats(byRarity, "Covert", 1, api.Cents(250))
+	byRarity = addCrateStats(byRarity, "Restricted", 3, api.Cents(750))
+
+	want := []api.RarityStats{
+		{Rarity: "Classified", Entered: 2},
+		{Rarity: "Covert", CratePulls: 1, CrateSpend: api.Cents(250)},
+		{Rarity: "Restricted", Entered: 1, CratePulls: 3, CrateSpend: api.Cents(750)},
+	}
+	if len(byRarity) != len(want) {
+		t.Fatalf("expected %d rarities, got %+v", len(want), byRarity)
+	}
+	for i := range want {
+		if byRarity[i] != want[i] {
+			t.Errorf("rarity %d: expected %+v, got %+v", i, want[i], byRarity[i])
+		}
+	}
+}
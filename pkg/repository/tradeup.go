@@ -2,9 +2,10 @@ package repository
 
 import (
 	"context"
-	"math/rand/v2"
+	"errors"
 
 	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/erobx/csupgrade-go-api/pkg/fairness"
 	"github.com/jackc/pgx/v5"
 )
 
@@ -12,7 +13,7 @@ func (s *storage) GetAllTradeups() ([]api.Tradeup, error) {
 	var tradeups []api.Tradeup
 	var ids []string
 
-	q := `select id from tradeups where current_status <> 'Completed'`
+	q := `select id from tradeups where current_status not in ('Completed', 'Cancelled')`
 	rows, err := s.db.Query(context.Background(), q)
 	if err != nil {
 		return tradeups, nil
@@ -52,13 +53,18 @@ func (s *storage) GetTradeupByID(tradeupID string) (api.Tradeup, error) {
 	}()
 
 	q := `
-	select id, rarity, current_status, stop_time, mode from tradeups where id=$1
+	select t.id, t.rarity, t.current_status, t.stop_time, t.mode, coalesce(f.server_seed_hash, ''),
+		coalesce(f.prizes, '[]')
+	from tradeups t
+	left join fair_seeds f on f.tradeup_id = t.id
+	where t.id=$1
 	`
 	err = s.db.QueryRow(context.Background(), q, tradeupID).Scan(&tradeup.ID,
-		&tradeup.Rarity, &tradeup.Status, &tradeup.StopTime, &tradeup.Mode)
+		&tradeup.Rarity, &tradeup.Status, &tradeup.StopTime, &tradeup.Mode, &tradeup.ServerSeedHash,
+		&tradeup.Prizes)
 	if err != nil {
 		tx.Rollback(context.Background())
-		return tradeup, err
+		return tradeup, notFound(err, api.ErrTradeupNotFound)
 	}
 
 	//if winner.Valid {
@@ -70,7 +76,7 @@ func (s *storage) GetTradeupByID(tradeupID string) (api.Tradeup, error) {
 	items := make([]api.Item, 0)
 	players := make(map[string]api.Player, 0)
 	q = `
-	select i.id, i.skin_id, i.wear_str, i.wear_num, i.price, i.is_stattrak, 
+	select i.id, i.skin_id, i.wear_str, i.wear_num, i.price_cents, i.is_stattrak, 
 		u.username, u.avatar_key, s.name, s.rarity, s.collection, s.image_key
 	from tradeups_skins ts
 	join inventory i on i.id = ts.inv_id
@@ -101,7 +107,7 @@ func (s *storage) GetTradeupByID(tradeupID string) (api.Tradeup, error) {
 		}
 
 		if _, ok := players[player.Username]; !ok {
-			player.AvatarSrc = avatarKey
+			player.AvatarSrc = s.createAvatarSrc(avatarKey)
 			players[player.Username] = player
 		}
 
@@ -133,56 +139,69 @@ func (s *storage) IsTradeupFull(tradeupID string) (bool, error) {
 	return false, nil
 }
 
+// Puts the item in the tradeup. The tradeup is locked while the item goes in
+// so it can't be completed or cancelled with the item halfway there.
 func (s *storage) AddSkinToTradeup(tradeupID, invID string) error {
 	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
 	if err != nil {
 		return err
 	}
-	defer func() {
-		tx.Commit(context.Background())
-	}()
+	defer tx.Rollback(context.Background())
+
+	var status string
+	q := "select current_status from tradeups where id=$1 for update"
+	err = tx.QueryRow(context.Background(), q, tradeupID).Scan(&status)
+	if err != nil {
+		return notFound(err, api.ErrTradeupNotFound)
+	}
+	if status != "Active" && status != "Waiting" {
+		return api.ErrTradeupLocked
+	}
 
-	q := "insert into tradeups_skins values($1,$2)"
+	q = "insert into tradeups_skins values($1,$2)"
 	_, err = tx.Exec(context.Background(), q, tradeupID, invID)
 	if err != nil {
-		tx.Rollback(context.Background())
 		return err
 	}
 
-	q = "update inventory set visible=false where id=$1"
-	_, err = tx.Exec(context.Background(), q, invID)
+	// sold and used items can't go in
+	q = "update inventory set visible=false where id=$1 and visible and not was_used"
+	tag, err := tx.Exec(context.Background(), q, invID)
 	if err != nil {
-		tx.Rollback(context.Background())
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		return api.ErrItemUnavailable
+	}
 
-	return nil
+	return tx.Commit(context.Background())
 }
 
+// Takes the item out of the tradeup and back into the inventory. Items that
+// aren't in this tradeup stay where they are.
 func (s *storage) RemoveSkinFromTradeup(tradeupID, invID string) error {
 	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
 	if err != nil {
 		return err
 	}
-	defer func() {
-		tx.Commit(context.Background())
-	}()
+	defer tx.Rollback(context.Background())
 
 	q := "delete from tradeups_skins where tradeup_id=$1 and inv_id=$2"
-	_, err = tx.Exec(context.Background(), q, tradeupID, invID)
+	tag, err := tx.Exec(context.Background(), q, tradeupID, invID)
 	if err != nil {
-		tx.Rollback(context.Background())
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		return api.ErrItemUnavailable
+	}
 
 	q = "update inventory set visible=true where id=$1"
 	_, err = tx.Exec(context.Background(), q, invID)
 	if err != nil {
-		tx.Rollback(context.Background())
 		return err
 	}
 
-	return nil
+	return tx.Commit(context.Background())
 }
 
 func (s *storage) GetUserContribution(tradeupID, userID string) (int, error) {
@@ -220,7 +239,7 @@ func (s *storage) GetStatus(tradeupID string) (string, error) {
 	var status string
 	q := "select current_status from tradeups where id=$1"
 	err := s.db.QueryRow(context.Background(), q, tradeupID).Scan(&status)
-	return status, err
+	return status, notFound(err, api.ErrTradeupNotFound)
 }
 
 func (s *storage) SetStatus(tradeupID, status string) error {
@@ -264,141 +283,194 @@ func (s *storage) GetExpired() ([]api.Tradeup, error) {
 	return expired, nil
 }
 
-// Determines the winner for a specific tradeup and marks inventory items in
-// the tradeup as used
-func (s *storage) DetermineWinner(tradeupID int) (string, error) {
-	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
-	if err != nil {
-		return "", err
-	}
-	defer func() {
-		tx.Commit(context.Background())
-	}()
+// One ticket per item in the tradeup, in the order they're rolled from
+func (s *storage) GetTickets(tradeupID int) ([]api.TradeupTicket, error) {
+	var tickets []api.TradeupTicket
 
 	q := `
-	select distinct i.user_id from tradeups_skins ts 
+	select ts.inv_id, i.user_id from tradeups_skins ts
 	join inventory i on i.id = ts.inv_id
 	where ts.tradeup_id = $1
+	order by ts.inv_id
 	`
-	rows, err := tx.Query(context.Background(), q, tradeupID)
+	rows, err := s.db.Query(context.Background(), q, tradeupID)
 	if err != nil {
-		return "", err
+		return tickets, err
 	}
 	defer rows.Close()
 
-	var playerIDs []string
 	for rows.Next() {
-		var userID string
-		err := rows.Scan(&userID)
-		if err != nil {
-			return "", err
+		var ticket api.TradeupTicket
+		if err := rows.Scan(&ticket.InvID, &ticket.UserID); err != nil {
+			return tickets, err
 		}
-		playerIDs = append(playerIDs, userID)
+		tickets = append(tickets, ticket)
 	}
 
-	playerWeights := make(map[string]int)
+	return tickets, rows.Err()
+}
 
-	for _, id := range playerIDs {
-		var weight int
-		q := `
-		select count(ts.inv_id) as skin_count
-		from tradeups_skins ts
-		join inventory i on ts.inv_id = i.id
-		where ts.tradeup_id = $1
-		  and i.user_id = $2
-		`
-		err := tx.QueryRow(context.Background(), q, tradeupID, id).Scan(&weight)
-		if err != nil {
-			return "", err
+// Every skin of the rarity, equally likely
+func (s *storage) GetPrizes(rarity string) (api.DropTable, error) {
+	var prizes api.DropTable
+
+	q := "select id, rarity, can_be_stattrak from skins where rarity=$1 order by id"
+	rows, err := s.db.Query(context.Background(), q, rarity)
+	if err != nil {
+		return prizes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		drop := api.Drop{Weight: 1}
+		if err := rows.Scan(&drop.SkinID, &drop.Rarity, &drop.CanBeStatTrak); err != nil {
+			return prizes, err
 		}
+		prizes = append(prizes, drop)
+	}
+
+	return prizes, rows.Err()
+}
 
-		playerWeights[id] = weight
+// The prizes fixed when the tradeup's seed was committed, empty for tradeups
+// from before they were
+func (s *storage) GetCommittedPrizes(tradeupID int) (api.DropTable, error) {
+	var prizes api.DropTable
+
+	q := "select prizes from fair_seeds where tradeup_id=$1 and prizes is not null"
+	err := s.db.QueryRow(context.Background(), q, tradeupID).Scan(&prizes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return prizes, nil
 	}
+	return prizes, err
+}
 
-	var winner string
-	randomNum := rand.IntN(100)
-	currWeight := 0
+// Records the winner, marks inventory items in the tradeup as used, gives the
+// winner their skin and saves the roll, all or nothing. A tradeup that's
+// already completed or cancelled is ErrTradeupClosed. audit is written along
+// with it when staff forced the completion.
+func (s *storage) CompleteTradeup(tradeupID int, winner string, prize api.TradeupPrize,
+	roll api.FairRoll, audit *api.AuditEntry) (api.Item, error) {
+	var item api.Item
+	var skin api.Skin
+	var imageKey string
 
-	for player, weight := range playerWeights {
-		currWeight += weight * 10
-		if randomNum < currWeight {
-			winner = player
-			break
-		}
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return item, err
 	}
+	defer tx.Rollback(context.Background())
 
-	q = `update tradeups set current_status='Completed', winner=$1 where id=$2`
-	_, err = tx.Exec(context.Background(), q, winner, tradeupID)
+	q := `
+	update tradeups set current_status='Completed', winner=$1
+	where id=$2 and current_status not in ('Completed', 'Cancelled')
+	`
+	tag, err := tx.Exec(context.Background(), q, winner, tradeupID)
 	if err != nil {
-		tx.Rollback(context.Background())
-		return "", err
+		return item, err
+	}
+	if tag.RowsAffected() == 0 {
+		return item, s.closedOrMissing(tx, tradeupID)
 	}
 
 	q = `
 	update inventory
-	set was_used = true
+	set was_used = true, removed_at = now()
 	from tradeups_skins
 	where tradeups_skins.inv_id = inventory.id
 		and tradeups_skins.tradeup_id = $1
 	`
 	_, err = tx.Exec(context.Background(), q, tradeupID)
 	if err != nil {
-		tx.Rollback(context.Background())
-		return "", err
+		return item, err
 	}
 
-	return winner, nil
-}
-
-// Gives user a new item of the requested rarity
-func (s *storage) GiveNewItem(userID, rarity string, avgFloat float64) (api.Item, error) {
-	var item api.Item
-	var skin api.Skin
-	var wearMin, wearMax float64
-	var canBeStatTrak bool
-	var imageKey string
-
-	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	q = "select id,name,rarity,collection,image_key from skins where id=$1"
+	err = tx.QueryRow(context.Background(), q, prize.SkinID).Scan(&skin.ID, &skin.Name,
+		&skin.Rarity, &skin.Collection, &imageKey)
 	if err != nil {
 		return item, err
 	}
-	defer func() {
-		tx.Commit(context.Background())
-	}()
 
-	q := "select id,name,rarity,collection,wear_min,wear_max,can_be_stattrak,image_key from skins where rarity=$1 order by random() limit 1"
-	err = tx.QueryRow(context.Background(), q, rarity).Scan(&skin.ID, &skin.Name,
-		&skin.Rarity, &skin.Collection, &wearMin, &wearMax, &canBeStatTrak, &imageKey)
+	q = `
+	insert into inventory(user_id, skin_id, wear_str, wear_num, price_cents, is_stattrak, was_won,
+		won_from_tradeup, won_avg_float)
+	values ($1,$2,$3,$4,$8,$5,true,$6,$7)
+	returning id,wear_str,wear_num,price_cents,is_stattrak,was_won,created_at
+	`
+	err = tx.QueryRow(context.Background(), q, winner, skin.ID, api.GetWearNameFromFloat(prize.Float),
+		prize.Float, prize.IsStatTrak, tradeupID, prize.AvgFloat, prize.Price).Scan(&item.InvID,
+		&skin.Wear, &skin.Float, &skin.Price, &skin.IsStatTrak, &skin.WasWon, &skin.CreatedAt)
 	if err != nil {
 		return item, err
 	}
 
-	wearNum := ((wearMax - wearMin) * avgFloat) + wearMin
-	wearStr := api.GetWearNameFromFloat(wearNum)
-	isStatTrak := false
-	if canBeStatTrak {
-		isStatTrak = api.IsStatTrak()
-	}
-
-	q = `
-    insert into inventory(user_id, skin_id, wear_str, wear_num, price, is_stattrak, was_won)
-	values ($1,$2,$3,$4,12.34,$5,true) 
-	returning id,wear_str,wear_num,price,is_stattrak,was_won,created_at
-    `
-
-	err = tx.QueryRow(context.Background(), q, userID, skin.ID, wearStr, wearNum,
-		isStatTrak).Scan(&item.InvID, &skin.Wear, &skin.Float, &skin.Price, &skin.IsStatTrak,
-		&skin.WasWon, &skin.CreatedAt)
+	err = recordRoll(context.Background(), tx, roll)
 	if err != nil {
-		tx.Rollback(context.Background())
 		return item, err
 	}
 
+	if audit != nil {
+		err = recordAudit(context.Background(), tx, *audit)
+		if err != nil {
+			return item, err
+		}
+	}
+
 	skin.ImgSrc = s.createImgSrc(imageKey)
 	item.Data = skin
 	item.Visible = true
 
-	return item, nil
+	return item, tx.Commit(context.Background())
+}
+
+// Why a status change matched no tradeup
+func (s *storage) closedOrMissing(tx pgx.Tx, tradeupID any) error {
+	var exists bool
+	q := "select exists(select 1 from tradeups where id=$1)"
+	if err := tx.QueryRow(context.Background(), q, tradeupID).Scan(&exists); err != nil {
+		return err
+	}
+
+	if !exists {
+		return api.ErrTradeupNotFound
+	}
+	return api.ErrTradeupClosed
+}
+
+// Every user with an item in the tradeup
+func (s *storage) GetParticipants(tradeupID int) ([]string, error) {
+	var userIDs []string
+
+	q := `
+	select distinct i.user_id from tradeups_skins ts
+	join inventory i on i.id = ts.inv_id
+	where ts.tradeup_id = $1
+	`
+	rows, err := s.db.Query(context.Background(), q, tradeupID)
+	if err != nil {
+		return userIDs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return userIDs, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+// The lowest and highest float the skin comes in
+func (s *storage) GetSkinWearRange(skinID int) (float64, float64, error) {
+	var wearMin, wearMax float64
+
+	q := "select wear_min, wear_max from skins where id=$1"
+	err := s.db.QueryRow(context.Background(), q, skinID).Scan(&wearMin, &wearMax)
+	return wearMin, wearMax, err
 }
 
 func (s *storage) MaintainTradeupCount() error {
@@ -416,8 +488,34 @@ func (s *storage) MaintainTradeupCount() error {
 		}
 
 		if count < 5 {
-			q := "insert into tradeups(rarity) values($1)"
-			_, err := tx.Exec(context.Background(), q, r)
+			var tradeupID int
+			q := "insert into tradeups(rarity) values($1) returning id"
+			err := tx.QueryRow(context.Background(), q, r).Scan(&tradeupID)
+			if err != nil {
+				tx.Rollback(context.Background())
+				return err
+			}
+
+			// commit to the winner roll and what it can give out before
+			// anyone can join
+			serverSeed, err := fairness.NewServerSeed()
+			if err != nil {
+				tx.Rollback(context.Background())
+				return err
+			}
+
+			prizes, err := s.GetPrizes(api.GetNextRarity(r))
+			if err != nil {
+				tx.Rollback(context.Background())
+				return err
+			}
+
+			q = `
+			insert into fair_seeds(tradeup_id, server_seed, server_seed_hash, client_seed, prizes)
+			values($1,$2,$3,'',$4)
+			`
+			_, err = tx.Exec(context.Background(), q, tradeupID, serverSeed,
+				fairness.HashSeed(serverSeed), prizes)
 			if err != nil {
 				tx.Rollback(context.Background())
 				return err
@@ -428,3 +526,52 @@ func (s *storage) MaintainTradeupCount() error {
 	tx.Commit(context.Background())
 	return nil
 }
+
+// Gives every item back to its owner and closes the tradeup without a winner.
+// A tradeup that's already completed or cancelled is ErrTradeupClosed.
+func (s *storage) CancelTradeup(tradeupID string, audit api.AuditEntry) error {
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	// closing it first locks the row, so a completion can't start on it
+	// while the items go back
+	q := `
+	update tradeups set current_status='Cancelled'
+	where id=$1 and current_status not in ('Completed', 'Cancelled')
+	`
+	tag, err := tx.Exec(context.Background(), q, tradeupID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return s.closedOrMissing(tx, tradeupID)
+	}
+
+	q = `
+	update inventory
+	set visible = true
+	from tradeups_skins
+	where tradeups_skins.inv_id = inventory.id
+		and tradeups_skins.tradeup_id = $1
+	`
+	_, err = tx.Exec(context.Background(), q, tradeupID)
+	if err != nil {
+		return err
+	}
+
+	q = "delete from tradeups_skins where tradeup_id=$1"
+	_, err = tx.Exec(context.Background(), q, tradeupID)
+	if err != nil {
+		return err
+	}
+
+	err = recordAudit(context.Background(), tx, audit)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
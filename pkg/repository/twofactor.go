@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *storage) GetTOTP(userID string) (api.TOTPState, error) {
+	var state api.TOTPState
+
+	q := "select coalesce(totp_secret, ''), totp_enabled from users where id=$1"
+	err := s.db.QueryRow(context.Background(), q, userID).Scan(&state.Secret, &state.Enabled)
+
+	return state, err
+}
+
+// Stores a secret awaiting confirmation, never touches an enabled secret
+func (s *storage) SetPendingTOTP(userID, secret string) error {
+	q := `
+	update users set totp_secret=$1, totp_last_step=0
+	where id=$2 and totp_enabled=false
+	`
+	_, err := s.db.Exec(context.Background(), q, secret, userID)
+	return err
+}
+
+func (s *storage) EnableTOTP(userID string, codeHashes []string) error {
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	q := "update users set totp_enabled=true where id=$1"
+	_, err = tx.Exec(context.Background(), q, userID)
+	if err != nil {
+		return err
+	}
+
+	err = replaceRecoveryCodes(tx, userID, codeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+func (s *storage) DisableTOTP(userID string) error {
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	q := "update users set totp_enabled=false, totp_secret=null, totp_last_step=0 where id=$1"
+	_, err = tx.Exec(context.Background(), q, userID)
+	if err != nil {
+		return err
+	}
+
+	q = "delete from user_recovery_codes where user_id=$1"
+	_, err = tx.Exec(context.Background(), q, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// Records the step of an accepted code. Returns false if that step (or a
+// later one) was already used.
+func (s *storage) UseTOTPStep(userID string, step int64) (bool, error) {
+	q := "update users set totp_last_step=$1 where id=$2 and totp_last_step < $1"
+	tag, err := s.db.Exec(context.Background(), q, step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (s *storage) ReplaceRecoveryCodes(userID string, codeHashes []string) error {
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	err = replaceRecoveryCodes(tx, userID, codeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+func (s *storage) UseRecoveryCode(userID, codeHash string) (bool, error) {
+	q := `
+	update user_recovery_codes set used_at=now()
+	where user_id=$1 and code_hash=$2 and used_at is null
+	`
+	tag, err := s.db.Exec(context.Background(), q, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func replaceRecoveryCodes(tx pgx.Tx, userID string, codeHashes []string) error {
+	q := "delete from user_recovery_codes where user_id=$1"
+	_, err := tx.Exec(context.Background(), q, userID)
+	if err != nil {
+		return err
+	}
+
+	q = "insert into user_recovery_codes(user_id,code_hash) select $1, unnest($2::text[])"
+	_, err = tx.Exec(context.Background(), q, userID, codeHashes)
+	return err
+}
@@ -3,9 +3,12 @@ package repository
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"time"
 
 	"github.com/erobx/csupgrade-go-api/pkg/api"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -18,40 +21,155 @@ func (s *storage) CreateUser(request *api.NewUserRequest) (string, error) {
 	}
 
 	q := "insert into users(id,username,email,hash,avatar_key,created_at) values($1,$2,$3,$4,$5,now())"
-	_, err = s.db.Exec(context.Background(), q, id, request.Username, request.Email, string(hashed), "none")
+	_, err = s.db.Exec(context.Background(), q, id, request.Username, request.Email, string(hashed),
+		defaultAvatarKey)
+	if err != nil {
+		return "", userConflict(err)
+	}
 
-	return id, err
+	return id, nil
 }
 
-func (s *storage) GetUserByID(userID string) (api.User, error) {
+// Columns scanned by scanUser, hash is selected separately where needed
+const userColumns = `id, username, coalesce(email, ''), email_verified, balance_cents, refresh_token_version,
+	avatar_key, coalesce(steam_id, ''), coalesce(steam_persona, ''), totp_enabled, hide_inventory,
+	hide_stats, role, banned_at, coalesce(ban_reason, ''), username_changed_at, delete_after, created_at`
+
+func (s *storage) scanUser(row pgx.Row, dest ...any) (api.User, error) {
 	var user api.User
 	var avatarKey string
 
-	q := "select id,username,email,balance,refresh_token_version,avatar_key,created_at from users where id=$1"
-	row := s.db.QueryRow(context.Background(), q, userID)
-	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.Balance,
-		&user.RefreshTokenVersion, &avatarKey, &user.CreatedAt)
+	fields := []any{&user.ID, &user.Username, &user.Email, &user.EmailVerified, &user.Balance,
+		&user.RefreshTokenVersion, &avatarKey, &user.SteamID, &user.SteamPersona,
+		&user.TwoFactorEnabled, &user.Privacy.HideInventory, &user.Privacy.HideStats,
+		&user.Role, &user.BannedAt, &user.BanReason, &user.UsernameChangedAt, &user.DeleteAfter, &user.CreatedAt}
+	err := row.Scan(append(fields, dest...)...)
+	user.AvatarSrc = s.createAvatarSrc(avatarKey)
 
-	// TODO: generate url for avatarKey, then assign to user.AvatarSrc
+	return user, notFound(err, api.ErrUserNotFound)
+}
 
-	return user, err
+func (s *storage) GetUserByID(userID string) (api.User, error) {
+	q := "select " + userColumns + " from users where id=$1"
+	return s.scanUser(s.db.QueryRow(context.Background(), q, userID))
 }
 
 func (s *storage) GetUserAndHashByEmail(email string) (api.User, string, error) {
-	var user api.User
 	var hash string
-	var avatarKey string
 
-	q := "select * from users where email=$1"
-	row := s.db.QueryRow(context.Background(), q, email)
-	err := row.Scan(&user.ID, &user.Username, &user.Email, &hash, &user.Balance,
-		&user.RefreshTokenVersion, &avatarKey, &user.CreatedAt)
+	q := "select " + userColumns + ", coalesce(hash, '') from users where email=$1"
+	user, err := s.scanUser(s.db.QueryRow(context.Background(), q, email), &hash)
 
-	// TODO: generate url for avatarKey, then assign to user.AvatarSrc
+	return user, hash, err
+}
+
+func (s *storage) GetUserAndHashByID(userID string) (api.User, string, error) {
+	var hash string
+
+	q := "select " + userColumns + ", coalesce(hash, '') from users where id=$1"
+	user, err := s.scanUser(s.db.QueryRow(context.Background(), q, userID), &hash)
 
 	return user, hash, err
 }
 
+func (s *storage) GetUserBySteamID(steamID string) (api.User, error) {
+	q := "select " + userColumns + " from users where steam_id=$1"
+	return s.scanUser(s.db.QueryRow(context.Background(), q, steamID))
+}
+
+// Creates a user without email or password, keyed by their SteamID64
+func (s *storage) CreateSteamUser(profile api.SteamProfile) (string, error) {
+	id := uuid.New().String()
+
+	// personas aren't unique and allow anything, fall back to the SteamID when
+	// the persona wouldn't pass as a username
+	fallback := "steam_" + profile.SteamID
+	username := api.NormalizeUsername(profile.PersonaName)
+	if api.ValidateUsername(username) != nil {
+		username = fallback
+	}
+
+	avatarKey := profile.AvatarURL
+	if avatarKey == "" {
+		avatarKey = defaultAvatarKey
+	}
+
+	q := `
+	insert into users(id,username,steam_id,steam_persona,avatar_key,created_at)
+	values($1,$2,$3,$4,$5,now())
+	`
+	_, err := s.db.Exec(context.Background(), q, id, username, profile.SteamID,
+		profile.PersonaName, avatarKey)
+	if userConflict(err) == api.ErrUsernameTaken && username != fallback {
+		_, err = s.db.Exec(context.Background(), q, id, fallback, profile.SteamID,
+			profile.PersonaName, avatarKey)
+	}
+
+	return id, err
+}
+
+// Links a Steam account and takes over its persona and avatar
+func (s *storage) LinkSteam(userID string, profile api.SteamProfile) error {
+	q := `
+	update users
+	set steam_id=$1, steam_persona=$2,
+		avatar_key=case when $3 = '' then avatar_key else $3 end
+	where id=$4
+	`
+	_, err := s.db.Exec(context.Background(), q, profile.SteamID, profile.PersonaName,
+		profile.AvatarURL, userID)
+	return err
+}
+
+func (s *storage) UnlinkSteam(userID string) error {
+	q := "update users set steam_id=null, steam_persona=null where id=$1"
+	_, err := s.db.Exec(context.Background(), q, userID)
+	return err
+}
+
+func (s *storage) HasPassword(userID string) (bool, error) {
+	var hasPassword bool
+	q := "select hash is not null and hash <> '' from users where id=$1"
+	err := s.db.QueryRow(context.Background(), q, userID).Scan(&hasPassword)
+	return hasPassword, err
+}
+
+// When logins for the email from the IP are locked until, zero if they aren't
+func (s *storage) GetLoginLock(email, ipAddress string) (time.Time, error) {
+	var lockedUntil *time.Time
+	q := "select locked_until from login_failures where email=$1 and ip_address=$2"
+	err := s.db.QueryRow(context.Background(), q, email, ipAddress).Scan(&lockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) || lockedUntil == nil {
+		return time.Time{}, nil
+	}
+	return *lockedUntil, err
+}
+
+// Counts a failed login and returns the new total
+func (s *storage) RecordFailedLogin(email, ipAddress string) (int, error) {
+	var failures int
+	q := `
+	insert into login_failures (email, ip_address, failures) values ($1, $2, 1)
+	on conflict (email, ip_address) do update
+	set failures = login_failures.failures + 1, last_failed_at = now()
+	returning failures
+	`
+	err := s.db.QueryRow(context.Background(), q, email, ipAddress).Scan(&failures)
+	return failures, err
+}
+
+func (s *storage) LockLogin(email, ipAddress string, until time.Time) error {
+	q := "update login_failures set locked_until=$1 where email=$2 and ip_address=$3"
+	_, err := s.db.Exec(context.Background(), q, until, email, ipAddress)
+	return err
+}
+
+func (s *storage) ResetFailedLogins(email, ipAddress string) error {
+	q := "delete from login_failures where email=$1 and ip_address=$2"
+	_, err := s.db.Exec(context.Background(), q, email, ipAddress)
+	return err
+}
+
 func (s *storage) GetInventory(userID string) (api.Inventory, error) {
 	inventory := api.Inventory{
 		UserID: userID,
@@ -59,7 +177,7 @@ func (s *storage) GetInventory(userID string) (api.Inventory, error) {
 	}
 
 	q := `
-	select i.id, i.skin_id, i.wear_str, i.wear_num, i.price, i.is_stattrak,
+	select i.id, i.skin_id, i.wear_str, i.wear_num, i.price_cents, i.is_stattrak,
 		i.was_won, i.created_at, i.visible, s.name, s.rarity, s.collection, s.image_key
 	from inventory i
 	join skins s on s.id = i.skin_id
@@ -116,7 +234,7 @@ func (s *storage) GetRecentTradeups(userID string) ([]api.RecentTradeup, error)
 				'data', JSONB_BUILD_OBJECT(
 					'name', s.name,
 					'wear', i.wear_str,
-					'price', i.price
+					'price', jsonb_build_object('cents', i.price_cents, 'currency', 'USD')
 				),
 				'visible', true
 			)
@@ -162,8 +280,52 @@ func (s *storage) GetRecentTradeups(userID string) ([]api.RecentTradeup, error)
 	return recentTradeups, nil
 }
 
-func (s *storage) GetRecentWinnings(userID string) ([]api.Item, error) {
-	var winnings []api.Item
+// Won items newest first. Input floats come from the items that went into
+// the tradeup, they stay linked to it after it completes.
+func (s *storage) GetRecentWinnings(userID string, limit, offset int) ([]api.WonItem, error) {
+	winnings := make([]api.WonItem, 0)
+
+	q := `
+	select i.id, i.skin_id, i.wear_str, i.wear_num, i.price_cents, i.is_stattrak,
+		i.was_won, i.created_at, i.visible, s.name, s.rarity, s.collection, s.image_key,
+		coalesce(t.id, 0), coalesce(t.rarity, ''), coalesce(i.won_avg_float, 0),
+		coalesce((
+			select array_agg(inp.wear_num order by inp.wear_num)
+			from tradeups_skins ts
+			join inventory inp on inp.id = ts.inv_id
+			where ts.tradeup_id = t.id
+		), '{}')
+	from inventory i
+	join skins s on s.id = i.skin_id
+	left join tradeups t on t.id = i.won_from_tradeup
+	where i.user_id = $1 and i.was_won = true
+	order by i.created_at desc, i.id desc
+	limit $2 offset $3
+	`
+	rows, err := s.db.Query(context.Background(), q, userID, limit, offset)
+	if err != nil {
+		return winnings, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var won api.WonItem
+		var skin api.Skin
+		var imageKey string
+
+		err := rows.Scan(&won.Item.InvID, &skin.ID, &skin.Wear, &skin.Float, &skin.Price,
+			&skin.IsStatTrak, &skin.WasWon, &skin.CreatedAt, &won.Item.Visible, &skin.Name,
+			&skin.Rarity, &skin.Collection, &imageKey, &won.TradeupID, &won.Rarity,
+			&won.AvgFloat, &won.InputFloats)
+		if err != nil {
+			return winnings, err
+		}
+
+		skin.ImgSrc = s.createImgSrc(imageKey)
+		won.Item.Data = skin
+		won.WonAt = skin.CreatedAt
+		winnings = append(winnings, won)
+	}
 
-	return winnings, nil
+	return winnings, rows.Err()
 }
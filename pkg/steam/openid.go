@@ -0,0 +1,210 @@
+package steam
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/erobx/csupgrade-go-api/pkg/api"
+)
+
+const (
+	DefaultProviderURL = "https://steamcommunity.com/openid/login"
+	DefaultAPIURL      = "https://api.steampowered.com"
+
+	openIDNS         = "http://specs.openid.net/auth/2.0"
+	identifierSelect = "http://specs.openid.net/auth/2.0/identifier_select"
+
+	// Assertions older than this are rejected even if the provider vouches
+	// for them
+	maxNonceAge = 5 * time.Minute
+)
+
+var (
+	ErrInvalidAssertion = errors.New("invalid steam openid assertion")
+
+	claimedIDPattern = regexp.MustCompile(`^https://steamcommunity\.com/openid/id/(\d{17})$`)
+)
+
+// OpenID 2.0 relying party for Steam. The provider and Web API endpoints are
+// configurable so a local stub can stand in for Steam.
+type Client struct {
+	providerURL string
+	apiURL      string
+	apiKey      string
+	returnTo    string
+	realm       string
+	http        *http.Client
+}
+
+func NewClient(providerURL, apiURL, apiKey, returnTo string) (*Client, error) {
+	u, err := url.Parse(returnTo)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid steam return url %q", returnTo)
+	}
+
+	if providerURL == "" {
+		providerURL = DefaultProviderURL
+	}
+
+	if apiURL == "" {
+		apiURL = DefaultAPIURL
+	}
+
+	return &Client{
+		providerURL: providerURL,
+		apiURL:      strings.TrimSuffix(apiURL, "/"),
+		apiKey:      apiKey,
+		returnTo:    returnTo,
+		realm:       u.Scheme + "://" + u.Host,
+		http:        &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Provider login page the user should be redirected to
+func (c *Client) AuthURL() string {
+	params := url.Values{}
+	params.Set("openid.ns", openIDNS)
+	params.Set("openid.mode", "checkid_setup")
+	params.Set("openid.return_to", c.returnTo)
+	params.Set("openid.realm", c.realm)
+	params.Set("openid.identity", identifierSelect)
+	params.Set("openid.claimed_id", identifierSelect)
+
+	return c.providerURL + "?" + params.Encode()
+}
+
+// Checks a positive assertion sent back to the return url and asks the
+// provider to confirm it. Returns the SteamID64 of the authenticated user.
+func (c *Client) Verify(params url.Values) (string, error) {
+	if params.Get("openid.mode") != "id_res" {
+		return "", ErrInvalidAssertion
+	}
+
+	if params.Get("openid.op_endpoint") != c.providerURL {
+		return "", fmt.Errorf("%w: unexpected op_endpoint", ErrInvalidAssertion)
+	}
+
+	returnTo := strings.SplitN(params.Get("openid.return_to"), "?", 2)[0]
+	if returnTo != strings.SplitN(c.returnTo, "?", 2)[0] {
+		return "", fmt.Errorf("%w: unexpected return_to", ErrInvalidAssertion)
+	}
+
+	claimedID := params.Get("openid.claimed_id")
+	if claimedID != params.Get("openid.identity") {
+		return "", fmt.Errorf("%w: identity mismatch", ErrInvalidAssertion)
+	}
+
+	match := claimedIDPattern.FindStringSubmatch(claimedID)
+	if match == nil {
+		return "", fmt.Errorf("%w: unexpected claimed_id", ErrInvalidAssertion)
+	}
+
+	if err := checkNonce(params.Get("openid.response_nonce")); err != nil {
+		return "", err
+	}
+
+	check := url.Values{}
+	for key, values := range params {
+		if strings.HasPrefix(key, "openid.") {
+			check[key] = values
+		}
+	}
+	check.Set("openid.mode", "check_authentication")
+
+	resp, err := c.http.PostForm(c.providerURL, check)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK || !isValid(string(body)) {
+		return "", fmt.Errorf("%w: rejected by provider", ErrInvalidAssertion)
+	}
+
+	return match[1], nil
+}
+
+// Looks up the persona name and avatar. Without an API key only the SteamID
+// is known.
+func (c *Client) GetProfile(steamID string) (api.SteamProfile, error) {
+	profile := api.SteamProfile{SteamID: steamID}
+	if c.apiKey == "" {
+		return profile, nil
+	}
+
+	params := url.Values{}
+	params.Set("key", c.apiKey)
+	params.Set("steamids", steamID)
+
+	resp, err := c.http.Get(c.apiURL + "/ISteamUser/GetPlayerSummaries/v2/?" + params.Encode())
+	if err != nil {
+		return profile, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return profile, fmt.Errorf("steam api returned %d", resp.StatusCode)
+	}
+
+	var summaries struct {
+		Response struct {
+			Players []struct {
+				SteamID     string `json:"steamid"`
+				PersonaName string `json:"personaname"`
+				AvatarFull  string `json:"avatarfull"`
+			} `json:"players"`
+		} `json:"response"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&summaries); err != nil {
+		return profile, err
+	}
+
+	for _, p := range summaries.Response.Players {
+		if p.SteamID == steamID {
+			profile.PersonaName = p.PersonaName
+			profile.AvatarURL = p.AvatarFull
+		}
+	}
+
+	return profile, nil
+}
+
+// Nonces start with the UTC time the assertion was issued
+func checkNonce(nonce string) error {
+	if len(nonce) < 20 {
+		return fmt.Errorf("%w: missing nonce", ErrInvalidAssertion)
+	}
+
+	issued, err := time.Parse("2006-01-02T15:04:05Z", nonce[:20])
+	if err != nil {
+		return fmt.Errorf("%w: malformed nonce", ErrInvalidAssertion)
+	}
+
+	if time.Since(issued) > maxNonceAge {
+		return fmt.Errorf("%w: assertion expired", ErrInvalidAssertion)
+	}
+
+	return nil
+}
+
+// Key-value form response, one key:value pair per line
+func isValid(body string) bool {
+	for _, line := range strings.Split(body, "\n") {
+		if strings.TrimSpace(line) == "is_valid:true" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package steam
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const (
+	testSteamID  = "76561197960287930"
+	testReturnTo = "https://csupgrade.test/auth/steam"
+)
+
+// Local stand-in for the Steam OpenID provider and Web API
+func newStubProvider(t *testing.T, valid bool) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/openid/login", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("openid.mode") != "check_authentication" {
+			http.Error(w, "unexpected mode", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "ns:http://specs.openid.net/auth/2.0\nis_valid:%t\n", valid)
+	})
+	mux.HandleFunc("/ISteamUser/GetPlayerSummaries/v2/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"response":{"players":[{"steamid":%q,"personaname":"gaben","avatarfull":"https://avatars.test/gaben.jpg"}]}}`,
+			r.URL.Query().Get("steamids"))
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func assertion(providerURL string) url.Values {
+	claimedID := "https://steamcommunity.com/openid/id/" + testSteamID
+	params := url.Values{}
+	params.Set("openid.ns", openIDNS)
+	params.Set("openid.mode", "id_res")
+	params.Set("openid.op_endpoint", providerURL)
+	params.Set("openid.claimed_id", claimedID)
+	params.Set("openid.identity", claimedID)
+	params.Set("openid.return_to", testReturnTo)
+	params.Set("openid.response_nonce", time.Now().UTC().Format("2006-01-02T15:04:05Z")+"abc")
+	params.Set("openid.assoc_handle", "1234567890")
+	params.Set("openid.signed", "signed,op_endpoint,claimed_id,identity,return_to,response_nonce,assoc_handle")
+	params.Set("openid.sig", "c2lnbmF0dXJl")
+	return params
+}
+
+func newTestClient(t *testing.T, valid bool) (*Client, string) {
+	t.Helper()
+
+	stub := newStubProvider(t, valid)
+	providerURL := stub.URL + "/openid/login"
+	client, err := NewClient(providerURL, stub.URL, "key", testReturnTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, providerURL
+}
+
+func TestVerify(t *testing.T) {
+	t.Run("accepts assertion confirmed by provider", func(t *testing.T) {
+		client, providerURL := newTestClient(t, true)
+
+		steamID, err := client.Verify(assertion(providerURL))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if steamID != testSteamID {
+			t.Errorf("expected %s, got %s", testSteamID, steamID)
+		}
+	})
+
+	t.Run("rejects assertion denied by provider", func(t *testing.T) {
+		client, providerURL := newTestClient(t, false)
+
+		if _, err := client.Verify(assertion(providerURL)); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	tampered := map[string]func(url.Values){
+		"op_endpoint": func(p url.Values) { p.Set("openid.op_endpoint", "https://evil.test/openid/login") },
+		"return_to":   func(p url.Values) { p.Set("openid.return_to", "https://evil.test/auth/steam") },
+		"identity":    func(p url.Values) { p.Set("openid.identity", "https://steamcommunity.com/openid/id/76561197960287931") },
+		"claimed_id": func(p url.Values) {
+			p.Set("openid.claimed_id", "https://evil.test/user")
+			p.Set("openid.identity", "https://evil.test/user")
+		},
+		"claimed_id host": func(p url.Values) {
+			p.Set("openid.claimed_id", "https://evil.test/openid/id/"+testSteamID)
+			p.Set("openid.identity", "https://evil.test/openid/id/"+testSteamID)
+		},
+		"stale nonce": func(p url.Values) {
+			p.Set("openid.response_nonce", time.Now().Add(-time.Hour).UTC().Format("2006-01-02T15:04:05Z")+"abc")
+		},
+	}
+
+	for name, tamper := range tampered {
+		t.Run("rejects tampered "+name, func(t *testing.T) {
+			client, providerURL := newTestClient(t, true)
+			params := assertion(providerURL)
+			tamper(params)
+
+			if _, err := client.Verify(params); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestGetProfile(t *testing.T) {
+	client, _ := newTestClient(t, true)
+
+	profile, err := client.GetProfile(testSteamID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if profile.PersonaName != "gaben" || profile.AvatarURL != "https://avatars.test/gaben.jpg" {
+		t.Errorf("unexpected profile %+v", profile)
+	}
+}
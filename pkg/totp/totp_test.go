@@ -0,0 +1,82 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B, SHA1 vectors truncated to 6 digits
+func TestCodeRFC6238(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, want := range vectors {
+		got, err := Code(secret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Errorf("at %d: expected %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	code, _ := Code(secret, Step(now))
+
+	t.Run("accepts current code", func(t *testing.T) {
+		step, ok := Validate(secret, code, now)
+		if !ok || step != Step(now) {
+			t.Fatalf("expected code to validate at step %d, got %d %v", Step(now), step, ok)
+		}
+	})
+
+	t.Run("allows one step of drift", func(t *testing.T) {
+		if _, ok := Validate(secret, code, now.Add(Period*time.Second)); !ok {
+			t.Error("expected code from previous step to validate")
+		}
+	})
+
+	t.Run("rejects old codes", func(t *testing.T) {
+		if _, ok := Validate(secret, code, now.Add(3*Period*time.Second)); ok {
+			t.Error("expected code from three steps ago to be rejected")
+		}
+	})
+
+	t.Run("rejects malformed codes", func(t *testing.T) {
+		for _, bad := range []string{"", "12345", "1234567", "abcdef"} {
+			if _, ok := Validate(secret, bad, now); ok {
+				t.Errorf("expected %q to be rejected", bad)
+			}
+		}
+	})
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("csupgrade", "test@test.com", "JBSWY3DPEHPK3PXP")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/csupgrade:test@test.com?") {
+		t.Errorf("unexpected label in %s", uri)
+	}
+
+	if !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "issuer=csupgrade") {
+		t.Errorf("missing parameters in %s", uri)
+	}
+}